@@ -0,0 +1,145 @@
+package bitcask
+
+import "bytes"
+
+// Swap stores value for key and returns the value it replaces, reading and
+// writing under a single lock so no other write comes in between. existed is
+// false and old nil if the key was absent or expired.
+func (b *Bitcask) Swap(key, value []byte) (old []byte, existed bool, err error) {
+	if err := b.checkPut(key, value); err != nil {
+		return nil, false, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, false, ErrClosed
+	}
+	if old, existed, err = b.lookup(key); err != nil {
+		return nil, false, err
+	}
+	if err := b.store(key, value); err != nil {
+		return nil, false, err
+	}
+	return old, existed, nil
+}
+
+// CompareAndSwap stores value for key only if its current value equals
+// expected, reading and writing under a single lock. A nil expected stands for
+// an absent or expired key, while an empty one matches an empty value. It
+// reports whether value was stored.
+func (b *Bitcask) CompareAndSwap(key, expected, value []byte) (bool, error) {
+	if err := b.checkPut(key, value); err != nil {
+		return false, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false, ErrClosed
+	}
+	if ok, err := b.matches(key, expected); !ok || err != nil {
+		return false, err
+	}
+	if err := b.store(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndDelete deletes key only if its current value equals expected,
+// reading and deleting under a single lock. It reports whether key was
+// deleted, which it never is if absent.
+func (b *Bitcask) CompareAndDelete(key, expected []byte) (bool, error) {
+	if b.cfg.Readonly {
+		return false, ErrReadOnlyDatabase
+	}
+	if expected == nil {
+		return false, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false, ErrClosed
+	}
+	if ok, err := b.matches(key, expected); !ok || err != nil {
+		return false, err
+	}
+	if _, err := b.putTombstone(key); err != nil {
+		return false, err
+	}
+	if err := b.maybeSync(); err != nil {
+		return false, err
+	}
+	b.remove(key)
+	return true, nil
+}
+
+// Append adds suffix to the end of the value of key, an absent or expired key
+// counting as empty, reading and writing under a single lock. It returns the
+// new value, which must not be larger than the maximum value size.
+func (b *Bitcask) Append(key, suffix []byte) ([]byte, error) {
+	if err := b.checkPut(key, suffix); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	old, _, err := b.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(old)+len(suffix)) > b.cfg.MaxValueSize {
+		return nil, ErrValueTooLarge
+	}
+	value := make([]byte, 0, len(old)+len(suffix))
+	value = append(append(value, old...), suffix...)
+	if err := b.store(key, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// matches reports whether the current value of key equals expected, nil
+// expected meaning absent. It must be called with b.mu held.
+func (b *Bitcask) matches(key, expected []byte) (bool, error) {
+	value, found, err := b.lookup(key)
+	if err != nil {
+		return false, err
+	}
+	if expected == nil {
+		return !found, nil
+	}
+	return found && bytes.Equal(value, expected), nil
+}
+
+// lookup returns the value of key and whether it exists and hasn't expired.
+// It must be called with b.mu held.
+func (b *Bitcask) lookup(key []byte) ([]byte, bool, error) {
+	item, found := b.t.Search(key)
+	if !found {
+		return nil, false, nil
+	}
+	e, err := b.read(item)
+	if err != nil {
+		return nil, false, err
+	}
+	if b.expired(e) {
+		return nil, false, nil
+	}
+	return e.Value, true, nil
+}
+
+// store writes value for key and updates the index. It must be called with
+// b.mu held for writing.
+func (b *Bitcask) store(key, value []byte) error {
+	item, err := b.put(key, value)
+	if err != nil {
+		return err
+	}
+	if err := b.maybeSync(); err != nil {
+		return err
+	}
+	b.insert(key, item)
+	return nil
+}
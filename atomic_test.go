@@ -0,0 +1,242 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSwap(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	old, existed, err := db.Swap([]byte("key"), []byte("value-1"))
+	if err != nil || existed || old != nil {
+		t.Errorf("swap absent key, want: <nil> false, got: %s %v (%v)", old, existed, err)
+	}
+	old, existed, err = db.Swap([]byte("key"), []byte("value-2"))
+	if err != nil || !existed || string(old) != "value-1" {
+		t.Errorf("swap present key, want: value-1 true, got: %s %v (%v)", old, existed, err)
+	}
+	got, err := db.Get([]byte("key"))
+	if err != nil || string(got) != "value-2" {
+		t.Errorf("get, want: %s, got: %s (%v)", "value-2", got, err)
+	}
+	if _, _, err := db.Swap(bytes.Repeat([]byte("k"), 100), []byte("value")); err != ErrKeyTooLarge {
+		t.Errorf("swap large key, want: %v, got: %v", ErrKeyTooLarge, err)
+	}
+}
+
+func TestSwapConcurrent(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	// every value written is returned by exactly one swap, or is the last one
+	const writers, swaps = 4, 50
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]int)
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < swaps; i++ {
+				old, existed, err := db.Swap([]byte("key"), []byte(fmt.Sprintf("value-%d-%d", w, i)))
+				if err != nil {
+					t.Errorf("swap error: %v", err)
+					return
+				}
+				if existed {
+					mu.Lock()
+					seen[string(old)]++
+					mu.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	last, err := db.Get([]byte("key"))
+	if err != nil {
+		t.Fatalf("get error: %v", err)
+	}
+	seen[string(last)]++
+	if len(seen) != writers*swaps {
+		t.Errorf("distinct values, want: %d, got: %d", writers*swaps, len(seen))
+	}
+	for value, n := range seen {
+		if n != 1 {
+			t.Errorf("value %s returned %d times, want once", value, n)
+		}
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name     string
+		expected []byte
+		value    []byte
+		want     bool
+		stored   string
+	}{
+		{name: "absent key, absent expected", expected: nil, value: []byte("value-1"), want: true, stored: "value-1"},
+		{name: "present key, absent expected", expected: nil, value: []byte("value-2"), want: false, stored: "value-1"},
+		{name: "non-matching", expected: []byte("value-0"), value: []byte("value-2"), want: false, stored: "value-1"},
+		{name: "empty expected", expected: []byte{}, value: []byte("value-2"), want: false, stored: "value-1"},
+		{name: "matching", expected: []byte("value-1"), value: []byte("value-2"), want: true, stored: "value-2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			swapped, err := db.CompareAndSwap([]byte("key"), test.expected, test.value)
+			if err != nil || swapped != test.want {
+				t.Errorf("compare and swap, want: %v, got: %v (%v)", test.want, swapped, err)
+			}
+			got, err := db.Get([]byte("key"))
+			if err != nil || string(got) != test.stored {
+				t.Errorf("get, want: %s, got: %s (%v)", test.stored, got, err)
+			}
+		})
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	if deleted, err := db.CompareAndDelete([]byte("key"), nil); err != nil || deleted {
+		t.Errorf("compare and delete absent key, want: false, got: %v (%v)", deleted, err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if deleted, err := db.CompareAndDelete([]byte("key"), []byte("other")); err != nil || deleted {
+		t.Errorf("compare and delete non-matching, want: false, got: %v (%v)", deleted, err)
+	}
+	if !db.Has([]byte("key")) {
+		t.Errorf("has after non-matching delete, want: true, got: false")
+	}
+	if deleted, err := db.CompareAndDelete([]byte("key"), []byte("value")); err != nil || !deleted {
+		t.Errorf("compare and delete matching, want: true, got: %v (%v)", deleted, err)
+	}
+	if db.Has([]byte("key")) {
+		t.Errorf("has after matching delete, want: false, got: true")
+	}
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("counter"), []byte("0")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+
+	// competing increments retry until their swap wins, none may be lost
+	const workers, increments = 4, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				current, err := db.Get([]byte("counter"))
+				if err != nil {
+					t.Errorf("get error: %v", err)
+					return
+				}
+				var n int
+				fmt.Sscan(string(current), &n)
+				swapped, err := db.CompareAndSwap([]byte("counter"), current, []byte(fmt.Sprint(n+1)))
+				if err != nil {
+					t.Errorf("compare and swap error: %v", err)
+					return
+				}
+				if swapped {
+					i++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := db.Get([]byte("counter"))
+	if want := fmt.Sprint(workers * increments); err != nil || string(got) != want {
+		t.Errorf("counter, want: %s, got: %s (%v)", want, got, err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxValueSize(8))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	for i, want := range []string{"a", "ab", "abc"} {
+		got, err := db.Append([]byte("key"), []byte{byte('a' + i)})
+		if err != nil || string(got) != want {
+			t.Errorf("append, want: %s, got: %s (%v)", want, got, err)
+		}
+	}
+	got, err := db.Get([]byte("key"))
+	if err != nil || string(got) != "abc" {
+		t.Errorf("get, want: %s, got: %s (%v)", "abc", got, err)
+	}
+	if _, err := db.Append([]byte("key"), []byte("defghi")); err != ErrValueTooLarge {
+		t.Errorf("append past max value size, want: %v, got: %v", ErrValueTooLarge, err)
+	}
+	if got, _ := db.Get([]byte("key")); string(got) != "abc" {
+		t.Errorf("get after failed append, want: %s, got: %s", "abc", got)
+	}
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	const workers, appends = 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < appends; i++ {
+				if _, err := db.Append([]byte("log"), []byte{byte('a' + w)}); err != nil {
+					t.Errorf("append error: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	got, err := db.Get([]byte("log"))
+	if err != nil || len(got) != workers*appends {
+		t.Fatalf("length, want: %d, got: %d (%v)", workers*appends, len(got), err)
+	}
+	for w := 0; w < workers; w++ {
+		if n := bytes.Count(got, []byte{byte('a' + w)}); n != appends {
+			t.Errorf("appends of worker %d, want: %d, got: %d", w, appends, n)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package bitcask
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"jay.com/bitcask/internal"
+)
+
+// Backup copies a consistent snapshot of the database to destPath, which can
+// then be opened with Open. Writes are blocked while the backup runs.
+func (b *Bitcask) Backup(destPath string) error {
+	return b.BackupContext(context.Background(), destPath)
+}
+
+// BackupContext is like Backup but stops between files and returns ctx.Err()
+// once ctx is done, leaving an incomplete backup in destPath.
+func (b *Bitcask) BackupContext(ctx context.Context, destPath string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if b.inMemory {
+		return ErrInMemory
+	}
+	if !b.cfg.Readonly {
+		if err := b.curr.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(destPath, b.cfg.DirModeOr(0755)); err != nil {
+		return err
+	}
+
+	fis, err := ioutil.ReadDir(b.path)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// the index on disk may be stale, it's saved from memory below
+		if fi.IsDir() || fi.Name() == "lock" || fi.Name() == "index" {
+			continue
+		}
+		if err := internal.CopyFile(filepath.Join(b.path, fi.Name()), filepath.Join(destPath, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return b.saveIndex(filepath.Join(destPath, "index"))
+}
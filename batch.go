@@ -0,0 +1,111 @@
+package bitcask
+
+import (
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/data/codec"
+)
+
+// Batch is a group of puts and deletes which Commit applies atomically,
+// concurrent readers see either none or all of them.
+type Batch struct {
+	db  *Bitcask
+	ops []batchOp
+}
+
+type batchOp struct {
+	entry  internal.Entry
+	delete bool
+}
+
+// NewBatch returns an empty batch
+func (b *Bitcask) NewBatch() *Batch {
+	return &Batch{db: b}
+}
+
+// Put adds storing key and value to the batch
+func (b *Batch) Put(key, value []byte) error {
+	if err := b.db.checkKey(key); err != nil {
+		return err
+	}
+	if uint64(len(value)) > b.db.cfg.MaxValueSize {
+		return ErrValueTooLarge
+	}
+	b.ops = append(b.ops, batchOp{entry: b.db.newEntry(key, value)})
+	return nil
+}
+
+// Delete adds deleting key to the batch
+func (b *Batch) Delete(key []byte) error {
+	if err := b.db.checkKey(key); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, batchOp{entry: b.db.newTombstone(key), delete: true})
+	return nil
+}
+
+// Commit writes all operations of the batch at once and then applies them to
+// the index under a single lock. If the write fails nothing of the batch is
+// left in the datafile, so none of it is applied, neither now nor when the
+// database is reopened.
+func (b *Batch) Commit() error {
+	db := b.db
+	if db.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if len(b.ops) == 0 {
+		return nil
+	}
+	entries := make([]internal.Entry, len(b.ops))
+	for i, op := range b.ops {
+		entries[i] = op.entry
+	}
+	items, err := db.writeMany(entries)
+	if err != nil {
+		return err
+	}
+	if err := db.maybeSync(); err != nil {
+		return err
+	}
+	for i, op := range b.ops {
+		if op.delete {
+			db.remove(op.entry.Key)
+			continue
+		}
+		db.insert(op.entry.Key, items[i])
+	}
+	b.ops = nil
+	return nil
+}
+
+// writeMany writes entries at once to the current datafile, rotating it first
+// if they don't all fit, and returns their items. A failed write leaves the
+// datafile as it was. It must be called with b.mu held for writing.
+func (b *Bitcask) writeMany(entries []internal.Entry) ([]internal.Item, error) {
+	var size int64
+	for _, e := range entries {
+		size += codec.EncodedSize(e, b.cfg.EncryptionKey != nil, b.cfg.ChecksumSize())
+	}
+	if err := b.rotateFor(size); err != nil {
+		return nil, err
+	}
+	offsets, sizes, err := b.curr.WriteMany(entries)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]internal.Item, len(entries))
+	for i, e := range entries {
+		b.size += sizes[i]
+		items[i] = internal.Item{
+			FileID:    b.curr.FileID(),
+			Offset:    offsets[i],
+			Size:      sizes[i],
+			Timestamp: e.Timestamp,
+		}
+	}
+	return items, nil
+}
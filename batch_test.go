@@ -0,0 +1,174 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/data"
+)
+
+var errWriteFailed = errors.New("write failed")
+
+// failingDatafile fails every write after the first writes ones
+type failingDatafile struct {
+	data.DataFile
+	writes int
+}
+
+func (f *failingDatafile) Write(e internal.Entry) (int64, int64, error) {
+	if f.writes == 0 {
+		return -1, 0, errWriteFailed
+	}
+	f.writes--
+	return f.DataFile.Write(e)
+}
+
+// WriteMany fails leaving the datafile as it was, like a real one, if not
+// all entries may be written
+func (f *failingDatafile) WriteMany(entries []internal.Entry) ([]int64, []int64, error) {
+	if len(entries) > f.writes {
+		f.writes = 0
+		return nil, nil, errWriteFailed
+	}
+	f.writes -= len(entries)
+	return f.DataFile.WriteMany(entries)
+}
+
+func TestBatch(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("foo"), []byte("old")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+
+	batch := db.NewBatch()
+	batch.Put([]byte("bar"), []byte("1"))
+	batch.Put([]byte("baz"), []byte("2"))
+	batch.Delete([]byte("foo"))
+	batch.Put([]byte("baz"), []byte("3"))
+	if db.Has([]byte("bar")) {
+		t.Errorf("batch applied before commit")
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("commit error: %v", err)
+	}
+
+	if db.Has([]byte("foo")) {
+		t.Errorf("deleted key foo still exists")
+	}
+	for key, want := range map[string]string{"bar": "1", "baz": "3"} {
+		got, err := db.Get([]byte(key))
+		if err != nil || !bytes.Equal(got, []byte(want)) {
+			t.Errorf("get key %s, want: %s, got: %s (%v)", key, want, got, err)
+		}
+	}
+
+	if err := batch.Put(bytes.Repeat([]byte("k"), int(DefaultMaxKeySize)+1), nil); err != ErrKeyTooLarge {
+		t.Errorf("put too large key, want: %v, got: %v", ErrKeyTooLarge, err)
+	}
+}
+
+func TestBatchWriteFailure(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("foo"), []byte("old")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+
+	batch := db.NewBatch()
+	for i := 0; i < 4; i++ {
+		batch.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("value"))
+	}
+	batch.Delete([]byte("foo"))
+
+	curr := db.curr
+	db.curr = &failingDatafile{DataFile: curr, writes: 2}
+	err = batch.Commit()
+	db.curr = curr
+	if err != errWriteFailed {
+		t.Fatalf("commit, want: %v, got: %v", errWriteFailed, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if key := []byte(fmt.Sprintf("key-%d", i)); db.Has(key) {
+			t.Errorf("key %s of failed batch exists", key)
+		}
+	}
+	got, err := db.Get([]byte("foo"))
+	if err != nil || !bytes.Equal(got, []byte("old")) {
+		t.Errorf("get key foo, want: %s, got: %s (%v)", "old", got, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// none of the batch is replayed from the datafile
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if n := db.Len(); n != 1 || !db.Has([]byte("foo")) {
+		t.Errorf("keys after reopen, want: foo, got: %d keys", n)
+	}
+}
+
+func TestDeleteAllWriteFailure(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+
+	curr := db.curr
+	db.curr = &failingDatafile{DataFile: curr, writes: 2}
+	err = db.DeleteAll()
+	db.curr = curr
+	if err != errWriteFailed {
+		t.Fatalf("delete all, want: %v, got: %v", errWriteFailed, err)
+	}
+	if db.Len() != 3 {
+		t.Errorf("keys after failed delete all, want: 3, got: %d", db.Len())
+	}
+	var want [][]byte
+	db.Scan(nil, func(key []byte) error {
+		want = append(want, key)
+		return nil
+	})
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// the datafiles agree with the index
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	var got [][]byte
+	db.Scan(nil, func(key []byte) error {
+		got = append(got, key)
+		return nil
+	})
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("keys after reopen, want: %q, got: %q", want, got)
+	}
+}
@@ -1,18 +1,20 @@
 package bitcask
 
 import (
-	"hash/crc32"
-	"io"
+	"bytes"
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
-	art "github.com/plar/go-adaptive-radix-tree"
 	"jay.com/bitcask/internal"
 	"jay.com/bitcask/internal/config"
 	"jay.com/bitcask/internal/data"
+	"jay.com/bitcask/internal/data/codec"
 	"jay.com/bitcask/internal/index"
 )
 
@@ -24,6 +26,10 @@ var (
 	// maximum allowed key size (configured with WithMaxKeySize).
 	ErrKeyTooLarge = errors.New("error: key too large")
 
+	// ErrEmptyKey is the error returned when writing an empty key, which
+	// can't be told apart from a corrupt entry when read back
+	ErrEmptyKey = errors.New("error: empty key")
+
 	// ErrValueTooLarge is the error returned for a value that exceeds the
 	// maximum allowed value size (configured with WithMaxValueSize).
 	ErrValueTooLarge = errors.New("error: value too large")
@@ -31,281 +37,1599 @@ var (
 	// ErrChecksumFailed is the error returned if a key/value retrieved does
 	// not match its CRC checksum
 	ErrChecksumFailed = errors.New("error: checksum failed")
+
+	// ErrIncompatibleVersion is the error returned when opening a database
+	// written with a different on-disk format version
+	ErrIncompatibleVersion = errors.New("error: incompatible format version")
+
+	// ErrDatabaseLocked is the error returned when the database is already
+	// opened by another process
+	ErrDatabaseLocked = errors.New("error: database locked")
+
+	// ErrChecksumModeMismatch is the error returned when opening a database
+	// with a checksum mode other than the one it was created with
+	ErrChecksumModeMismatch = errors.New("error: checksum mode mismatch")
+
+	// ErrConfigMismatch is the error returned when an option conflicts with
+	// the configuration persisted with the database, see WithForceConfig
+	ErrConfigMismatch = errors.New("error: config mismatch")
+
+	// ErrReadOnlyDatabase is the error returned when modifying a database
+	// opened with WithReadonly
+	ErrReadOnlyDatabase = errors.New("error: read only database")
+
+	// ErrEncryptionKeyRequired is the error returned when opening an
+	// encrypted database without WithEncryption
+	ErrEncryptionKeyRequired = errors.New("error: encryption key required")
+
+	// ErrWrongEncryptionKey is the error returned when opening an encrypted
+	// database with a key other than the one it was created with
+	ErrWrongEncryptionKey = errors.New("error: wrong encryption key")
+
+	// ErrDatafileMissing is the error returned when the index refers to a
+	// datafile that doesn't exist, e.g. because the index is stale
+	ErrDatafileMissing = errors.New("error: datafile missing")
+
+	// ErrTxnClosed is the error returned when using a transaction after
+	// Commit or Rollback
+	ErrTxnClosed = errors.New("error: transaction closed")
+
+	// ErrTxnConflict is the error returned when committing a transaction
+	// writing a key committed by others since the transaction began
+	ErrTxnConflict = errors.New("error: transaction conflict")
+
+	// ErrTxnsOpen is the error returned by Merge while transactions are open,
+	// their snapshots may refer to the entries a merge would drop
+	ErrTxnsOpen = errors.New("error: transactions open")
+
+	// ErrClosed is the error returned when using a database after Close
+	ErrClosed = errors.New("error: database closed")
+
+	// ErrOpenTimeout is the error returned by Open when loading the database
+	// takes longer than WithOpenTimeout allows
+	ErrOpenTimeout = errors.New("error: open timeout")
+
+	// ErrCorruptSize is the error returned when the size of an entry is
+	// larger than the max key and value sizes allow, e.g. because the index
+	// is corrupt
+	ErrCorruptSize = codec.ErrCorruptSize
+
+	// ErrInMemory is the error returned by the operations an in-memory
+	// database doesn't support, see OpenInMemory
+	ErrInMemory = errors.New("error: not supported in memory")
+
+	// ErrInvalidNamespace is the error returned by Namespace for an empty
+	// name or one containing NamespaceSeparator
+	ErrInvalidNamespace = errors.New("error: invalid namespace")
 )
 
+// IOError is the error of a failed operation on a datafile, telling which
+// datafile and offset it involved. Errors returned by the database wrap it,
+// see AsIOError.
+type IOError = data.IOError
+
+// AsIOError returns the IOError err wraps, if any
+func AsIOError(err error) (*IOError, bool) {
+	return data.AsIOError(err)
+}
+
 // Bitcask is a struct that represents a on-disk LSM and WAL data structure
 // and in-memory hash of key/value pairs as per the Bitcask paper and seen
 // in the Riak database.
 type Bitcask struct {
-	mu        sync.Mutex
+	mu        sync.RWMutex
+	flock     *os.File
 	options   []Option
 	cfg       *config.Config
 	path      string
 	curr      data.DataFile
 	datafiles map[int]data.DataFile
+	pool      *data.Pool
+	blooms    map[int]*index.Bloom
 	indexer   index.Indexer
-	t         art.Tree
+	t         index.Tree
+	clock     func() time.Time
+	done      chan struct{}
+	wg        sync.WaitGroup
+	txns      map[*Txn]struct{}
+	// keys and size count the keys in t and the bytes in all datafiles, kept
+	// up to date under mu
+	keys int
+	size int64
+	// closed is set under mu by Close
+	closed bool
+	// inMemory is set by OpenInMemory
+	inMemory bool
+	// subs are the subscriptions of Subscribe, guarded by mu
+	subs map[*subscription]struct{}
 }
 
 // Open opens the database at the given path with optional options.
 // Options can be provided with the `WithXXX` functions that provide
 // configuration options as functions.
-func Open(path string, options ...Option) (*Bitcask, error) {
-	var (
-		cfg *config.Config
-		err error
-	)
-	if err = os.MkdirAll(path, 0755); err != nil {
-		return nil, err
-	}
-
+func Open(path string, options ...Option) (_ *Bitcask, err error) {
+	var cfg *config.Config
 	configPath := filepath.Join(path, "config.json")
-	if internal.Exists(configPath) {
+	persisted := internal.Exists(configPath)
+	if persisted {
 		if cfg, err = config.Load(configPath); err != nil {
 			return nil, err
 		}
+		if cfg.Version < codec.MinVersion || cfg.Version > codec.Version {
+			return nil, ErrIncompatibleVersion
+		}
 	} else {
 		cfg = newDefaultConfig()
 	}
+	prev := *cfg
 
 	bitcask := &Bitcask{
 		options: options,
 		cfg:     cfg,
 		path:    path,
-		indexer: index.NewIndexer(),
+		clock:   time.Now,
+		done:    make(chan struct{}),
+		txns:    make(map[*Txn]struct{}),
 	}
 
-	for _, opt := range options {
-		if err = opt(cfg); err != nil {
+	if err = applyOptions(cfg, options); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(path, cfg.DirModeOr(0755)); err != nil {
+		return nil, err
+	}
+	if cfg.MaxOpenFiles > 0 {
+		bitcask.pool = data.NewPool(cfg.MaxOpenFiles)
+	}
+	bitcask.indexer = index.NewIndexer(cfg.Logger, cfg.FileModeOr(0600))
+	if persisted {
+		if err = checkConfig(&prev, cfg); err != nil {
 			return nil, err
 		}
 	}
-	if err = cfg.Save(configPath); err != nil {
-		return nil, err
+
+	// a read-only database may be opened alongside the process writing it
+	if !cfg.Readonly {
+		bitcask.flock, err = internal.Flock(filepath.Join(path, "lock"))
+		if err != nil {
+			if err == internal.ErrLocked {
+				return nil, ErrDatabaseLocked
+			}
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				bitcask.flock.Close()
+			}
+		}()
+		if err = cfg.Save(configPath); err != nil {
+			return nil, err
+		}
+		if err = os.RemoveAll(filepath.Join(path, mergeDir)); err != nil {
+			return nil, err
+		}
+		if cfg.TempDir != "" {
+			if err = checkTempDir(cfg.TempDir, path); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.RepairOnOpen {
+			err = repairDatafiles(path, cfg)
+		} else {
+			err = repairTail(path, cfg)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if err = bitcask.reopen(); err != nil {
+	if err = bitcask.reopenWithin(cfg.OpenTimeout); err != nil {
 		return nil, err
 	}
 
+	if cfg.AutoMergeThreshold > 0 && !cfg.Readonly {
+		bitcask.wg.Add(1)
+		go bitcask.autoMerge(cfg.AutoMergeThreshold)
+	}
+	if cfg.SyncInterval > 0 && !cfg.Readonly {
+		bitcask.wg.Add(1)
+		go bitcask.syncEvery(cfg.SyncInterval)
+	}
+
 	return bitcask, nil
 }
 
+// applyOptions applies options to cfg, fills in the defaults of the fields
+// they left unset and validates the result
+func applyOptions(cfg *config.Config, options []Option) error {
+	for _, opt := range options {
+		if err := opt(cfg); err != nil {
+			return err
+		}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = internal.DiscardLogger
+	}
+	if cfg.Metrics == nil {
+		cfg.Metrics = internal.DiscardMetrics
+	}
+	if cfg.Index == "" {
+		cfg.Index = IndexART
+	}
+	return cfg.Validate()
+}
+
+// checkConfig returns an error if cfg, the persisted config prev with options
+// applied, can't read the data written with prev.
+func checkConfig(prev, cfg *config.Config) error {
+	if cfg.EncryptionCheck != prev.EncryptionCheck {
+		if prev.EncryptionCheck == "" {
+			return errors.Wrap(ErrConfigMismatch, "encryption can't be enabled on an existing database")
+		}
+		return ErrWrongEncryptionKey
+	}
+	if cfg.EncryptionCheck != "" && cfg.EncryptionKey == nil {
+		return ErrEncryptionKeyRequired
+	}
+	if cfg.ChecksumMode != prev.ChecksumMode {
+		return ErrChecksumModeMismatch
+	}
+	if cfg.Checksum != prev.Checksum {
+		return errors.Wrapf(ErrConfigMismatch, "checksum %q differs from persisted %q", cfg.Checksum, prev.Checksum)
+	}
+	if cfg.DatafileFormat != prev.DatafileFormat {
+		return errors.Wrapf(ErrConfigMismatch, "datafile format %q differs from persisted %q", cfg.DatafileFormat, prev.DatafileFormat)
+	}
+	// a different max datafile size only changes when datafiles rotate
+	if cfg.ForceConfig {
+		return nil
+	}
+	if cfg.MaxKeySize < prev.MaxKeySize {
+		return errors.Wrapf(ErrConfigMismatch, "max key size %d is smaller than persisted %d", cfg.MaxKeySize, prev.MaxKeySize)
+	}
+	if cfg.MaxValueSize < prev.MaxValueSize {
+		return errors.Wrapf(ErrConfigMismatch, "max value size %d is smaller than persisted %d", cfg.MaxValueSize, prev.MaxValueSize)
+	}
+	return nil
+}
+
 func (b *Bitcask) reopen() error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	datafiles, lastID, err := loadDatafiles(b.path, b.cfg.MaxKeySize, b.cfg.MaxValueSize)
+	return b.openFiles()
+}
+
+// openFiles opens the datafiles and loads the index, it must be called with
+// b.mu held for writing.
+func (b *Bitcask) openFiles() error {
+	datafiles, lastID, err := loadDatafiles(b.path, b.cfg, b.pool)
 	if err != nil {
 		return err
 	}
-	t, err := loadIndex(b.path, b.indexer, b.cfg.MaxKeySize, datafiles)
+	t, err := loadIndex(b.path, b.indexer, b.cfg, datafiles)
 	if err != nil {
+		for _, df := range datafiles {
+			df.Close()
+		}
 		return err
 	}
-	curr, err := data.NewDatafile(b.path, lastID, false, b.cfg.MaxKeySize, b.cfg.MaxValueSize)
-	if err != nil {
-		return err
+	// writes go to a fresh datafile after the existing ones, a read-only
+	// database uses the last datafile as its current one
+	var curr data.DataFile
+	if b.cfg.Readonly && datafiles[lastID] != nil {
+		curr = datafiles[lastID]
+		delete(datafiles, lastID)
+	} else {
+		id := lastID
+		if len(datafiles) > 0 {
+			id++
+		}
+		curr, err = b.openDatafile(id, b.cfg.Readonly)
+		if err != nil {
+			return err
+		}
+	}
+	blooms := make(map[int]*index.Bloom)
+	if b.cfg.BloomFilter {
+		for id, df := range datafiles {
+			bloom, err := index.LoadBloom(internal.BloomPath(df.Name()))
+			if err == nil {
+				blooms[id] = bloom
+			} else if !os.IsNotExist(err) {
+				b.cfg.Logger.Printf("ignoring bloom filter of datafile %d: %v", id, err)
+			}
+		}
 	}
 	b.curr = curr
 	b.datafiles = datafiles
+	b.blooms = blooms
 	b.t = t
+	b.keys = t.Size()
+	b.size = curr.Size()
+	for _, df := range datafiles {
+		b.size += df.Size()
+	}
+	return nil
+}
+
+// reopenWithin is like reopen but gives up with ErrOpenTimeout after timeout,
+// if not zero. The datafiles opened by a reopen given up on are closed once it
+// completes.
+func (b *Bitcask) reopenWithin(timeout time.Duration) error {
+	if timeout == 0 {
+		return b.reopen()
+	}
+	var (
+		mu       sync.Mutex
+		timedOut bool
+		result   = make(chan error, 1)
+	)
+	go func() {
+		err := b.reopen()
+		mu.Lock()
+		defer mu.Unlock()
+		if timedOut {
+			if err == nil {
+				b.closeDatafiles()
+			}
+			return
+		}
+		result <- err
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// the reopen may have completed while timing out
+	select {
+	case err := <-result:
+		return err
+	default:
+		timedOut = true
+		b.cfg.Logger.Printf("gave up loading the database after %s", timeout)
+		return ErrOpenTimeout
+	}
+}
+
+// closeDatafiles closes all datafiles, removing the current datafile if
+// nothing was written to it
+func (b *Bitcask) closeDatafiles() error {
+	for _, df := range b.datafiles {
+		if err := df.Close(); err != nil {
+			return err
+		}
+	}
+	if err := b.curr.Close(); err != nil {
+		return err
+	}
+	if !b.cfg.Readonly && b.curr.Size() == 0 {
+		return os.Remove(b.curr.Name())
+	}
 	return nil
 }
 
 // Put store key and value in database
 // TODO(jay) check whether key exists
 func (b *Bitcask) Put(key, value []byte) error {
-	if uint32(len(key)) > b.cfg.MaxKeySize {
-		return ErrKeyTooLarge
+	return b.set(b.newEntry(key, value))
+}
+
+// PutWithTTL store key and value in database, the key expires and is no
+// longer found once ttl has elapsed
+func (b *Bitcask) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	e := b.newEntry(key, value)
+	e.Expiry = b.clock().Add(ttl).UnixNano()
+	return b.set(e)
+}
+
+func (b *Bitcask) set(e internal.Entry) (err error) {
+	if b.cfg.Metrics != internal.DiscardMetrics {
+		defer func(start time.Time) {
+			if err == nil {
+				b.cfg.Metrics.RecordPut(time.Since(start))
+			}
+		}(time.Now())
 	}
-	if uint64(len(value)) > b.cfg.MaxValueSize {
-		return ErrValueTooLarge
+	if err := b.checkPut(e.Key, e.Value); err != nil {
+		return err
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	offset, n, err := b.put(key, value)
+	if b.closed {
+		return ErrClosed
+	}
+	item, err := b.write(e)
 	if err != nil {
 		return err
 	}
-	item := internal.Item{
-		FileID: b.curr.FileID(),
-		Offset: offset,
-		Size:   n,
+	if err := b.maybeSync(); err != nil {
+		return err
 	}
-	b.t.Insert(key, item)
+	b.insert(e.Key, item)
 	return nil
 }
 
 // Get retrieves the value of the given key. If the key is not found or an IO
-// error occurs a null byte slice is returned along with the error.
+// error occurs a null byte slice is returned along with the error. The value
+// is a copy the caller owns, at the cost of one allocation per call, so it can
+// be retained and modified freely.
 func (b *Bitcask) Get(key []byte) ([]byte, error) {
-	b.mu.Lock()
-	value, found := b.t.Search(key)
+	value, _, err := b.get(key)
+	return value, err
+}
+
+// Meta is where and when the entry holding the value of a key was written,
+// Size being that of the whole encoded entry
+type Meta struct {
+	FileID    int
+	Offset    int64
+	Size      int64
+	Timestamp int64
+}
+
+// GetWithMeta is like Get but also returns the metadata of the key's entry.
+// Timestamp is zero for entries loaded from a version 1 index or hint file.
+func (b *Bitcask) GetWithMeta(key []byte) ([]byte, Meta, error) {
+	value, item, err := b.get(key)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	return value, Meta{FileID: item.FileID, Offset: item.Offset, Size: item.Size, Timestamp: item.Timestamp}, nil
+}
+
+// get returns a copy of the value of key along with its item
+func (b *Bitcask) get(key []byte) (_ []byte, _ internal.Item, err error) {
+	if b.cfg.Metrics != internal.DiscardMetrics {
+		defer func(start time.Time) {
+			if err == nil || err == ErrKeyNotFound {
+				b.cfg.Metrics.RecordGet(err == nil, time.Since(start))
+			}
+		}(time.Now())
+	}
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return nil, internal.Item{}, ErrClosed
+	}
+	item, found := b.t.Search(key)
 	if !found {
-		b.mu.Unlock()
-		return nil, ErrKeyNotFound
+		b.mu.RUnlock()
+		return nil, internal.Item{}, ErrKeyNotFound
 	}
-	item := value.(internal.Item)
 
-	var df data.DataFile
-	if item.FileID == b.curr.FileID() {
-		df = b.curr
-	} else {
-		df = b.datafiles[item.FileID]
+	df, err := b.datafile(item.FileID)
+	if err != nil {
+		b.mu.RUnlock()
+		return nil, internal.Item{}, err
 	}
 	e, err := df.ReadAt(item.Offset, item.Size)
-	b.mu.Unlock()
+	b.mu.RUnlock()
 	if err != nil {
-		return nil, err
+		return nil, internal.Item{}, err
 	}
-	checksum := crc32.ChecksumIEEE(e.Value)
+	checksum := internal.Checksum(b.cfg.ChecksumMode, b.cfg.Checksum, e.Key, e.Value)
 	if checksum != e.Checksum {
-		return nil, ErrChecksumFailed
+		switch onCorruption(b.cfg, key, item.FileID, ErrChecksumFailed, ActionAbort) {
+		case ActionSkip:
+			return nil, internal.Item{}, ErrKeyNotFound
+		case ActionDelete:
+			if err := b.deleteCorrupt(key, item); err != nil {
+				return nil, internal.Item{}, err
+			}
+			return nil, internal.Item{}, ErrKeyNotFound
+		}
+		return nil, internal.Item{}, ErrChecksumFailed
+	}
+	if b.expired(e) {
+		return nil, internal.Item{}, ErrKeyNotFound
+	}
+	value := make([]byte, len(e.Value))
+	copy(value, e.Value)
+	return value, item, nil
+}
+
+// GetFrom returns the id of the datafile holding the value of the given key.
+// It fails if the bloom filter of that datafile rules the key out, which
+// means the index and the datafile disagree.
+func (b *Bitcask) GetFrom(key []byte) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return 0, ErrClosed
 	}
-	return e.Value, nil
+	item, found := b.t.Search(key)
+	if !found {
+		return 0, ErrKeyNotFound
+	}
+	if !b.mayContain(item.FileID, key) {
+		return 0, errors.Errorf("key not in bloom filter of datafile %d", item.FileID)
+	}
+	return item.FileID, nil
 }
 
-// Has return the true if key exists in database, false otherwise
+// Has return the true if key exists in database, false otherwise or once the
+// database is closed
 func (b *Bitcask) Has(key []byte) bool {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return false
+	}
 	_, found := b.t.Search(key)
 	return found
 }
 
-// Delete delete the named key, if key not found or an IO error
-// occurs the error is returned
-func (b *Bitcask) Delete(key []byte) error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	_, _, err := b.put(key, []byte{})
-	if err != nil {
-		return err
+// LastModified returns the time the value of key was last written. Like Has
+// it doesn't tell whether key expired.
+func (b *Bitcask) LastModified(key []byte) (time.Time, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return time.Time{}, ErrClosed
 	}
-	b.t.Delete(key)
-	return nil
+	item, found := b.t.Search(key)
+	if !found {
+		return time.Time{}, ErrKeyNotFound
+	}
+	// items loaded from version 1 index and hint files have no timestamp
+	if item.Timestamp == 0 {
+		e, err := b.read(item)
+		if err != nil {
+			return time.Time{}, err
+		}
+		item.Timestamp = e.Timestamp
+	}
+	return time.Unix(0, item.Timestamp), nil
 }
 
-// DeleteAll delete all keys in the database. If an I/O error occurs the error is returned.
-func (b *Bitcask) DeleteAll() (err error) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	b.t.ForEach(func(node art.Node) (cont bool) {
-		_, _, err = b.put(node.Key(), []byte{})
-		if err != nil {
+// Scan calls fn for every key with the given prefix in lexicographic order.
+// An empty prefix matches all keys. If fn returns an error the scan stops and
+// the error is returned.
+func (b *Bitcask) Scan(prefix []byte, fn func(key []byte) error) error {
+	return b.ScanContext(context.Background(), prefix, fn)
+}
+
+// ScanContext is like Scan but stops and returns ctx.Err() once ctx is done.
+func (b *Bitcask) ScanContext(ctx context.Context, prefix []byte, fn func(key []byte) error) (err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	b.t.ForEachPrefix(prefix, func(key []byte, _ internal.Item) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		if err = fn(key); err != nil {
 			return false
 		}
 		return true
 	})
-	b.t = art.New()
 	return
 }
 
-// Len return the total number of keys in database
-func (b *Bitcask) Len() int {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	return b.t.Size()
+// ScanReverse is like Scan but calls fn in reverse lexicographic order. The
+// keys are collected when ScanReverse is called, fn is called without the
+// database locked.
+func (b *Bitcask) ScanReverse(prefix []byte, fn func(key []byte) error) error {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrClosed
+	}
+	var keys [][]byte
+	b.t.ForEachPrefix(prefix, func(key []byte, _ internal.Item) bool {
+		keys = append(keys, key)
+		return true
+	})
+	b.mu.RUnlock()
+
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := fn(keys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Sync flushes all buffers to disk ensuring all data is writing
-func (b *Bitcask) Sync() error {
-	return b.curr.Sync()
+// Keys returns a channel streaming all keys in lexicographic order. The keys
+// are a point-in-time snapshot taken when Keys is called, later writes are
+// not reflected. The channel must be drained to release its goroutine. No
+// keys are sent once the database is closed.
+func (b *Bitcask) Keys() chan []byte {
+	b.mu.RLock()
+	var keys [][]byte
+	if !b.closed {
+		keys = make([][]byte, 0, b.t.Size())
+		b.t.ForEach(func(key []byte, _ internal.Item) bool {
+			keys = append(keys, key)
+			return true
+		})
+	}
+	b.mu.RUnlock()
+
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		for _, key := range keys {
+			ch <- key
+		}
+	}()
+	return ch
 }
 
-// Close close the database
-func (b *Bitcask) Close() error {
-	if err := b.indexer.Save(b.t, filepath.Join(b.path, "index")); err != nil {
-		return err
+// Fold calls fn with every key and its value in key order, verifying each
+// value's checksum like Get does and skipping expired keys. If fn returns an
+// error the iteration stops and the error is returned. fn must not retain
+// value after it returns, the backing buffer may be reused.
+func (b *Bitcask) Fold(fn func(key, value []byte) error) error {
+	return b.FoldContext(context.Background(), fn)
+}
+
+// FoldContext is like Fold but stops and returns ctx.Err() once ctx is done.
+func (b *Bitcask) FoldContext(ctx context.Context, fn func(key, value []byte) error) (err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
 	}
-	for _, f := range b.datafiles {
-		err := f.Close()
+	b.t.ForEach(func(key []byte, item internal.Item) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		var e internal.Entry
+		e, err = b.read(item)
 		if err != nil {
-			return err
+			return false
 		}
-	}
-	return b.curr.Close()
+		if b.expired(e) {
+			return true
+		}
+		err = fn(e.Key, e.Value)
+		return err == nil
+	})
+	return
 }
 
-func (b *Bitcask) put(key, value []byte) (int64, int64, error) {
-	size := b.curr.Size()
-	// TODO make new datafile
-	if size > int64(b.cfg.MaxDatafileSize) {
-		b.curr.Close()
-		id := b.curr.FileID()
-		datafile, err := data.NewDatafile(b.path, id, true, b.cfg.MaxKeySize, b.cfg.MaxValueSize)
+// Range calls fn with every key in [start, end) and its value in key order,
+// reading values like Fold does. A nil start or end leaves the range open on
+// that side. If fn returns an error the iteration stops and the error is
+// returned.
+func (b *Bitcask) Range(start, end []byte, fn func(key, value []byte) error) (err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	// the index transforms start itself, end is compared with the keys as
+	// it holds them
+	if end != nil {
+		end = b.indexKey(end)
+	}
+	b.t.ForEachFrom(start, func(key []byte, item internal.Item) bool {
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			return false
+		}
+		var e internal.Entry
+		e, err = b.read(item)
 		if err != nil {
-			return -1, 0, err
+			return false
 		}
-		b.datafiles[id] = datafile
+		if b.expired(e) {
+			return true
+		}
+		err = fn(e.Key, e.Value)
+		return err == nil
+	})
+	return
+}
 
-		datafile, err = data.NewDatafile(b.path, id+1, false, b.cfg.MaxKeySize, b.cfg.MaxValueSize)
-		if err != nil {
-			return -1, 0, err
+// Delete delete the named key, if an IO error occurs the error is returned.
+// A key not found is deleted all the same, writing a tombstone, unless
+// WithStrictDelete is set, then ErrKeyNotFound is returned.
+func (b *Bitcask) Delete(key []byte) (err error) {
+	if b.cfg.Metrics != internal.DiscardMetrics {
+		defer func(start time.Time) {
+			if err == nil {
+				b.cfg.Metrics.RecordDelete(time.Since(start))
+			}
+		}(time.Now())
+	}
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	if err := b.checkKey(key); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if b.cfg.StrictDelete {
+		if _, found := b.t.Search(key); !found {
+			return ErrKeyNotFound
 		}
-		b.curr = datafile
 	}
-	e := internal.NewEntry(key, value)
-	return b.curr.Write(e)
+	if _, err := b.putTombstone(key); err != nil {
+		return err
+	}
+	if err := b.maybeSync(); err != nil {
+		return err
+	}
+	b.remove(key)
+	return nil
 }
 
-func loadDatafiles(path string, maxKeySize uint32, maxValueSize uint64) (datafiles map[int]data.DataFile, lastID int, err error) {
-	fns, err := internal.GetDatafiles(path)
-	if err != nil {
-		return nil, 0, err
+// DeleteAll delete all keys in the database. If an I/O error occurs the error
+// is returned and only the keys whose tombstones were written are deleted, so
+// the index stays consistent with the datafiles. DropAll deletes all keys
+// without writing tombstones.
+func (b *Bitcask) DeleteAll() error {
+	return b.DeleteAllContext(context.Background())
+}
+
+// DeleteAllContext is like DeleteAll but stops and returns ctx.Err() once ctx
+// is done, keeping the keys not deleted yet.
+func (b *Bitcask) DeleteAllContext(ctx context.Context) error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
 	}
-	ids, err := internal.ParseIds(fns)
-	if err != nil {
-		return nil, 0, err
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
 	}
-	datafiles = make(map[int]data.DataFile)
-	for _, id := range ids {
-		file, err := data.NewDatafile(path, id, true, maxKeySize, maxValueSize)
-		if err != nil {
-			return nil, 0, err
+	keys := make([][]byte, 0, b.t.Size())
+	b.t.ForEach(func(key []byte, _ internal.Item) bool {
+		keys = append(keys, key)
+		return true
+	})
+	_, err := b.deleteKeys(ctx, keys)
+	return err
+}
+
+// DeletePrefix deletes every key with the given prefix and returns the number
+// of keys deleted. Like Scan an empty prefix matches all keys. Readers see
+// either all or none of the keys deleted, unless an I/O error occurs, then
+// only the keys whose tombstones were written are deleted.
+func (b *Bitcask) DeletePrefix(prefix []byte) (int, error) {
+	if b.cfg.Readonly {
+		return 0, ErrReadOnlyDatabase
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosed
+	}
+	var keys [][]byte
+	b.t.ForEachPrefix(prefix, func(key []byte, _ internal.Item) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return b.deleteKeys(context.Background(), keys)
+}
+
+// deleteKeys writes tombstones for keys and then removes them from the index.
+// If a write fails or ctx is done only the keys whose tombstones were written
+// are removed. It must be called with b.mu held for writing.
+func (b *Bitcask) deleteKeys(ctx context.Context, keys [][]byte) (deleted int, err error) {
+	for _, key := range keys {
+		if err = ctx.Err(); err != nil {
+			break
 		}
-		datafiles[id] = file
+		if _, err = b.putTombstone(key); err != nil {
+			break
+		}
+		deleted++
 	}
-	if len(ids) > 0 {
-		lastID = ids[len(ids)-1]
+	if err == nil {
+		err = b.maybeSync()
 	}
-	return
+	for _, key := range keys[:deleted] {
+		b.remove(key)
+	}
+	return deleted, err
 }
 
-func loadIndex(path string, indexer index.Indexer, maxKeySize uint32, datafles map[int]data.DataFile) (art.Tree, error) {
-	t, found, err := indexer.Load(filepath.Join(path, "index"), maxKeySize)
-	if err != nil {
-		return nil, err
+// Rename atomically moves the value of oldKey, and its expiry, to newKey,
+// replacing any value of newKey. Readers see either oldKey or newKey, never
+// both or neither. ErrKeyNotFound is returned if oldKey doesn't exist.
+func (b *Bitcask) Rename(oldKey, newKey []byte) error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
 	}
-	if !found {
-		sortedDatafiles := getSortedDatafiles(datafles)
-		var offset int64
-		for _, f := range sortedDatafiles {
-			e, n, err := f.Read()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return nil, err
+	if err := b.checkKey(newKey); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	item, found := b.t.Search(oldKey)
+	if !found {
+		return ErrKeyNotFound
+	}
+	e, err := b.read(item)
+	if err != nil {
+		return err
+	}
+	if b.expired(e) {
+		return ErrKeyNotFound
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return nil
+	}
+
+	renamed := b.newEntry(newKey, e.Value)
+	renamed.Expiry = e.Expiry
+	if item, err = b.write(renamed); err != nil {
+		return err
+	}
+	if _, err := b.putTombstone(oldKey); err != nil {
+		return err
+	}
+	if err := b.maybeSync(); err != nil {
+		return err
+	}
+	b.insert(newKey, item)
+	b.remove(oldKey)
+	return nil
+}
+
+// Len return the total number of keys in database, zero once it is closed
+func (b *Bitcask) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return 0
+	}
+	return b.t.Size()
+}
+
+// Count returns the number of keys with the given prefix without collecting
+// them, all keys for an empty prefix. It returns zero once the database is
+// closed.
+func (b *Bitcask) Count(prefix []byte) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return 0
+	}
+	if len(prefix) == 0 {
+		return b.t.Size()
+	}
+	n := 0
+	b.t.ForEachPrefix(prefix, func([]byte, internal.Item) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Sync flushes all buffers to disk ensuring all data is writing, along with
+// the database directory so that new datafiles survive a crash
+func (b *Bitcask) Sync() error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if err := b.curr.Flush(); err != nil {
+		return err
+	}
+	if err := b.curr.Sync(); err != nil {
+		return err
+	}
+	if b.inMemory {
+		return nil
+	}
+	return internal.FsyncDir(b.path)
+}
+
+// Flush writes the entries buffered by WithWriteBuffer to the current
+// datafile without syncing it, making them visible to other readers of the
+// file but not durable.
+func (b *Bitcask) Flush() error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	return b.curr.Flush()
+}
+
+// Merge rewrites the live entries of all read-only datafiles into fresh
+// datafiles and removes the old ones, reclaiming the space taken by
+// overwritten values, tombstones and expired entries. The database is locked
+// while merging.
+func (b *Bitcask) Merge() error {
+	return b.MergeContext(context.Background())
+}
+
+// MergeContext is like Merge but stops and returns ctx.Err() once ctx is done,
+// leaving the database as it was.
+func (b *Bitcask) MergeContext(ctx context.Context) error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if len(b.txns) > 0 {
+		return ErrTxnsOpen
+	}
+
+	// make the current datafile read-only so its entries are merged too and
+	// the rewritten entries land in datafiles newer than all merged ones
+	if b.curr.Size() > 0 {
+		if err := b.rotate(); err != nil {
+			return err
+		}
+	}
+	merged := make(map[int]bool)
+	for id := range b.datafiles {
+		if id != b.curr.FileID() {
+			merged[id] = true
+		}
+	}
+	return b.merge(ctx, merged)
+}
+
+// MergeFiles merges only the maxFiles oldest read-only datafiles, rewriting
+// their live entries into new datafiles and leaving the other datafiles
+// untouched. It bounds the time the database is locked, at the cost of
+// reclaiming less space than Merge.
+func (b *Bitcask) MergeFiles(maxFiles int) error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if len(b.txns) > 0 {
+		return ErrTxnsOpen
+	}
+	ids := make([]int, 0, len(b.datafiles))
+	for id := range b.datafiles {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	merged := make(map[int]bool)
+	for i := 0; i < len(ids) && i < maxFiles; i++ {
+		merged[ids[i]] = true
+	}
+	return b.merge(context.Background(), merged)
+}
+
+// Close close the database, later calls do nothing. Once it is closed the
+// methods reading or writing keys return ErrClosed, those without an error
+// return nothing found.
+func (b *Bitcask) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.closeSubscriptions()
+	b.mu.Unlock()
+	close(b.done)
+	b.wg.Wait()
+	if b.inMemory {
+		return nil
+	}
+	if !b.cfg.Readonly {
+		defer func() {
+			os.Remove(b.flock.Name())
+			b.flock.Close()
+		}()
+		if b.curr.Size() > 0 {
+			// the hint is written from the datafile on disk
+			if err := b.curr.Sync(); err != nil {
+				return err
 			}
-			//tombstome
-			if len(e.Value) == 0 {
-				t.Delete(e.Key)
-				offset += n
+			if err := b.writeHint(b.curr.FileID()); err != nil {
+				return err
+			}
+		}
+		// saved after the datafiles are written so it isn't taken as stale
+		if err := b.saveIndex(filepath.Join(b.path, "index")); err != nil {
+			return err
+		}
+	}
+	for _, f := range b.datafiles {
+		err := f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := b.curr.Close(); err != nil {
+		return err
+	}
+	// every open starts a new datafile, don't leave it behind if unused
+	if !b.cfg.Readonly && b.curr.Size() == 0 {
+		return os.Remove(b.curr.Name())
+	}
+	return nil
+}
+
+// autoMerge periodically merges the database once the share of reclaimable
+// space exceeds threshold, until the database is closed.
+func (b *Bitcask) autoMerge(threshold float64) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(autoMergeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			stats, err := b.Stats(false)
+			if err != nil || stats.TotalSize == 0 {
 				continue
 			}
-			item := internal.Item{
-				FileID: f.FileID(),
-				Offset: offset,
-				Size:   n,
+			if float64(stats.ReclaimableSize)/float64(stats.TotalSize) > threshold {
+				// a failed merge leaves the database intact, retry next tick
+				b.Merge()
+			}
+		}
+	}
+}
+
+// syncEvery syncs the current datafile every interval until the database is
+// closed.
+func (b *Bitcask) syncEvery(interval time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.mu.RLock()
+			if !b.closed {
+				if err := b.curr.Sync(); err != nil {
+					b.cfg.Logger.Printf("syncing datafile %d: %v", b.curr.FileID(), err)
+				}
+			}
+			b.mu.RUnlock()
+		}
+	}
+}
+
+// maybeSync syncs the current datafile if the database syncs on every write.
+func (b *Bitcask) maybeSync() error {
+	if !b.cfg.Sync {
+		return nil
+	}
+	return b.curr.Sync()
+}
+
+// checkPut returns the error of a put of key and value exceeding the limits
+// of the database or to a read-only one.
+func (b *Bitcask) checkPut(key, value []byte) error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	if err := b.checkKey(key); err != nil {
+		return err
+	}
+	if uint64(len(value)) > b.cfg.MaxValueSize {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// checkKey returns the error of writing an empty or too large key
+func (b *Bitcask) checkKey(key []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if uint32(len(key)) > b.cfg.MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+func (b *Bitcask) put(key, value []byte) (internal.Item, error) {
+	return b.write(b.newEntry(key, value))
+}
+
+// putTombstone writes the tombstone deleting key
+func (b *Bitcask) putTombstone(key []byte) (internal.Item, error) {
+	return b.write(b.newTombstone(key))
+}
+
+func (b *Bitcask) openDatafile(id int, readonly bool) (data.DataFile, error) {
+	if b.inMemory {
+		return data.NewMemDatafile(id, b.cfg)
+	}
+	if readonly && b.pool != nil {
+		return b.pool.Open(b.path, id, b.cfg)
+	}
+	return data.NewDatafile(b.path, id, readonly, b.cfg)
+}
+
+func (b *Bitcask) newEntry(key, value []byte) internal.Entry {
+	e := internal.NewEntryWithChecksum(b.cfg.ChecksumMode, b.cfg.Checksum, key, value)
+	e.Timestamp = b.clock().UnixNano()
+	return e
+}
+
+func (b *Bitcask) newTombstone(key []byte) internal.Entry {
+	e := b.newEntry(key, []byte{})
+	e.Tombstone = true
+	return e
+}
+
+// isTombstone reports whether e deletes its key, databases created before
+// codec.TombstoneVersion take every empty value for a tombstone
+func isTombstone(cfg *config.Config, e internal.Entry) bool {
+	return e.Tombstone || cfg.Version < codec.TombstoneVersion && len(e.Value) == 0
+}
+
+// write appends e to the current datafile, rotating it first if e would take
+// it past the max datafile size.
+func (b *Bitcask) write(e internal.Entry) (internal.Item, error) {
+	if err := b.rotateFor(codec.EncodedSize(e, b.cfg.EncryptionKey != nil, b.cfg.ChecksumSize())); err != nil {
+		return internal.Item{}, err
+	}
+	offset, n, err := b.curr.Write(e)
+	if err != nil {
+		return internal.Item{}, err
+	}
+	b.size += n
+	return internal.Item{
+		FileID:    b.curr.FileID(),
+		Offset:    offset,
+		Size:      n,
+		Timestamp: e.Timestamp,
+	}, nil
+}
+
+// rotateFor rotates the current datafile if an entry of the given size
+// doesn't fit in it, an entry larger than the max datafile size gets a
+// datafile of its own.
+func (b *Bitcask) rotateFor(size int64) error {
+	curr := b.curr.Size()
+	if curr > 0 && curr+size > int64(b.cfg.MaxDatafileSize) {
+		return b.rotate()
+	}
+	return nil
+}
+
+// insert adds key to the index, it must be called with b.mu held for writing.
+func (b *Bitcask) insert(key []byte, item internal.Item) {
+	b.record(key)
+	if !b.t.Insert(key, item) {
+		b.keys++
+	}
+	b.publish(key, OpPut)
+}
+
+// remove deletes key from the index, it must be called with b.mu held for
+// writing.
+func (b *Bitcask) remove(key []byte) {
+	b.record(key)
+	if b.t.Delete(key) {
+		b.keys--
+		b.publish(key, OpDelete)
+	}
+}
+
+// read retrieves the entry of item from its datafile and verifies its checksum.
+func (b *Bitcask) read(item internal.Item) (internal.Entry, error) {
+	df, err := b.datafile(item.FileID)
+	if err != nil {
+		return internal.Entry{}, err
+	}
+	e, err := df.ReadAt(item.Offset, item.Size)
+	if err != nil {
+		return e, err
+	}
+	if internal.Checksum(b.cfg.ChecksumMode, b.cfg.Checksum, e.Key, e.Value) != e.Checksum {
+		return e, ErrChecksumFailed
+	}
+	return e, nil
+}
+
+// datafile returns the datafile with the given id, which may be the current
+// one.
+func (b *Bitcask) datafile(id int) (data.DataFile, error) {
+	if id == b.curr.FileID() {
+		return b.curr, nil
+	}
+	df, ok := b.datafiles[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrDatafileMissing, "datafile %d", id)
+	}
+	return df, nil
+}
+
+// expired reports whether e has an expiry that has passed.
+func (b *Bitcask) expired(e internal.Entry) bool {
+	return e.Expiry > 0 && b.clock().UnixNano() > e.Expiry
+}
+
+// rotate closes the current datafile, reopens it read-only and starts a new
+// current datafile after all others.
+func (b *Bitcask) rotate() error {
+	return b.rotateTo(b.nextID())
+}
+
+// nextID returns an id higher than those of all datafiles, skipping the ids
+// of files that exist without being loaded so they aren't appended to.
+func (b *Bitcask) nextID() int {
+	id := b.curr.FileID()
+	for i := range b.datafiles {
+		if i > id {
+			id = i
+		}
+	}
+	id++
+	for !b.inMemory && internal.Exists(filepath.Join(b.path, fmt.Sprintf(b.cfg.DatafileFormat, id))) {
+		id++
+	}
+	return id
+}
+
+// rotateTo is like rotate but starts the current datafile with the given id,
+// an empty current datafile is removed rather than kept.
+func (b *Bitcask) rotateTo(next int) error {
+	id := b.curr.FileID()
+	if err := b.curr.Close(); err != nil {
+		return err
+	}
+	if b.inMemory {
+		// the datafile stays readable as it is
+		if b.curr.Size() > 0 {
+			b.datafiles[id] = b.curr
+		}
+	} else if b.curr.Size() == 0 {
+		if err := os.Remove(b.curr.Name()); err != nil {
+			return err
+		}
+	} else {
+		datafile, err := b.openDatafile(id, true)
+		if err != nil {
+			return err
+		}
+		b.datafiles[id] = datafile
+		if err := b.writeHint(id); err != nil {
+			return err
+		}
+	}
+
+	datafile, err := b.openDatafile(next, false)
+	if err != nil {
+		return err
+	}
+	b.curr = datafile
+	b.cfg.Logger.Printf("rotated datafile %d, writing to %d", id, next)
+	return nil
+}
+
+// loadDatafiles opens the datafiles in path read-only, through pool if not nil
+func loadDatafiles(path string, cfg *config.Config, pool *data.Pool) (datafiles map[int]data.DataFile, lastID int, err error) {
+	fns, err := internal.GetDatafiles(path, cfg.DatafileFormat)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids, err := internal.ParseIds(fns, cfg.DatafileFormat)
+	if err != nil {
+		return nil, 0, err
+	}
+	datafiles = make(map[int]data.DataFile)
+	for i, id := range ids {
+		// a name like 01.data parses to an id whose datafile is named
+		// differently, or to the id of another datafile
+		if !internal.Exists(filepath.Join(path, fmt.Sprintf(cfg.DatafileFormat, id))) || i > 0 && ids[i-1] == id {
+			cfg.Logger.Printf("skipping file not named after datafile %d", id)
+			continue
+		}
+		var file data.DataFile
+		if pool != nil {
+			file, err = pool.Open(path, id, cfg)
+		} else {
+			file, err = data.NewDatafile(path, id, true, cfg)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		datafiles[id] = file
+	}
+	for id := range datafiles {
+		if id > lastID {
+			lastID = id
+		}
+	}
+	return
+}
+
+// loadIndex loads the index file and replays the datafiles after its
+// watermark, or else rebuilds the index from the hint files and the datafiles
+// without one. Index and hint files are ignored if a datafile they should
+// reflect was modified after them, or with WithForceIndexRebuild. Entries of
+// datafiles failing their checksum are skipped, fail the load with
+// WithStrictRecovery, or are handled as the WithOnCorruption handler says.
+func loadIndex(path string, indexer index.Indexer, cfg *config.Config, datafles map[int]data.DataFile) (index.Tree, error) {
+	maxKeySize := cfg.MaxKeySize
+	t := newTree(cfg)
+	sortedDatafiles := getSortedDatafiles(datafles)
+	// the index reflects the datafiles up to wm, the rest is replayed
+	wm := &index.Watermark{FileID: -1}
+	indexPath := filepath.Join(path, "index")
+	if cfg.ForceIndexRebuild {
+		cfg.Logger.Printf("rebuilding index %s", indexPath)
+	} else {
+		loaded, found, err := indexer.Load(t, indexPath, maxKeySize)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !found:
+		case !indexCurrent(indexPath, loaded, sortedDatafiles):
+			cfg.Logger.Printf("ignoring index %s behind the datafiles", indexPath)
+			t = newTree(cfg)
+		case loaded == nil:
+			// an index without a watermark reflects all datafiles
+			return t, nil
+		default:
+			wm = loaded
+		}
+	}
+	replay := func(e internal.Entry, item internal.Item) error {
+		if internal.Checksum(cfg.ChecksumMode, cfg.Checksum, e.Key, e.Value) != e.Checksum {
+			def := ActionSkip
+			if cfg.StrictRecovery {
+				def = ActionAbort
+			}
+			switch onCorruption(cfg, e.Key, item.FileID, ErrChecksumFailed, def) {
+			case ActionAbort:
+				return errors.Wrapf(ErrChecksumFailed, "datafile %d offset %d", item.FileID, item.Offset)
+			case ActionDelete:
+				cfg.Logger.Printf("deleting key of corrupt entry at datafile %d offset %d", item.FileID, item.Offset)
+				t.Delete(e.Key)
+			default:
+				cfg.Logger.Printf("skipping corrupt entry at datafile %d offset %d", item.FileID, item.Offset)
 			}
-			t.Insert(e.Key, item)
-			offset += n
+			return nil
+		}
+		if isTombstone(cfg, e) {
+			t.Delete(e.Key)
+			return nil
+		}
+		t.Insert(e.Key, item)
+		return nil
+	}
+	for i, f := range sortedDatafiles {
+		if f.FileID() < wm.FileID {
+			continue
+		}
+		var offset int64
+		if f.FileID() == wm.FileID {
+			offset = wm.Offset
+		}
+		hint := internal.HintPath(f.Name())
+		var err error
+		if offset == 0 && internal.Exists(hint) && !cfg.ForceIndexRebuild && !olderThan(hint, f) {
+			err = index.LoadHint(hint, maxKeySize, func(key []byte, item internal.Item) {
+				//tombstone
+				if item.Size == 0 {
+					t.Delete(key)
+					return
+				}
+				t.Insert(key, item)
+			})
+		} else {
+			err = scanDatafileFrom(f, cfg, offset, replay)
+			// a crash while writing leaves a truncated entry at the end of
+			// the last datafile, any other decoding error is corruption
+			if codec.IsTruncated(err) && i == len(sortedDatafiles)-1 {
+				cfg.Logger.Printf("ignoring truncated entry at the end of datafile %d", f.FileID())
+				err = nil
+			}
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 	return t, nil
 }
 
+// newTree returns an empty index of the kind cfg says, normalizing keys with
+// its key transform if any
+func newTree(cfg *config.Config) index.Tree {
+	t := index.NewTree(cfg.Index)
+	if cfg.KeyTransform != nil {
+		return index.NewTransformTree(t, cfg.KeyTransform)
+	}
+	return t
+}
+
+// indexKey returns key as the index holds it
+func (b *Bitcask) indexKey(key []byte) []byte {
+	if b.cfg.KeyTransform != nil {
+		return b.cfg.KeyTransform(key)
+	}
+	return key
+}
+
+// indexCurrent reports whether the index file at path with watermark wm
+// reflects datafiles up to it: without a watermark none of them may have been
+// modified after the index, with one only those before the watermark's, and
+// the watermark's must hold its offset.
+func indexCurrent(path string, wm *index.Watermark, datafiles []data.DataFile) bool {
+	if wm == nil {
+		return !olderThan(path, datafiles...)
+	}
+	var before []data.DataFile
+	size := int64(0)
+	for _, df := range datafiles {
+		if df.FileID() < wm.FileID {
+			before = append(before, df)
+		} else if df.FileID() == wm.FileID {
+			size = df.Size()
+		}
+	}
+	return size >= wm.Offset && !olderThan(path, before...)
+}
+
+// saveIndex saves the index to path with a watermark at the end of the
+// current datafile, unless the database is in memory. It must be called with
+// b.mu held.
+func (b *Bitcask) saveIndex(path string) error {
+	if b.inMemory {
+		return nil
+	}
+	wm := index.Watermark{FileID: b.curr.FileID(), Offset: b.curr.Size()}
+	return b.indexer.Save(b.t, path, wm)
+}
+
+// olderThan reports whether any of datafiles was modified after the index or
+// hint file at path, so that it may lack their latest entries
+func olderThan(path string, datafiles ...data.DataFile) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	for _, df := range datafiles {
+		dfi, err := os.Stat(df.Name())
+		if err == nil && dfi.ModTime().After(fi.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+// onCorruption returns what to do with the corrupt entry of key in the
+// datafile with the given id, def if no WithOnCorruption handler is set.
+func onCorruption(cfg *config.Config, key []byte, fileID int, err error, def internal.Action) internal.Action {
+	if cfg.OnCorruption == nil {
+		return def
+	}
+	return cfg.OnCorruption(key, fileID, err)
+}
+
+// deleteCorrupt deletes key if its entry is still the corrupt one at item,
+// writing a tombstone unless the database is read-only.
+func (b *Bitcask) deleteCorrupt(key []byte, item internal.Item) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if curr, found := b.t.Search(key); b.closed || !found || curr != item {
+		return nil
+	}
+	if !b.cfg.Readonly {
+		if _, err := b.putTombstone(key); err != nil {
+			return err
+		}
+		if err := b.maybeSync(); err != nil {
+			return err
+		}
+	}
+	b.remove(key)
+	return nil
+}
+
+// scanDatafile decodes the entries of f from its start to its end, calling fn
+// with each entry and its location.
+func scanDatafile(f data.DataFile, cfg *config.Config, fn func(e internal.Entry, item internal.Item) error) error {
+	return scanDatafileFrom(f, cfg, 0, fn)
+}
+
+// scanDatafileFrom is like scanDatafile but starts at offset
+func scanDatafileFrom(f data.DataFile, cfg *config.Config, offset int64, fn func(e internal.Entry, item internal.Item) error) error {
+	return data.ScanDatafileFrom(f.Name(), cfg, offset, func(e internal.Entry, offset, size int64) error {
+		return fn(e, internal.Item{
+			FileID:    f.FileID(),
+			Offset:    offset,
+			Size:      size,
+			Timestamp: e.Timestamp,
+		})
+	})
+}
+
+// writeHint saves the hint file of the datafile with the given id. Tombstones
+// are recorded with a zero size.
+func (b *Bitcask) writeHint(id int) error {
+	df, err := b.openDatafile(id, true)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+	var (
+		keys  [][]byte
+		items []internal.Item
+	)
+	err = scanDatafile(df, b.cfg, func(e internal.Entry, item internal.Item) error {
+		if isTombstone(b.cfg, e) {
+			item.Size = 0
+		}
+		keys = append(keys, e.Key)
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mode := b.cfg.FileModeOr(0600)
+	if err := index.SaveHint(internal.HintPath(df.Name()), keys, items, mode); err != nil {
+		return err
+	}
+	if !b.cfg.BloomFilter {
+		return nil
+	}
+	var live [][]byte
+	for i, key := range keys {
+		if items[i].Size > 0 {
+			live = append(live, b.indexKey(key))
+		}
+	}
+	bloom := index.NewBloom(live)
+	if err := index.SaveBloom(internal.BloomPath(df.Name()), bloom, mode); err != nil {
+		return err
+	}
+	b.blooms[id] = bloom
+	return nil
+}
+
+// mayContain returns false if the bloom filter of the datafile with the given
+// id rules key out. Datafiles without one, like the current one, may contain
+// any key.
+func (b *Bitcask) mayContain(id int, key []byte) bool {
+	bloom, ok := b.blooms[id]
+	return !ok || bloom.MayContain(b.indexKey(key))
+}
+
+// removeHint removes the hint file and the bloom filter of datafile
+func removeHint(datafile string) error {
+	for _, path := range []string{internal.HintPath(datafile), internal.BloomPath(datafile)} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 func getSortedDatafiles(datafles map[int]data.DataFile) []data.DataFile {
 	files := make([]data.DataFile, len(datafles))
 	i := 0
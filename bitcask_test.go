@@ -2,30 +2,3318 @@ package bitcask
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/config"
+	"jay.com/bitcask/internal/data"
+	"jay.com/bitcask/internal/index"
 )
 
+// tempDir returns a new temporary directory removed once the test ends
+func tempDir(tb testing.TB) string {
+	dir, err := ioutil.TempDir("", "bitcask-")
+	if err != nil {
+		tb.Fatalf("temp dir error: %v", err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
 func TestPut(t *testing.T) {
-	db, err := Open("/tmp/db")
+	db, err := Open(tempDir(t))
 	if err != nil {
-		t.Errorf("test put error: %v", err)
+		t.Fatalf("open error: %v", err)
 	}
 	defer db.Close()
-	db.Put([]byte("hello"), []byte("world"))
+	if err := db.Put([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
 	got, err := db.Get([]byte("hello"))
-	if !bytes.Equal(got, []byte("world")) {
-		t.Errorf("put error, want: %v, got: %v", []byte("world"), got)
+	if err != nil || !bytes.Equal(got, []byte("world")) {
+		t.Errorf("put error, want: %v, got: %v (%v)", []byte("world"), got, err)
 	}
 }
 
 func TestGet(t *testing.T) {
-	db, err := Open("/tmp/db")
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	got, err := db.Get([]byte("hello"))
+	if err != nil || !bytes.Equal(got, []byte("world")) {
+		t.Errorf("get error, want: %v, got: %v (%v)", []byte("world"), got, err)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	path := filepath.Join(tempDir(t), "db")
+	files := func() []string {
+		fis, err := ioutil.ReadDir(path)
+		if err != nil {
+			t.Fatalf("read dir error: %v", err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+
+	// opening and closing a new database leaves no datafile behind
+	for i := 0; i < 2; i++ {
+		db, err := Open(path)
+		if err != nil {
+			t.Fatalf("open empty error: %v", err)
+		}
+		if db.Len() != 0 {
+			t.Errorf("len of empty database, want: 0, got: %d", db.Len())
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("close empty error: %v", err)
+		}
+	}
+	if got := strings.Join(files(), ","); got != "config.json,index" {
+		t.Errorf("files of empty database, want: config.json,index, got: %s", got)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	if got := strings.Join(files(), ","); got != "000000000.data,000000000.hint,config.json,index" {
+		t.Errorf("files, want: 000000000.data,000000000.hint,config.json,index, got: %s", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		db, err = Open(path)
+		if err != nil {
+			t.Fatalf("reopen error: %v", err)
+		}
+		if got, err := db.Get([]byte("hello")); err != nil || string(got) != "world" {
+			t.Errorf("get after reopen, want: world, got: %s (%v)", got, err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("close error: %v", err)
+		}
+	}
+}
+
+func TestGetCopy(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	got, err := db.Get([]byte("hello"))
+	if err != nil {
+		t.Fatalf("get error: %v", err)
+	}
+	copy(got, "xxxxx")
+	got, err = db.Get([]byte("hello"))
+	if err != nil || string(got) != "world" {
+		t.Errorf("get after mutating, want: world, got: %s (%v)", got, err)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("second close, want: nil, got: %v", err)
+	}
+	if _, err := db.Get([]byte("hello")); err != ErrClosed {
+		t.Errorf("get after close, want: %v, got: %v", ErrClosed, err)
+	}
+}
+
+func TestUseAfterClose(t *testing.T) {
+	t.Run("disk", func(t *testing.T) {
+		db, err := Open(tempDir(t))
+		if err != nil {
+			t.Fatalf("open error: %v", err)
+		}
+		testUseAfterClose(t, db)
+	})
+	t.Run("in memory", func(t *testing.T) {
+		db, err := OpenInMemory()
+		if err != nil {
+			t.Fatalf("open error: %v", err)
+		}
+		testUseAfterClose(t, db)
+	})
+}
+
+func testUseAfterClose(t *testing.T, db *Bitcask) {
+	if err := db.Put([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"put", func() error { return db.Put([]byte("hello"), []byte("again")) }},
+		{"get", func() error { _, err := db.Get([]byte("hello")); return err }},
+		{"delete", func() error { return db.Delete([]byte("hello")) }},
+		{"delete all", func() error { return db.DeleteAll() }},
+		{"sync", func() error { return db.Sync() }},
+		{"flush", func() error { return db.Flush() }},
+		{"scan", func() error { return db.Scan(nil, func([]byte) error { return nil }) }},
+		{"fold", func() error { return db.Fold(func(_, _ []byte) error { return nil }) }},
+		{"get many", func() error { _, err := db.GetMany([][]byte{[]byte("hello")}); return err }},
+		{"put many", func() error { return db.PutMany([]KV{{Key: []byte("hello"), Value: []byte("again")}}) }},
+		{"rename", func() error { return db.Rename([]byte("hello"), []byte("bye")) }},
+		{"delete prefix", func() error { _, err := db.DeletePrefix([]byte("he")); return err }},
+		{"swap", func() error { _, _, err := db.Swap([]byte("hello"), []byte("again")); return err }},
+		{"compare and swap", func() error {
+			_, err := db.CompareAndSwap([]byte("hello"), []byte("world"), []byte("again"))
+			return err
+		}},
+		{"compare and delete", func() error { _, err := db.CompareAndDelete([]byte("hello"), []byte("world")); return err }},
+		{"append", func() error { _, err := db.Append([]byte("hello"), []byte("!")); return err }},
+		{"put large", func() error { return db.PutLarge([]byte("hello"), []byte("again")) }},
+		{"get large", func() error { _, err := db.GetLarge([]byte("hello")); return err }},
+		{"delete large", func() error { return db.DeleteLarge([]byte("hello")) }},
+		{"put reader", func() error { return db.PutReader([]byte("hello"), strings.NewReader("again"), 5) }},
+		{"get reader", func() error { _, err := db.GetReader([]byte("hello")); return err }},
+		{"get from", func() error { _, err := db.GetFrom([]byte("hello")); return err }},
+		{"last modified", func() error { _, err := db.LastModified([]byte("hello")); return err }},
+		{"stats", func() error { _, err := db.Stats(true); return err }},
+		{"disk usage", func() error { _, err := db.DiskUsage(); return err }},
+		{"export", func() error { return db.Export(ioutil.Discard) }},
+		{"dump", func() error { return db.Dump(ioutil.Discard) }},
+		{"import", func() error { return db.Import(strings.NewReader("")) }},
+		{"backup", func() error { return db.Backup(tempDir(t)) }},
+		{"snapshot", func() error { _, err := db.Snapshot(); return err }},
+		{"txn", func() error {
+			txn := db.Begin()
+			if err := txn.Put([]byte("hello"), []byte("again")); err != nil {
+				return err
+			}
+			return txn.Commit()
+		}},
+		{"batch", func() error {
+			batch := db.NewBatch()
+			if err := batch.Put([]byte("hello"), []byte("again")); err != nil {
+				return err
+			}
+			return batch.Commit()
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.call(); err != ErrClosed {
+				t.Errorf("%s after close, want: %v, got: %v", test.name, ErrClosed, err)
+			}
+		})
+	}
+	if db.Has([]byte("hello")) {
+		t.Errorf("has after close, want: false, got: true")
+	}
+	if n := db.Len(); n != 0 {
+		t.Errorf("len after close, want: 0, got: %d", n)
+	}
+	if n := db.KeyCount(); n != 0 {
+		t.Errorf("key count after close, want: 0, got: %d", n)
+	}
+	if n := db.SizeOnDisk(); n != 0 {
+		t.Errorf("size on disk after close, want: 0, got: %d", n)
+	}
+	for key := range db.Keys() {
+		t.Errorf("keys after close, want: none, got: %s", key)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(1024))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("new-value-%d", i))); err != nil {
+			t.Fatalf("overwrite error: %v", err)
+		}
+	}
+	for i := 0; i < 1000; i += 2 {
+		if err := db.Delete([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatalf("delete error: %v", err)
+		}
+	}
+
+	countBefore, sizeBefore := datafilesUsage(t, path)
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	countAfter, sizeAfter := datafilesUsage(t, path)
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		got, err := db.Get(key)
+		if i%2 == 0 {
+			if err != ErrKeyNotFound {
+				t.Errorf("get deleted key %s, want: %v, got: %v", key, ErrKeyNotFound, err)
+			}
+			continue
+		}
+		want := []byte(fmt.Sprintf("new-value-%d", i))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("get key %s, want: %s, got: %s (%v)", key, want, got, err)
+		}
+	}
+	if countAfter >= countBefore {
+		t.Errorf("datafile count not reduced, before: %d, after: %d", countBefore, countAfter)
+	}
+	if sizeAfter >= sizeBefore {
+		t.Errorf("datafile size not reduced, before: %d, after: %d", sizeBefore, sizeAfter)
+	}
+}
+
+func datafilesUsage(t *testing.T, path string) (int, int64) {
+	fns, err := filepath.Glob(filepath.Join(path, "*.data"))
+	if err != nil {
+		t.Fatalf("glob error: %v", err)
+	}
+	var size int64
+	for _, fn := range fns {
+		stat, err := os.Stat(fn)
+		if err != nil {
+			t.Fatalf("stat error: %v", err)
+		}
+		size += stat.Size()
+	}
+	return len(fns), size
+}
+
+func TestReopenWithHints(t *testing.T) {
+	tests := []struct {
+		name        string
+		removeHints bool
+	}{
+		{name: "with hints", removeHints: false},
+		{name: "without hints", removeHints: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path, WithMaxDatafileSize(256))
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			for i := 0; i < 100; i++ {
+				if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+					t.Fatalf("put error: %v", err)
+				}
+			}
+			for i := 0; i < 100; i += 2 {
+				if err := db.Delete([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+					t.Fatalf("delete error: %v", err)
+				}
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+
+			datafiles, _ := filepath.Glob(filepath.Join(path, "*.data"))
+			hints, _ := filepath.Glob(filepath.Join(path, "*.hint"))
+			if len(hints) != len(datafiles) {
+				t.Errorf("hint files, want: %d, got: %d", len(datafiles), len(hints))
+			}
+			if err := os.Remove(filepath.Join(path, "index")); err != nil {
+				t.Fatalf("remove index error: %v", err)
+			}
+			if test.removeHints {
+				for _, fn := range hints {
+					os.Remove(fn)
+				}
+			}
+
+			db, err = Open(path, WithMaxDatafileSize(256))
+			if err != nil {
+				t.Fatalf("reopen error: %v", err)
+			}
+			defer db.Close()
+			if db.Len() != 50 {
+				t.Errorf("len, want: %d, got: %d", 50, db.Len())
+			}
+			for i := 1; i < 100; i += 2 {
+				key := []byte(fmt.Sprintf("key-%d", i))
+				want := []byte(fmt.Sprintf("value-%d", i))
+				got, err := db.Get(key)
+				if err != nil || !bytes.Equal(got, want) {
+					t.Errorf("get key %s, want: %s, got: %s (%v)", key, want, got, err)
+				}
+			}
+		})
+	}
+}
+
+const reopenBenchmarkSize = 500 << 20
+
+func BenchmarkReopen(b *testing.B) {
+	path := tempDir(b)
+	db, err := Open(path)
+	if err != nil {
+		b.Fatalf("open error: %v", err)
+	}
+	value := bytes.Repeat([]byte("v"), 4096)
+	for i := 0; i < reopenBenchmarkSize/len(value); i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), value); err != nil {
+			b.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		b.Fatalf("close error: %v", err)
+	}
+
+	benchmarks := []struct {
+		name        string
+		removeHints bool
+	}{
+		{name: "WithHints", removeHints: false},
+		{name: "WithoutHints", removeHints: true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				os.Remove(filepath.Join(path, "index"))
+				if bm.removeHints {
+					hints, _ := filepath.Glob(filepath.Join(path, "*.hint"))
+					for _, fn := range hints {
+						os.Remove(fn)
+					}
+				}
+				b.StartTimer()
+				db, err := Open(path)
+				if err != nil {
+					b.Fatalf("open error: %v", err)
+				}
+				b.StopTimer()
+				db.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	var keys [][]byte
+	scan := func(key []byte) error {
+		keys = append(keys, key)
+		return nil
+	}
+	if err := db.Scan(nil, scan); err != nil {
+		t.Errorf("scan empty database error: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("scan empty database, want no keys, got: %q", keys)
+	}
+
+	for _, key := range []string{"foo:2", "bar:1", "foo:1", "foobar", "baz"} {
+		if err := db.Put([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{name: "prefix", prefix: "foo:", want: []string{"foo:1", "foo:2"}},
+		{name: "shared prefix", prefix: "foo", want: []string{"foo:1", "foo:2", "foobar"}},
+		{name: "no match", prefix: "qux", want: nil},
+		{name: "empty prefix", prefix: "", want: []string{"bar:1", "baz", "foo:1", "foo:2", "foobar"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys = nil
+			if err := db.Scan([]byte(test.prefix), scan); err != nil {
+				t.Fatalf("scan error: %v", err)
+			}
+			if len(keys) != len(test.want) {
+				t.Fatalf("scan %q, want: %q, got: %q", test.prefix, test.want, keys)
+			}
+			for i, key := range keys {
+				if string(key) != test.want[i] {
+					t.Errorf("scan %q, want: %q, got: %q", test.prefix, test.want, keys)
+					break
+				}
+			}
+		})
+	}
+
+	errStop := errors.New("stop")
+	n := 0
+	err = db.Scan(nil, func(key []byte) error {
+		n++
+		if n == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("scan early termination, want: %v, got: %v", errStop, err)
+	}
+	if n != 2 {
+		t.Errorf("scan early termination, want %d calls, got: %d", 2, n)
+	}
+}
+
+func TestScanReverse(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"foo:2", "bar:1", "foo:1", "foobar", "baz"} {
+		if err := db.Put([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{name: "prefix", prefix: "foo:", want: []string{"foo:2", "foo:1"}},
+		{name: "shared prefix", prefix: "foo", want: []string{"foobar", "foo:2", "foo:1"}},
+		{name: "no match", prefix: "qux", want: nil},
+		{name: "empty prefix", prefix: "", want: []string{"foobar", "foo:2", "foo:1", "baz", "bar:1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var keys []string
+			err := db.ScanReverse([]byte(test.prefix), func(key []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("scan reverse error: %v", err)
+			}
+			if fmt.Sprint(keys) != fmt.Sprint(test.want) {
+				t.Errorf("scan reverse %q, want: %q, got: %q", test.prefix, test.want, keys)
+			}
+		})
+	}
+
+	errStop := errors.New("stop")
+	var keys []string
+	err = db.ScanReverse(nil, func(key []byte) error {
+		keys = append(keys, string(key))
+		return errStop
+	})
+	if err != errStop || len(keys) != 1 || keys[0] != "foobar" {
+		t.Errorf("scan reverse early termination, want: [foobar] (%v), got: %q (%v)", errStop, keys, err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"foo:2", "bar:1", "foo:1", "foobar", "baz", "f"} {
+		if err := db.Put([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Delete([]byte("foo:2")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   int
+	}{
+		{prefix: "", want: 5},
+		{prefix: "f", want: 3},
+		{prefix: "foo", want: 2},
+		{prefix: "foo:", want: 1},
+		{prefix: "ba", want: 2},
+		{prefix: "bar:1", want: 1},
+		{prefix: "qux", want: 0},
+	}
+	for _, test := range tests {
+		if got := db.Count([]byte(test.prefix)); got != test.want {
+			t.Errorf("count %q, want: %d, got: %d", test.prefix, test.want, got)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxDatafileSize(256))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	for i := 0; i < 100; i += 2 {
+		if err := db.Delete([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatalf("delete error: %v", err)
+		}
+	}
+
+	seen := make(map[string]int)
+	err = db.Fold(func(key, value []byte) error {
+		seen[string(key)]++
+		var i int
+		fmt.Sscanf(string(key), "key-%d", &i)
+		if want := fmt.Sprintf("value-%d", i); string(value) != want {
+			t.Errorf("fold key %s, want: %s, got: %s", key, want, value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("fold error: %v", err)
+	}
+	if len(seen) != 50 {
+		t.Errorf("fold visited keys, want: %d, got: %d", 50, len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("fold visited key %s %d times", key, n)
+		}
+	}
+
+	errStop := errors.New("stop")
+	n := 0
+	err = db.Fold(func(key, value []byte) error {
+		n++
+		return errStop
+	})
+	if err != errStop || n != 1 {
+		t.Errorf("fold early termination, want: %v after 1 call, got: %v after %d", errStop, err, n)
+	}
+}
+
+func TestRange(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key-%03d", i)) }
+	tests := []struct {
+		name       string
+		start, end []byte
+		first, n   int
+	}{
+		{name: "bounded", start: key(10), end: key(20), first: 10, n: 10},
+		{name: "between keys", start: []byte("key-0105"), end: []byte("key-0125"), first: 11, n: 2},
+		{name: "open start", start: nil, end: key(5), first: 0, n: 5},
+		{name: "open end", start: key(95), end: nil, first: 95, n: 5},
+		{name: "open", start: nil, end: nil, first: 0, n: 100},
+		{name: "empty", start: key(20), end: key(20), first: 0, n: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := 0
+			err := db.Range(test.start, test.end, func(k, value []byte) error {
+				i := test.first + n
+				if !bytes.Equal(k, key(i)) || string(value) != fmt.Sprintf("value-%03d", i) {
+					t.Errorf("range, want: %s, got: %s=%s", key(i), k, value)
+				}
+				n++
+				return nil
+			})
+			if err != nil || n != test.n {
+				t.Errorf("range, want: %d keys, got: %d (%v)", test.n, n, err)
+			}
+		})
+	}
+
+	errStop := errors.New("stop")
+	n := 0
+	err = db.Range(key(10), key(20), func(_, _ []byte) error {
+		n++
+		if n == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || n != 3 {
+		t.Errorf("range early termination, want: 3 calls (%v), got: %d (%v)", errStop, n, err)
+	}
+}
+
+func TestFoldChecksumFailed(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+
+	// flip the first value byte, after the key/value size prefix, the flags and the key
+	f, err := os.OpenFile(filepath.Join(path, "000000000.data"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open datafile error: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("V"), 4+8+1+int64(len("key"))); err != nil {
+		t.Fatalf("corrupt datafile error: %v", err)
+	}
+	f.Close()
+
+	err = db.Fold(func(key, value []byte) error {
+		return nil
+	})
+	if err != ErrChecksumFailed {
+		t.Errorf("fold corrupted value, want: %v, got: %v", ErrChecksumFailed, err)
+	}
+}
+
+func TestPutWithTTL(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	now := time.Unix(1600000000, 0)
+	db.clock = func() time.Time { return now }
+
+	if err := db.PutWithTTL([]byte("session"), []byte("data"), time.Minute); err != nil {
+		t.Fatalf("put with ttl error: %v", err)
+	}
+	if err := db.Put([]byte("forever"), []byte("data")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+
+	now = now.Add(59 * time.Second)
+	got, err := db.Get([]byte("session"))
+	if err != nil || !bytes.Equal(got, []byte("data")) {
+		t.Errorf("get before expiry, want: %s, got: %s (%v)", "data", got, err)
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, err := db.Get([]byte("session")); err != ErrKeyNotFound {
+		t.Errorf("get after expiry, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	got, err = db.Get([]byte("forever"))
+	if err != nil || !bytes.Equal(got, []byte("data")) {
+		t.Errorf("get without ttl, want: %s, got: %s (%v)", "data", got, err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	db.clock = func() time.Time { return now }
+	if _, err := db.Get([]byte("session")); err != ErrKeyNotFound {
+		t.Errorf("get after reopen, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestConcurrentReadWrite(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxDatafileSize(1024))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			key := []byte(fmt.Sprintf("key-%d", i%100))
+			if err := db.Put(key, []byte(fmt.Sprintf("value-%d", i%100))); err != nil {
+				t.Errorf("put error: %v", err)
+				return
+			}
+		}
+	}()
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				key := []byte(fmt.Sprintf("key-%d", i%100))
+				want := []byte(fmt.Sprintf("value-%d", i%100))
+				got, err := db.Get(key)
+				if err != nil || !bytes.Equal(got, want) {
+					t.Errorf("get key %s, want: %s, got: %s (%v)", key, want, got, err)
+					return
+				}
+				db.Has(key)
+				db.Len()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOpenLocked(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if _, err := Open(path); err != ErrDatabaseLocked {
+		t.Errorf("second open, want: %v, got: %v", ErrDatabaseLocked, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "lock")); !os.IsNotExist(err) {
+		t.Errorf("lock file not removed on close: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("open after close error: %v", err)
+	}
+	db.Close()
+}
+
+func TestReadonly(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path, WithReadonly(true))
 	if err != nil {
-		t.Errorf("test put error: %v", err)
+		t.Fatalf("open read-only error: %v", err)
 	}
 	defer db.Close()
+
+	mutations := []struct {
+		name string
+		fn   func() error
+	}{
+		{name: "Put", fn: func() error { return db.Put([]byte("foo"), []byte("bar")) }},
+		{name: "PutWithTTL", fn: func() error { return db.PutWithTTL([]byte("foo"), []byte("bar"), time.Minute) }},
+		{name: "Delete", fn: func() error { return db.Delete([]byte("hello")) }},
+		{name: "DeleteAll", fn: func() error { return db.DeleteAll() }},
+		{name: "Sync", fn: func() error { return db.Sync() }},
+		{name: "Merge", fn: func() error { return db.Merge() }},
+	}
+	for _, m := range mutations {
+		if err := m.fn(); err != ErrReadOnlyDatabase {
+			t.Errorf("%s, want: %v, got: %v", m.name, ErrReadOnlyDatabase, err)
+		}
+	}
+
 	got, err := db.Get([]byte("hello"))
-	if !bytes.Equal(got, []byte("world")) {
-		t.Errorf("put error, want: %v, got: %v", []byte("world"), got)
+	if err != nil || !bytes.Equal(got, []byte("world")) {
+		t.Errorf("get, want: %s, got: %s (%v)", "world", got, err)
+	}
+	if !db.Has([]byte("hello")) {
+		t.Errorf("has, want: true, got: false")
+	}
+	if db.Len() != 1 {
+		t.Errorf("len, want: %d, got: %d", 1, db.Len())
+	}
+	var keys int
+	if err := db.Scan(nil, func(key []byte) error { keys++; return nil }); err != nil || keys != 1 {
+		t.Errorf("scan, want 1 key, got: %d (%v)", keys, err)
+	}
+	if err := db.Fold(func(key, value []byte) error { return nil }); err != nil {
+		t.Errorf("fold error: %v", err)
+	}
+}
+
+func TestChecksumMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		want error
+	}{
+		{mode: ChecksumValueOnly, want: nil},
+		{mode: ChecksumKeyAndValue, want: ErrChecksumFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path, WithChecksumMode(test.mode))
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			defer db.Close()
+			if err := db.Put([]byte("key"), []byte("value")); err != nil {
+				t.Fatalf("put error: %v", err)
+			}
+
+			// flip the last key byte, after the key/value size prefix and the flags
+			f, err := os.OpenFile(filepath.Join(path, "000000000.data"), os.O_RDWR, 0)
+			if err != nil {
+				t.Fatalf("open datafile error: %v", err)
+			}
+			if _, err := f.WriteAt([]byte("Y"), 4+8+1+2); err != nil {
+				t.Fatalf("corrupt datafile error: %v", err)
+			}
+			f.Close()
+
+			if _, err := db.Get([]byte("key")); err != test.want {
+				t.Errorf("get corrupted key, want: %v, got: %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestChecksumModeMismatch(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	db.Close()
+
+	if _, err := Open(path, WithChecksumMode(ChecksumKeyAndValue)); err != ErrChecksumModeMismatch {
+		t.Errorf("reopen with other checksum mode, want: %v, got: %v", ErrChecksumModeMismatch, err)
+	}
+	db, err = Open(path, WithChecksumMode(ChecksumValueOnly))
+	if err != nil {
+		t.Fatalf("reopen with same checksum mode error: %v", err)
+	}
+	db.Close()
+}
+
+func TestChecksumAlgorithm(t *testing.T) {
+	for _, algorithm := range []string{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash, ChecksumCRC64, ChecksumXXHash64} {
+		t.Run(algorithm, func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path, WithChecksum(algorithm), WithChecksumMode(ChecksumKeyAndValue))
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			for i := 0; i < 10; i++ {
+				if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), bytes.Repeat([]byte{byte(i)}, i*10)); err != nil {
+					t.Fatalf("put error: %v", err)
+				}
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+
+			db, err = Open(path, WithChecksum(algorithm), WithChecksumMode(ChecksumKeyAndValue))
+			if err != nil {
+				t.Fatalf("reopen error: %v", err)
+			}
+			defer db.Close()
+			for i := 0; i < 10; i++ {
+				got, err := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+				if err != nil || !bytes.Equal(got, bytes.Repeat([]byte{byte(i)}, i*10)) {
+					t.Errorf("get key-%d, want: %d bytes, got: %d bytes (%v)", i, i*10, len(got), err)
+				}
+			}
+			report, err := db.Verify()
+			if err != nil || report.Checked != 10 || len(report.Corrupt) != 0 {
+				t.Errorf("verify, want: 10 entries checked, none corrupt, got: %+v (%v)", report, err)
+			}
+
+			corruptValue(t, db, []byte("key-5"))
+			if _, err := db.Get([]byte("key-5")); err != ErrChecksumFailed {
+				t.Errorf("get corrupted key, want: %v, got: %v", ErrChecksumFailed, err)
+			}
+		})
+	}
+}
+
+func TestChecksum64Size(t *testing.T) {
+	// 64-bit checksums make every entry 54 bytes, 4 more than the 50 of
+	// TestRotationBoundary, so 2 fit in a datafile of 108 bytes
+	path := tempDir(t)
+	db, err := Open(path, WithChecksum(ChecksumXXHash64), WithMaxDatafileSize(108))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	if n, size := datafilesUsage(t, path); n != 5 || size != 10*54 {
+		t.Errorf("datafiles, want: 5 holding %d bytes, got: %d holding %d", 10*54, n, size)
+	}
+
+	db, err = Open(path, WithForceIndexRebuild())
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		want := fmt.Sprintf("value-%05d", i)
+		if got, err := db.Get([]byte(fmt.Sprintf("key-%02d", i))); err != nil || string(got) != want {
+			t.Errorf("get, want: %s, got: %s (%v)", want, got, err)
+		}
+	}
+	value := bytes.Repeat([]byte("v"), 100)
+	if err := db.PutReader([]byte("large"), bytes.NewReader(value), int64(len(value))); err != nil {
+		t.Fatalf("put reader error: %v", err)
+	}
+	r, err := db.GetReader([]byte("large"))
+	if err != nil {
+		t.Fatalf("get reader error: %v", err)
+	}
+	defer r.Close()
+	if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, value) {
+		t.Errorf("read, want: %d bytes, got: %d bytes (%v)", len(value), len(got), err)
+	}
+}
+
+func TestChecksumAlgorithmMismatch(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithChecksum(ChecksumXXHash))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	db.Close()
+
+	if _, err := Open(path, WithChecksum(ChecksumCRC32C)); !errors.Is(err, ErrConfigMismatch) {
+		t.Errorf("reopen with other checksum, want: %v, got: %v", ErrConfigMismatch, err)
+	}
+	// the persisted algorithm is kept without WithChecksum
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	if db.cfg.Checksum != ChecksumXXHash {
+		t.Errorf("reopen checksum, want: %s, got: %s", ChecksumXXHash, db.cfg.Checksum)
+	}
+	db.Close()
+}
+
+func TestStats(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxDatafileSize(1024))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	stats, err := db.Stats(false)
+	if err != nil {
+		t.Fatalf("stats error: %v", err)
+	}
+	if stats.Keys != 100 || stats.ReclaimableSize != 0 || stats.TotalSize == 0 {
+		t.Errorf("stats after puts, want 100 keys and nothing reclaimable, got: %+v", stats)
+	}
+	total, reclaimable := stats.TotalSize, stats.ReclaimableSize
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("new-value-%d", i))); err != nil {
+			t.Fatalf("overwrite error: %v", err)
+		}
+	}
+	stats, _ = db.Stats(false)
+	if stats.ReclaimableSize <= reclaimable || stats.TotalSize <= total {
+		t.Errorf("stats after overwrites, want more than %d reclaimable, got: %+v", reclaimable, stats)
+	}
+	reclaimable = stats.ReclaimableSize
+
+	for i := 0; i < 100; i += 2 {
+		if err := db.Delete([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatalf("delete error: %v", err)
+		}
+	}
+	stats, _ = db.Stats(false)
+	if stats.Keys != 50 || stats.ReclaimableSize <= reclaimable {
+		t.Errorf("stats after deletes, want 50 keys and more than %d reclaimable, got: %+v", reclaimable, stats)
+	}
+	datafiles := stats.Datafiles
+
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	stats, _ = db.Stats(false)
+	if stats.Keys != 50 || stats.ReclaimableSize != 0 || stats.Datafiles >= datafiles {
+		t.Errorf("stats after merge, want 50 keys, nothing reclaimable and fewer than %d datafiles, got: %+v", datafiles, stats)
+	}
+}
+
+func TestStatsDetailed(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "plain"},
+		{name: "encrypted", opts: []Option{WithEncryption(bytes.Repeat([]byte("k"), 16))}},
+		{name: "64-bit checksums", opts: []Option{WithChecksum(ChecksumXXHash64)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := Open(tempDir(t), append(test.opts, WithMaxKeySize(128))...)
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			defer db.Close()
+			// keys of 1 to 100 bytes with values 10 times as large
+			for i := 1; i <= 100; i++ {
+				if err := db.Put(bytes.Repeat([]byte("k"), i), bytes.Repeat([]byte("v"), i*10)); err != nil {
+					t.Fatalf("put error: %v", err)
+				}
+			}
+
+			stats, err := db.Stats(false)
+			if err != nil {
+				t.Fatalf("stats error: %v", err)
+			}
+			if stats.KeySizes != (SizeHistogram{}) || stats.ValueSizes != (SizeHistogram{}) {
+				t.Errorf("stats, want no histograms, got: %+v, %+v", stats.KeySizes, stats.ValueSizes)
+			}
+
+			stats, err = db.Stats(true)
+			if err != nil {
+				t.Fatalf("detailed stats error: %v", err)
+			}
+			wantKeys := SizeHistogram{Count: 100, Min: 1, Max: 100, Mean: 50.5, P50: 50, P90: 90, P99: 99}
+			if stats.KeySizes != wantKeys {
+				t.Errorf("key sizes, want: %+v, got: %+v", wantKeys, stats.KeySizes)
+			}
+			wantValues := SizeHistogram{Count: 100, Min: 10, Max: 1000, Mean: 505, P50: 500, P90: 900, P99: 990}
+			if stats.ValueSizes != wantValues {
+				t.Errorf("value sizes, want: %+v, got: %+v", wantValues, stats.ValueSizes)
+			}
+		})
+	}
+}
+
+func TestAutoMerge(t *testing.T) {
+	interval := autoMergeInterval
+	autoMergeInterval = 10 * time.Millisecond
+	defer func() { autoMergeInterval = interval }()
+
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(1024))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for n := 0; n < 5; n++ {
+		for i := 0; i < 100; i++ {
+			if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d-%d", i, n))); err != nil {
+				t.Fatalf("put error: %v", err)
+			}
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	before, _ := datafilesUsage(t, path)
+
+	db, err = Open(path, WithAutoMerge(0.5))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if after, _ := datafilesUsage(t, path); after < before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("datafiles not merged automatically, still %d datafiles", before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		want := []byte(fmt.Sprintf("value-%d-%d", i, 4))
+		got, err := db.Get(key)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("get key %s, want: %s, got: %s (%v)", key, want, got, err)
+		}
+	}
+}
+
+func TestAutoMergeNotPersisted(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithAutoMerge(0.5))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if db.cfg.AutoMergeThreshold != 0 {
+		t.Errorf("auto merge threshold after reopen, want: 0, got: %v", db.cfg.AutoMergeThreshold)
+	}
+}
+
+func TestSyncOnWrite(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithSync(true))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Delete([]byte("hello")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.Put([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+
+	// the writer is still open, so read what reached disk read-only
+	reader, err := Open(path, WithReadonly(true))
+	if err != nil {
+		t.Fatalf("open read-only error: %v", err)
+	}
+	defer reader.Close()
+	if reader.Has([]byte("hello")) {
+		t.Errorf("deleted key hello exists after reopen")
+	}
+	got, err := reader.Get([]byte("foo"))
+	if err != nil || !bytes.Equal(got, []byte("bar")) {
+		t.Errorf("get after reopen, want: %s, got: %s (%v)", "bar", got, err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+
+	keys := db.Keys()
+	// writes after Keys are not part of the snapshot
+	if err := db.Put([]byte("key-new"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	var got [][]byte
+	for key := range keys {
+		got = append(got, key)
+	}
+	if len(got) != db.Len()-1 {
+		t.Fatalf("keys, want: %d, got: %d", db.Len()-1, len(got))
+	}
+	for i, key := range got {
+		if want := fmt.Sprintf("key-%02d", i); string(key) != want {
+			t.Errorf("key %d, want: %s, got: %s", i, want, key)
+		}
+	}
+}
+
+func TestReopenAndWrite(t *testing.T) {
+	path := tempDir(t)
+	for n := 0; n < 3; n++ {
+		db, err := Open(path)
+		if err != nil {
+			t.Fatalf("open %d error: %v", n, err)
+		}
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("key-%d-%d", n, i))
+			if err := db.Put(key, []byte(fmt.Sprintf("value-%d-%d", n, i))); err != nil {
+				t.Fatalf("put error: %v", err)
+			}
+		}
+		// overwrite a key written before the reopen
+		if err := db.Put([]byte("shared"), []byte(fmt.Sprintf("value-%d", n))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+		for m := 0; m <= n; m++ {
+			for i := 0; i < 10; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", m, i))
+				want := []byte(fmt.Sprintf("value-%d-%d", m, i))
+				got, err := db.Get(key)
+				if err != nil || !bytes.Equal(got, want) {
+					t.Errorf("open %d get key %s, want: %s, got: %s (%v)", n, key, want, got, err)
+				}
+			}
+		}
+		got, err := db.Get([]byte("shared"))
+		if want := []byte(fmt.Sprintf("value-%d", n)); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("open %d get key shared, want: %s, got: %s (%v)", n, want, got, err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("close error: %v", err)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxDatafileSize(256))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	for i := 0; i < 100; i += 3 {
+		if err := db.Delete([]byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatalf("delete error: %v", err)
+		}
+	}
+
+	dest := filepath.Join(tempDir(t), "backup")
+	if err := db.Backup(dest); err != nil {
+		t.Fatalf("backup error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "lock")); !os.IsNotExist(err) {
+		t.Errorf("lock file copied to backup: %v", err)
+	}
+
+	backup, err := Open(dest)
+	if err != nil {
+		t.Fatalf("open backup error: %v", err)
+	}
+	defer backup.Close()
+	if backup.Len() != db.Len() {
+		t.Errorf("backup len, want: %d, got: %d", db.Len(), backup.Len())
+	}
+	err = db.Fold(func(key, value []byte) error {
+		got, err := backup.Get(key)
+		if err != nil || !bytes.Equal(got, value) {
+			t.Errorf("backup get key %s, want: %s, got: %s (%v)", key, value, got, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("fold error: %v", err)
+	}
+}
+
+func TestDatafileFormat(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithDatafileFormat("shard-1-%012d.data"), WithMaxDatafileSize(256))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	fns, _ := filepath.Glob(filepath.Join(path, "shard-1-*.data"))
+	if len(fns) < 2 {
+		t.Fatalf("datafiles, want several shard-1-*.data files, got: %q", fns)
+	}
+	if _, err := os.Stat(filepath.Join(path, "shard-1-000000000000.data")); err != nil {
+		t.Errorf("first datafile error: %v", err)
+	}
+	// rebuild from the datafiles rather than the index
+	os.Remove(filepath.Join(path, "index"))
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		want := []byte(fmt.Sprintf("value-%d", i))
+		got, err := db.Get(key)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("get key %s, want: %s, got: %s (%v)", key, want, got, err)
+		}
+	}
+	if _, err := Open(tempDir(t), WithDatafileFormat("%09d.log")); err == nil {
+		t.Errorf("open with invalid datafile format, want error, got: nil")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	value := bytes.Repeat([]byte("compressible "), 100)
+	usage := make(map[string]int64)
+	for _, compression := range []string{CompressionNone, CompressionGzip} {
+		path := tempDir(t)
+		db, err := Open(path, WithCompression(compression))
+		if err != nil {
+			t.Fatalf("open error: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), value); err != nil {
+				t.Fatalf("put error: %v", err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("close error: %v", err)
+		}
+		_, usage[compression] = datafilesUsage(t, path)
+
+		// entries stay readable whatever compression the store is reopened with
+		db, err = Open(path, WithCompression(CompressionNone))
+		if err != nil {
+			t.Fatalf("reopen error: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			got, err := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+			if err != nil || !bytes.Equal(got, value) {
+				t.Errorf("get with %s, want: %d bytes, got: %d bytes (%v)", compression, len(value), len(got), err)
+			}
+		}
+		db.Close()
+	}
+	if usage[CompressionGzip] >= usage[CompressionNone] {
+		t.Errorf("compressed size, want less than %d, got: %d", usage[CompressionNone], usage[CompressionGzip])
+	}
+	if _, err := Open(tempDir(t), WithCompression("snappy")); err != errInvalidCompression {
+		t.Errorf("open with invalid compression, want: %v, got: %v", errInvalidCompression, err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	path := tempDir(t)
+	key := bytes.Repeat([]byte("k"), 32)
+	value := []byte("top secret value")
+	db, err := Open(path, WithEncryption(key))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), value); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Delete([]byte("key-0")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(path, "000000000.data"))
+	if err != nil {
+		t.Fatalf("read datafile error: %v", err)
+	}
+	if bytes.Contains(raw, value) {
+		t.Errorf("datafile holds the plaintext value")
+	}
+
+	if _, err := Open(path); err != ErrEncryptionKeyRequired {
+		t.Errorf("open without key, want: %v, got: %v", ErrEncryptionKeyRequired, err)
+	}
+	if _, err := Open(path, WithEncryption(bytes.Repeat([]byte("w"), 32))); err != ErrWrongEncryptionKey {
+		t.Errorf("open with wrong key, want: %v, got: %v", ErrWrongEncryptionKey, err)
+	}
+	if _, err := Open(tempDir(t), WithEncryption([]byte("short"))); err != errInvalidEncryptionKey {
+		t.Errorf("open with invalid key, want: %v, got: %v", errInvalidEncryptionKey, err)
+	}
+
+	// rebuild from the datafiles rather than the index
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path, WithEncryption(key))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Get([]byte("key-0")); err != ErrKeyNotFound {
+		t.Errorf("get deleted key, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	for i := 1; i < 10; i++ {
+		got, err := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+		if err != nil || !bytes.Equal(got, value) {
+			t.Errorf("get key-%d, want: %s, got: %s (%v)", i, value, got, err)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxKeySize(8))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("tmp"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Put([]byte("final"), []byte("old")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Rename([]byte("tmp"), []byte("final")); err != nil {
+		t.Fatalf("rename error: %v", err)
+	}
+	if got, err := db.Get([]byte("final")); err != nil || !bytes.Equal(got, []byte("value")) {
+		t.Errorf("get renamed key, want: value, got: %s (%v)", got, err)
+	}
+	if db.Has([]byte("tmp")) {
+		t.Errorf("has old key, want: false, got: true")
+	}
+	if err := db.Rename([]byte("missing"), []byte("other")); err != ErrKeyNotFound {
+		t.Errorf("rename missing key, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	if err := db.Rename([]byte("final"), []byte("too long key")); err != ErrKeyTooLarge {
+		t.Errorf("rename to large key, want: %v, got: %v", ErrKeyTooLarge, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// the rename survives rebuilding from the datafiles
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path, WithMaxKeySize(8))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if got, err := db.Get([]byte("final")); err != nil || !bytes.Equal(got, []byte("value")) {
+		t.Errorf("get renamed key after reopen, want: value, got: %s (%v)", got, err)
+	}
+	if db.Has([]byte("tmp")) || db.Len() != 1 {
+		t.Errorf("keys after reopen, want: [final], got: %d keys", db.Len())
+	}
+}
+
+func TestCounters(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(512))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	check := func(step string) {
+		t.Helper()
+		if db.KeyCount() != db.Len() {
+			t.Errorf("%s: key count, want: %d, got: %d", step, db.Len(), db.KeyCount())
+		}
+		db.Sync()
+		if _, size := datafilesUsage(t, path); db.SizeOnDisk() != size {
+			t.Errorf("%s: size on disk, want: %d, got: %d", step, size, db.SizeOnDisk())
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("key-%d", i%30)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	check("put")
+	for i := 0; i < 10; i++ {
+		db.Delete([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	db.Delete([]byte("missing"))
+	check("delete")
+	db.Rename([]byte("key-10"), []byte("renamed"))
+	batch := db.NewBatch()
+	batch.Put([]byte("batch"), []byte("value"))
+	batch.Delete([]byte("key-11"))
+	batch.Commit()
+	txn := db.Begin()
+	txn.Put([]byte("txn"), []byte("value"))
+	txn.Delete([]byte("key-12"))
+	txn.Commit()
+	check("rename, batch and txn")
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	check("merge")
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path, WithMaxDatafileSize(512))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	check("reopen")
+	if err := db.DeleteAll(); err != nil {
+		t.Fatalf("delete all error: %v", err)
+	}
+	check("delete all")
+	if db.KeyCount() != 0 {
+		t.Errorf("key count after delete all, want: 0, got: %d", db.KeyCount())
+	}
+}
+
+// countdownContext is done after its Err method has been called n times
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n == 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestContextCancel(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(256))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("key-%02d", i%50)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := 0
+	err = db.FoldContext(ctx, func(key, value []byte) error {
+		if n++; n == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || n != 10 {
+		t.Errorf("fold, want: %v after 10 keys, got: %v after %d", context.Canceled, err, n)
+	}
+	n = 0
+	err = db.ScanContext(ctx, nil, func(key []byte) error {
+		n++
+		return nil
+	})
+	if err != context.Canceled || n != 0 {
+		t.Errorf("scan, want: %v after 0 keys, got: %v after %d", context.Canceled, err, n)
+	}
+	if err := db.BackupContext(ctx, tempDir(t)); err != context.Canceled {
+		t.Errorf("backup, want: %v, got: %v", context.Canceled, err)
+	}
+
+	if err := db.MergeContext(&countdownContext{Context: context.Background(), n: 20}); err != context.Canceled {
+		t.Errorf("merge, want: %v, got: %v", context.Canceled, err)
+	}
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key-%02d", i))
+		want := []byte(fmt.Sprintf("value-%d", i+50))
+		if got, err := db.Get(key); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("get %s after cancelled merge, want: %s, got: %s (%v)", key, want, got, err)
+		}
+	}
+	if err := db.Merge(); err != nil {
+		t.Errorf("merge error: %v", err)
+	}
+
+	if err := db.DeleteAllContext(&countdownContext{Context: context.Background(), n: 20}); err != context.Canceled {
+		t.Errorf("delete all, want: %v, got: %v", context.Canceled, err)
+	}
+	if db.Len() != 30 {
+		t.Errorf("keys after cancelled delete all, want: 30, got: %d", db.Len())
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Errorf("put after cancel error: %v", err)
+	}
+}
+
+// captureLogger collects the messages logged to it
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *captureLogger) count(prefix string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLogger(t *testing.T) {
+	logger := &captureLogger{}
+	db, err := Open(tempDir(t), WithMaxDatafileSize(64), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if logger.count("loading index") != 1 {
+		t.Errorf("index load lines, want: 1, got: %q", logger.lines)
+	}
+	for i := 0; i < 10; i++ {
+		db.Put([]byte("key"), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	if logger.count("rotated datafile") == 0 {
+		t.Errorf("rotation lines, want some, got: %q", logger.lines)
+	}
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	if logger.count("merging") != 1 || logger.count("merged") != 1 {
+		t.Errorf("merge lines, want: 2, got: %q", logger.lines)
+	}
+}
+
+func TestEmptyValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		reopen func(t *testing.T, db *Bitcask, path string) (*Bitcask, error)
+	}{
+		{name: "close", reopen: func(t *testing.T, db *Bitcask, path string) (*Bitcask, error) {
+			if err := db.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+			return Open(path)
+		}},
+		{name: "rebuild", reopen: func(t *testing.T, db *Bitcask, path string) (*Bitcask, error) {
+			if err := db.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+			return Open(path, WithForceIndexRebuild())
+		}},
+		{name: "crash", reopen: func(t *testing.T, db *Bitcask, path string) (*Bitcask, error) {
+			abandon(db)
+			return Open(path)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path, WithMaxDatafileSize(100))
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			for _, key := range []string{"empty", "deleted", "other"} {
+				if err := db.Put([]byte(key), []byte{}); err != nil {
+					t.Fatalf("put error: %v", err)
+				}
+			}
+			if err := db.Delete([]byte("deleted")); err != nil {
+				t.Fatalf("delete error: %v", err)
+			}
+			if err := db.Sync(); err != nil {
+				t.Fatalf("sync error: %v", err)
+			}
+
+			db, err = test.reopen(t, db, path)
+			if err != nil {
+				t.Fatalf("reopen error: %v", err)
+			}
+			defer db.Close()
+			for _, key := range []string{"empty", "other"} {
+				if got, err := db.Get([]byte(key)); err != nil || len(got) != 0 {
+					t.Errorf("get %s, want: empty value, got: %q (%v)", key, got, err)
+				}
+			}
+			if _, err := db.Get([]byte("deleted")); err != ErrKeyNotFound {
+				t.Errorf("get deleted key, want: %v, got: %v", ErrKeyNotFound, err)
+			}
+		})
+	}
+}
+
+func TestReopenEmptyValue(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("marker"), []byte{}); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	if !db.Has([]byte("marker")) {
+		t.Errorf("has after reopen, want: true, got: false")
+	}
+	// merging rewrites the entry, still without the tombstone flag
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path, WithForceIndexRebuild())
+	if err != nil {
+		t.Fatalf("reopen after merge error: %v", err)
+	}
+	defer db.Close()
+	if !db.Has([]byte("marker")) {
+		t.Errorf("has after merge, want: true, got: false")
+	}
+}
+
+func TestLegacyTombstone(t *testing.T) {
+	tests := []struct {
+		version int
+		want    error
+	}{
+		{version: 4, want: ErrKeyNotFound},
+		{version: 5, want: nil},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.version), func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path)
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			if err := db.Put([]byte("key"), []byte("value")); err != nil {
+				t.Fatalf("put error: %v", err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+			configPath := filepath.Join(path, "config.json")
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				t.Fatalf("load config error: %v", err)
+			}
+			cfg.Version = test.version
+			if err := cfg.Save(configPath); err != nil {
+				t.Fatalf("save config error: %v", err)
+			}
+			// an empty value without the tombstone flag
+			df, err := data.NewDatafile(path, 1, false, cfg)
+			if err != nil {
+				t.Fatalf("open datafile error: %v", err)
+			}
+			if _, _, err := df.Write(internal.NewEntry([]byte("key"), []byte{})); err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+			if err := df.Close(); err != nil {
+				t.Fatalf("close datafile error: %v", err)
+			}
+
+			db, err = Open(path, WithForceIndexRebuild())
+			if err != nil {
+				t.Fatalf("reopen error: %v", err)
+			}
+			defer db.Close()
+			if _, err := db.Get([]byte("key")); err != test.want {
+				t.Errorf("get, want: %v, got: %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestStrictDelete(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantAbsent error
+	}{
+		{name: "lenient", wantAbsent: nil},
+		{name: "strict", opts: []Option{WithStrictDelete()}, wantAbsent: ErrKeyNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := Open(tempDir(t), test.opts...)
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			defer db.Close()
+			if err := db.Put([]byte("key"), []byte("value")); err != nil {
+				t.Fatalf("put error: %v", err)
+			}
+
+			if err := db.Delete([]byte("key")); err != nil {
+				t.Errorf("delete present key error: %v", err)
+			}
+			if db.Has([]byte("key")) {
+				t.Errorf("has deleted key, want: false, got: true")
+			}
+
+			size := db.curr.Size()
+			if err := db.Delete([]byte("absent")); err != test.wantAbsent {
+				t.Errorf("delete absent key, want: %v, got: %v", test.wantAbsent, err)
+			}
+			// only the lenient delete writes a tombstone
+			if grown := db.curr.Size() > size; grown != (test.wantAbsent == nil) {
+				t.Errorf("delete absent key wrote a tombstone: %v", grown)
+			}
+		})
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		db.Put([]byte(fmt.Sprintf("user:%d", i)), []byte("user"))
+		db.Put([]byte(fmt.Sprintf("order:%d", i)), []byte("order"))
+	}
+	n, err := db.DeletePrefix([]byte("user:"))
+	if err != nil || n != 5 {
+		t.Errorf("delete prefix, want: 5 deleted, got: %d (%v)", n, err)
+	}
+	if n, err := db.DeletePrefix([]byte("missing:")); err != nil || n != 0 {
+		t.Errorf("delete missing prefix, want: 0 deleted, got: %d (%v)", n, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 5; i++ {
+		if key := []byte(fmt.Sprintf("user:%d", i)); db.Has(key) {
+			t.Errorf("has %s, want: false, got: true", key)
+		}
+		if key := []byte(fmt.Sprintf("order:%d", i)); !db.Has(key) {
+			t.Errorf("has %s, want: true, got: false", key)
+		}
+	}
+}
+
+func TestMergeExpired(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	now := time.Now()
+	db.clock = func() time.Time { return now }
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 10; i++ {
+		db.PutWithTTL([]byte(fmt.Sprintf("ttl-%d", i)), value, time.Minute)
+		db.Put([]byte(fmt.Sprintf("key-%d", i)), value)
+	}
+	db.Sync()
+	_, before := datafilesUsage(t, path)
+
+	now = now.Add(2 * time.Minute)
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	if db.Len() != 10 {
+		t.Errorf("keys after merge, want: 10, got: %d", db.Len())
+	}
+	_, after := datafilesUsage(t, path)
+	if after >= before {
+		t.Errorf("disk usage after merge, want less than %d, got: %d", before, after)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// the expired keys are gone from the datafiles too
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		if key := []byte(fmt.Sprintf("ttl-%d", i)); db.Has(key) {
+			t.Errorf("has %s, want: false, got: true", key)
+		}
+		if key := []byte(fmt.Sprintf("key-%d", i)); !db.Has(key) {
+			t.Errorf("has %s, want: true, got: false", key)
+		}
+	}
+}
+
+func TestDatafileGap(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	// datafile i holds k=value-i and key-i
+	for i := 0; i < 5; i++ {
+		db.Put([]byte("k"), []byte(fmt.Sprintf("value-%05d", i)))
+		db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%05d", i)))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	os.Remove(filepath.Join(path, "index"))
+	hints, _ := filepath.Glob(filepath.Join(path, "*.hint"))
+	for _, hint := range hints {
+		os.Remove(hint)
+	}
+	// keep datafiles 1, 3 and 4, and datafile 2 under a name parsing to its id
+	os.Remove(filepath.Join(path, "000000000.data"))
+	os.Rename(filepath.Join(path, "000000002.data"), filepath.Join(path, "00000000002.data"))
+
+	db, err = Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if got, err := db.Get([]byte("k")); err != nil || string(got) != "value-00004" {
+		t.Errorf("get k, want: value-00004, got: %s (%v)", got, err)
+	}
+	for i := 0; i < 5; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if want := i != 0 && i != 2; db.Has(key) != want {
+			t.Errorf("has %s, want: %v, got: %v", key, want, !want)
+		}
+	}
+	if stats, _ := db.Stats(false); stats.Datafiles != 4 {
+		t.Errorf("datafiles, want: 1, 3, 4 and a new one, got: %d", stats.Datafiles)
+	}
+}
+
+func TestRotateAfterGap(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 6; i++ {
+		db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%05d", i)))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	// datafiles 0, 2 and 7
+	os.Remove(filepath.Join(path, "000000001.data"))
+	os.Remove(filepath.Join(path, "000000001.hint"))
+	os.Rename(filepath.Join(path, "000000002.data"), filepath.Join(path, "000000007.data"))
+	os.Rename(filepath.Join(path, "000000002.hint"), filepath.Join(path, "000000007.hint"))
+	os.Remove(filepath.Join(path, "index"))
+
+	db, err = Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if id := db.curr.FileID(); id != 8 {
+		t.Fatalf("current datafile, want: 8, got: %d", id)
+	}
+	// a file appearing at the next id isn't written to
+	stray := filepath.Join(path, "000000009.data")
+	if err := ioutil.WriteFile(stray, []byte("stray"), 0600); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("new-%d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if id := db.curr.FileID(); id != 10 {
+		t.Errorf("current datafile after rotating, want: 10, got: %d", id)
+	}
+	if data, _ := ioutil.ReadFile(stray); string(data) != "stray" {
+		t.Errorf("stray file, want: stray, got: %q", data)
+	}
+	for i := 0; i < 3; i++ {
+		want := fmt.Sprintf("value-%05d", i)
+		if got, err := db.Get([]byte(fmt.Sprintf("new-%d", i))); err != nil || string(got) != want {
+			t.Errorf("get, want: %s, got: %s (%v)", want, got, err)
+		}
+	}
+}
+
+func TestRotationBoundary(t *testing.T) {
+	// every entry takes 50 bytes: a 33 byte header and checksum, expiry and
+	// timestamp trailer, a 6 byte key and an 11 byte value
+	for _, max := range []int{99, 100, 101, 150} {
+		t.Run(fmt.Sprint(max), func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path, WithMaxDatafileSize(max))
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			for i := 0; i < 20; i++ {
+				if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+					t.Fatalf("put error: %v", err)
+				}
+			}
+			if n, size := datafilesUsage(t, path); n < 2 || size != 20*50 {
+				t.Errorf("datafiles, want several holding %d bytes, got: %d holding %d", 20*50, n, size)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+
+			for _, rebuild := range []bool{false, true} {
+				if rebuild {
+					os.Remove(filepath.Join(path, "index"))
+				}
+				db, err := Open(path, WithMaxDatafileSize(max))
+				if err != nil {
+					t.Fatalf("reopen error: %v", err)
+				}
+				for i := 0; i < 20; i++ {
+					key := []byte(fmt.Sprintf("key-%02d", i))
+					want := []byte(fmt.Sprintf("value-%05d", i))
+					if got, err := db.Get(key); err != nil || !bytes.Equal(got, want) {
+						t.Errorf("get %s (rebuild %v), want: %s, got: %s (%v)", key, rebuild, want, got, err)
+					}
+				}
+				if err := db.Close(); err != nil {
+					t.Fatalf("close error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRotateBeforeWrite(t *testing.T) {
+	// every entry takes 50 bytes, see TestRotationBoundary
+	tests := []struct {
+		max       int
+		datafiles int
+	}{
+		{max: 100, datafiles: 5}, // two entries fit exactly
+		{max: 99, datafiles: 10}, // the second entry would go over
+		{max: 149, datafiles: 5}, // the third entry would go over
+		{max: 40, datafiles: 10}, // entries larger than the max get their own datafile
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.max), func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path, WithMaxDatafileSize(test.max))
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			defer db.Close()
+			for i := 0; i < 10; i++ {
+				if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+					t.Fatalf("put error: %v", err)
+				}
+			}
+			fns, _ := filepath.Glob(filepath.Join(path, "*.data"))
+			if len(fns) != test.datafiles {
+				t.Errorf("datafiles, want: %d, got: %d", test.datafiles, len(fns))
+			}
+			for _, fn := range fns {
+				fi, err := os.Stat(fn)
+				if err != nil {
+					t.Fatalf("stat error: %v", err)
+				}
+				if fi.Size() > int64(test.max) && fi.Size() != 50 {
+					t.Errorf("datafile %s size, want at most %d, got: %d", fn, test.max, fi.Size())
+				}
+			}
+		})
+	}
+}
+
+func TestSyncAfterRotation(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	// stop without a clean Close, so no index is saved
+	close(db.done)
+	db.wg.Wait()
+	for _, df := range db.datafiles {
+		df.Close()
+	}
+	db.curr.Close()
+	db.flock.Close()
+
+	db, err = Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 5; i++ {
+		want := fmt.Sprintf("value-%05d", i)
+		if got, err := db.Get([]byte(fmt.Sprintf("key-%02d", i))); err != nil || string(got) != want {
+			t.Errorf("get, want: %s, got: %s (%v)", want, got, err)
+		}
+	}
+}
+
+// abandon stops db without a clean Close, leaving its index file as it was
+func abandon(db *Bitcask) {
+	close(db.done)
+	db.wg.Wait()
+	for _, df := range db.datafiles {
+		df.Close()
+	}
+	db.curr.Close()
+	db.flock.Close()
+}
+
+func TestWatermarkReplay(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	want := make(map[string]string)
+	put := func(i, round int) {
+		key, value := fmt.Sprintf("key-%02d", i), fmt.Sprintf("value-%05d", round*100+i)
+		if err := db.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+		want[key] = value
+	}
+	check := func() {
+		if db.Len() != len(want) {
+			t.Errorf("len, want: %d, got: %d", len(want), db.Len())
+		}
+		for key, value := range want {
+			if got, err := db.Get([]byte(key)); err != nil || string(got) != value {
+				t.Errorf("get %s, want: %s, got: %s (%v)", key, value, got, err)
+			}
+		}
+	}
+	for i := 0; i < 4; i++ {
+		put(i, 0)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// the index reflects datafiles 0 and 1, entries after it overwrite,
+	// delete and add keys across datafiles 2 to 4
+	db, err = Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	put(0, 1)
+	if err := db.Delete([]byte("key-01")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	delete(want, "key-01")
+	for i := 4; i < 8; i++ {
+		put(i, 1)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	abandon(db)
+
+	// datafile 0 isn't read again, corrupting it leaves Open unaffected
+	fn := filepath.Join(path, "000000000.data")
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatalf("stat error: %v", err)
+	}
+	f, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open datafile error: %v", err)
+	}
+	f.WriteAt([]byte{0xff}, 20)
+	f.Close()
+	os.Chtimes(fn, fi.ModTime(), fi.ModTime())
+
+	db, err = Open(path, WithMaxDatafileSize(100), WithStrictRecovery(true))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	check()
+	db.Close()
+
+	// without the watermark the whole datafile is replayed
+	if _, err := Open(path, WithMaxDatafileSize(100), WithStrictRecovery(true), WithForceIndexRebuild()); err == nil {
+		t.Errorf("open rebuilding the index, want: checksum error, got: nil")
+	}
+}
+
+func TestWatermarkMidDatafile(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	l := db.NewBulkLoader()
+	for i := 0; i < 3; i++ {
+		if err := l.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("old")); err != nil {
+			t.Fatalf("bulk put error: %v", err)
+		}
+	}
+	// saves the index partway through the current datafile
+	if err := l.Finish(); err != nil {
+		t.Fatalf("finish error: %v", err)
+	}
+	if err := db.Put([]byte("key-0"), []byte("new")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Delete([]byte("key-1")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.Put([]byte("key-3"), []byte("new")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	abandon(db)
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	want := map[string]string{"key-0": "new", "key-2": "old", "key-3": "new"}
+	if db.Len() != len(want) {
+		t.Errorf("len, want: %d, got: %d", len(want), db.Len())
+	}
+	for key, value := range want {
+		if got, err := db.Get([]byte(key)); err != nil || string(got) != value {
+			t.Errorf("get %s, want: %s, got: %s (%v)", key, value, got, err)
+		}
+	}
+}
+
+func TestMergeFiles(t *testing.T) {
+	path := tempDir(t)
+	// two 50 byte entries per datafile, see TestRotationBoundary
+	db, err := Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	want := make(map[string]string)
+	put := func(i, round int) {
+		key, value := fmt.Sprintf("key-%02d", i), fmt.Sprintf("value-%05d", round*100+i)
+		if err := db.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+		want[key] = value
+	}
+	// datafiles 0 to 4 hold keys 0 to 9, datafiles 5 to 7 updates of keys 0 to 4
+	for i := 0; i < 10; i++ {
+		put(i, 0)
+	}
+	for i := 0; i < 5; i++ {
+		put(i, 1)
+	}
+	newer := make(map[string][]byte)
+	for id := 3; id < 7; id++ {
+		fn := filepath.Join(path, fmt.Sprintf("%09d.data", id))
+		newer[fn], _ = ioutil.ReadFile(fn)
+	}
+
+	if err := db.MergeFiles(3); err != nil {
+		t.Fatalf("merge files error: %v", err)
+	}
+	for id := 0; id < 3; id++ {
+		if _, err := os.Stat(filepath.Join(path, fmt.Sprintf("%09d.data", id))); !os.IsNotExist(err) {
+			t.Errorf("datafile %d after merge, want removed, got: %v", id, err)
+		}
+	}
+	for fn, data := range newer {
+		if got, err := ioutil.ReadFile(fn); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("datafile %s changed by merge (%v)", fn, err)
+		}
+	}
+	check := func() {
+		t.Helper()
+		for key, value := range want {
+			if got, err := db.Get([]byte(key)); err != nil || string(got) != value {
+				t.Errorf("get %s, want: %s, got: %s (%v)", key, value, got, err)
+			}
+		}
+	}
+	check()
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	check()
+	if db.Len() != 10 {
+		t.Errorf("keys after reopen, want: 10, got: %d", db.Len())
+	}
+}
+
+func TestDatafileMissing(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	// point the index at a datafile that doesn't exist
+	db.t.Insert([]byte("key"), internal.Item{FileID: 42, Offset: 0, Size: 10})
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Get([]byte("key")); !errors.Is(err, ErrDatafileMissing) {
+		t.Errorf("get, want: %v, got: %v", ErrDatafileMissing, err)
+	}
+	if err := db.Fold(func(key, value []byte) error { return nil }); !errors.Is(err, ErrDatafileMissing) {
+		t.Errorf("fold, want: %v, got: %v", ErrDatafileMissing, err)
+	}
+}
+
+func TestRecoveryChecksum(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprint(strict), func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path)
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			db.Put([]byte("a"), []byte("value"))
+			db.Put([]byte("b"), []byte("value"))
+			if err := db.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+
+			// flip the first value byte of a, then rebuild from the datafile
+			f, err := os.OpenFile(filepath.Join(path, "000000000.data"), os.O_RDWR, 0)
+			if err != nil {
+				t.Fatalf("open datafile error: %v", err)
+			}
+			if _, err := f.WriteAt([]byte("V"), 4+8+1+1); err != nil {
+				t.Fatalf("corrupt datafile error: %v", err)
+			}
+			f.Close()
+			os.Remove(filepath.Join(path, "index"))
+			os.Remove(filepath.Join(path, "000000000.hint"))
+
+			logger := &captureLogger{}
+			db, err = Open(path, WithStrictRecovery(strict), WithLogger(logger))
+			if strict {
+				if !errors.Is(err, ErrChecksumFailed) {
+					t.Errorf("strict open, want: %v, got: %v", ErrChecksumFailed, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reopen error: %v", err)
+			}
+			defer db.Close()
+			if db.Has([]byte("a")) || !db.Has([]byte("b")) {
+				t.Errorf("has a/b, want: false/true, got: %v/%v", db.Has([]byte("a")), db.Has([]byte("b")))
+			}
+			if logger.count("skipping corrupt entry") != 1 {
+				t.Errorf("corrupt entry lines, want: 1, got: %q", logger.lines)
+			}
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	for _, kind := range []string{IndexART, IndexHashmap} {
+		t.Run(kind, func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path, WithIndex(kind))
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			for _, key := range []string{"b", "c", "ab", "a"} {
+				if err := db.Put([]byte(key), []byte("value-"+key)); err != nil {
+					t.Fatalf("put error: %v", err)
+				}
+			}
+			if err := db.Delete([]byte("c")); err != nil {
+				t.Fatalf("delete error: %v", err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+
+			db, err = Open(path, WithIndex(kind))
+			if err != nil {
+				t.Fatalf("reopen error: %v", err)
+			}
+			defer db.Close()
+			if db.Len() != 3 {
+				t.Errorf("len, want: %d, got: %d", 3, db.Len())
+			}
+			got, err := db.Get([]byte("ab"))
+			if err != nil || string(got) != "value-ab" {
+				t.Errorf("get, want: %s, got: %s (%v)", "value-ab", got, err)
+			}
+			var keys []string
+			db.Scan([]byte("a"), func(key []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			})
+			if strings.Join(keys, ",") != "a,ab" {
+				t.Errorf("scan, want: %s, got: %s", "a,ab", strings.Join(keys, ","))
+			}
+			keys = keys[:0]
+			db.Fold(func(key, value []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			})
+			if strings.Join(keys, ",") != "a,ab,b" {
+				t.Errorf("fold, want: %s, got: %s", "a,ab,b", strings.Join(keys, ","))
+			}
+		})
+	}
+}
+
+func TestKeyTransformOnce(t *testing.T) {
+	// a transform applied twice gives another key than applied once
+	prefix := WithKeyTransform(func(key []byte) []byte {
+		return append([]byte("n:"), key...)
+	})
+	db, err := Open(tempDir(t), prefix, WithMaxDatafileSize(64))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	if n := db.Len(); n != 10 {
+		t.Errorf("len after merge, want: 10, got: %d", n)
+	}
+	for i := 0; i < 10; i++ {
+		key, want := []byte(fmt.Sprintf("key-%d", i)), fmt.Sprintf("value-%d", i)
+		if got, err := db.Get(key); err != nil || string(got) != want {
+			t.Errorf("get %s after merge, want: %s, got: %s (%v)", key, want, got, err)
+		}
+		if !snap.Has(key) {
+			t.Errorf("snapshot has %s, want: true, got: false", key)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := db.Export(&buf); err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	imported, err := OpenInMemory(prefix)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer imported.Close()
+	if err := imported.Import(&buf); err != nil {
+		t.Fatalf("import error: %v", err)
+	}
+	if got, err := imported.Get([]byte("key-3")); err != nil || string(got) != "value-3" {
+		t.Errorf("get imported key, want: value-3, got: %s (%v)", got, err)
+	}
+}
+
+func TestKeyTransformRange(t *testing.T) {
+	// a transform applied twice gives another key than applied once
+	db, err := OpenInMemory(WithKeyTransform(func(key []byte) []byte {
+		return append([]byte("n:"), key...)
+	}))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := db.Put([]byte(key), []byte("value-"+key)); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	var keys []string
+	err = db.Range([]byte("b"), []byte("d"), func(key, _ []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("range error: %v", err)
+	}
+	if strings.Join(keys, ",") != "b,c" {
+		t.Errorf("range, want: %s, got: %s", "b,c", strings.Join(keys, ","))
+	}
+}
+
+func TestKeyTransform(t *testing.T) {
+	path := tempDir(t)
+	lower := WithKeyTransform(bytes.ToLower)
+	db, err := Open(path, lower)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("1")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if got, err := db.Get([]byte("KEY")); err != nil || string(got) != "1" {
+		t.Errorf("get, want: %s, got: %s (%v)", "1", got, err)
+	}
+	if !db.Has([]byte("Key")) {
+		t.Errorf("has, want: true, got: false")
+	}
+	if err := db.Put([]byte("KEY"), []byte("2")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Put([]byte("Other"), []byte("3")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if db.Len() != 2 {
+		t.Errorf("len, want: %d, got: %d", 2, db.Len())
+	}
+	// Fold passes keys as written
+	var keys []string
+	db.Fold(func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if strings.Join(keys, ",") != "KEY,Other" {
+		t.Errorf("fold, want: %s, got: %s", "KEY,Other", strings.Join(keys, ","))
+	}
+	if err := db.Delete([]byte("oTHER")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	for _, opts := range [][]Option{{lower}, {lower, WithForceIndexRebuild()}} {
+		db, err := Open(path, opts...)
+		if err != nil {
+			t.Fatalf("reopen error: %v", err)
+		}
+		if got, err := db.Get([]byte("kEy")); err != nil || string(got) != "2" {
+			t.Errorf("get after reopen, want: %s, got: %s (%v)", "2", got, err)
+		}
+		if db.Has([]byte("other")) {
+			t.Errorf("deleted key found after reopen")
+		}
+		db.Close()
+	}
+}
+
+func TestStaleIndex(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	cfg := db.cfg
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	// appends to the datafile behind the index's back, setting the index
+	// file's time to when it's meant to be
+	appendEntry := func(key string, indexTime time.Time) {
+		df, err := data.NewDatafile(path, 0, false, cfg)
+		if err != nil {
+			t.Fatalf("open datafile error: %v", err)
+		}
+		if _, _, err := df.Write(internal.NewEntry([]byte(key), []byte("2"))); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+		if err := df.Close(); err != nil {
+			t.Fatalf("close datafile error: %v", err)
+		}
+		if err := os.Chtimes(filepath.Join(path, "index"), indexTime, indexTime); err != nil {
+			t.Fatalf("chtimes error: %v", err)
+		}
+	}
+
+	appendEntry("b", time.Now().Add(-time.Hour))
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if !db.Has([]byte(key)) {
+			t.Errorf("key %s missing after stale index", key)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	appendEntry("c", time.Now().Add(time.Hour))
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	if db.Has([]byte("c")) {
+		t.Errorf("key written after a newer index found without rebuilding")
+	}
+	db.Close()
+	db, err = Open(path, WithForceIndexRebuild())
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		if !db.Has([]byte(key)) {
+			t.Errorf("key %s missing after forced rebuild", key)
+		}
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	value := []byte("value")
+	for _, kind := range []string{IndexART, IndexHashmap} {
+		db, err := Open(tempDir(b), WithIndex(kind), WithMaxDatafileSize(1<<30))
+		if err != nil {
+			b.Fatalf("open error: %v", err)
+		}
+		keys := make([][]byte, 10000)
+		for i := range keys {
+			keys[i] = []byte(fmt.Sprintf("key-%d", i))
+		}
+		b.Run(kind+"/Put", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := db.Put(keys[i%len(keys)], value); err != nil {
+					b.Fatalf("put error: %v", err)
+				}
+			}
+		})
+		b.Run(kind+"/Get", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Get(keys[i%len(keys)]); err != nil && err != ErrKeyNotFound {
+					b.Fatalf("get error: %v", err)
+				}
+			}
+		})
+		db.Close()
+	}
+}
+
+type countingMetrics struct {
+	hits, misses, puts, deletes, merges int
+	reclaimed                           int64
+}
+
+func (m *countingMetrics) RecordGet(hit bool, d time.Duration) {
+	if hit {
+		m.hits++
+	} else {
+		m.misses++
+	}
+}
+
+func (m *countingMetrics) RecordPut(d time.Duration)    { m.puts++ }
+func (m *countingMetrics) RecordDelete(d time.Duration) { m.deletes++ }
+
+func (m *countingMetrics) RecordMerge(reclaimed int64) {
+	m.merges++
+	m.reclaimed += reclaimed
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := &countingMetrics{}
+	db, err := Open(tempDir(t), WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 3; i++ {
+		if err := db.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour); err != nil {
+		t.Fatalf("put with ttl error: %v", err)
+	}
+	db.Get([]byte("key"))
+	db.Get([]byte("missing"))
+	db.Get([]byte("missing"))
+	if err := db.Delete([]byte("ttl")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.Put(bytes.Repeat([]byte("k"), 100), []byte("value")); err != ErrKeyTooLarge {
+		t.Fatalf("put large key, want: %v, got: %v", ErrKeyTooLarge, err)
+	}
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+
+	want := countingMetrics{hits: 1, misses: 2, puts: 4, deletes: 1, merges: 1}
+	want.reclaimed = metrics.reclaimed
+	if *metrics != want {
+		t.Errorf("metrics, want: %+v, got: %+v", want, *metrics)
+	}
+	if metrics.reclaimed <= 0 {
+		t.Errorf("reclaimed, want: > 0, got: %d", metrics.reclaimed)
+	}
+}
+
+// openDatafiles counts the descriptors of this process open on datafiles in
+// path, skipping the test where they can't be listed
+func openDatafiles(t *testing.T, path string) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("listing open files: %v", err)
+	}
+	n := 0
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && filepath.Dir(target) == path && strings.HasSuffix(target, ".data") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path, WithMaxOpenFiles(3))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if stats, _ := db.Stats(false); stats.Datafiles < 10 {
+		t.Fatalf("datafiles, want: >= 10, got: %d", stats.Datafiles)
+	}
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 40; i++ {
+			want := fmt.Sprintf("value-%05d", i)
+			got, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+			if err != nil || string(got) != want {
+				t.Errorf("get, want: %s, got: %s (%v)", want, got, err)
+			}
+		}
+	}
+	// the 3 read-only datafiles plus the reader and writer of the current one
+	if n := openDatafiles(t, path); n > 3+2 {
+		t.Errorf("open datafiles, want: <= %d, got: %d", 3+2, n)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(100), WithBloomFilter())
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path, WithBloomFilter())
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if len(db.blooms) == 0 {
+		t.Fatalf("bloom filters, want: > 0, got: 0")
+	}
+	for i := 0; i < 40; i++ {
+		key := []byte(fmt.Sprintf("key-%02d", i))
+		// two entries of 50 bytes fit in each datafile
+		if id, err := db.GetFrom(key); err != nil || id != i/2 {
+			t.Errorf("get from %s, want: %d, got: %d (%v)", key, i/2, id, err)
+		}
+	}
+	if _, err := db.GetFrom([]byte("missing")); err != ErrKeyNotFound {
+		t.Errorf("get from missing key, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	// 50-byte entries, two per datafile: key-00 and key-01 fill datafile 0
+	for i := 0; i < 6; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i+10))); err != nil {
+			t.Fatalf("overwrite error: %v", err)
+		}
+	}
+	usage, err := db.DiskUsage()
+	if err != nil {
+		t.Fatalf("disk usage error: %v", err)
+	}
+	if len(usage) != 4 {
+		t.Fatalf("files, want: %d, got: %d", 4, len(usage))
+	}
+	var total int64
+	for i, u := range usage {
+		if u.LiveBytes+u.DeadBytes != u.TotalBytes {
+			t.Errorf("file %d, want live + dead = total, got: %+v", u.FileID, u)
+		}
+		if i > 0 && u.DeadBytes > usage[0].DeadBytes {
+			t.Errorf("file %d dead bytes, want less than the first file's %d, got: %d", u.FileID, usage[0].DeadBytes, u.DeadBytes)
+		}
+		total += u.TotalBytes
+	}
+	if usage[0].DeadBytes != 100 {
+		t.Errorf("first file dead bytes, want: %d, got: %d", 100, usage[0].DeadBytes)
+	}
+	if total != db.SizeOnDisk() {
+		t.Errorf("total bytes, want: %d, got: %d", db.SizeOnDisk(), total)
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	now := time.Unix(1600000000, 0)
+	db.clock = func() time.Time { return now }
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if got, err := db.LastModified([]byte("key")); err != nil || !got.Equal(now) {
+		t.Errorf("last modified, want: %v, got: %v (%v)", now, got, err)
+	}
+	now = now.Add(time.Minute)
+	if err := db.Put([]byte("key"), []byte("value-2")); err != nil {
+		t.Fatalf("overwrite error: %v", err)
+	}
+	if got, err := db.LastModified([]byte("key")); err != nil || !got.Equal(now) {
+		t.Errorf("last modified after overwrite, want: %v, got: %v (%v)", now, got, err)
+	}
+	if _, err := db.LastModified([]byte("missing")); err != ErrKeyNotFound {
+		t.Errorf("last modified of missing key, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// from the index file, and from the hint files once it's gone
+	for _, removeIndex := range []bool{false, true} {
+		if removeIndex {
+			os.Remove(filepath.Join(path, "index"))
+		}
+		db, err = Open(path)
+		if err != nil {
+			t.Fatalf("reopen error: %v", err)
+		}
+		if got, err := db.LastModified([]byte("key")); err != nil || !got.Equal(now) {
+			t.Errorf("last modified after reopen, want: %v, got: %v (%v)", now, got, err)
+		}
+		db.Close()
+	}
+}
+
+func TestEmptyKey(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte{}, []byte("value")); err != ErrEmptyKey {
+		t.Errorf("put empty key, want: %v, got: %v", ErrEmptyKey, err)
+	}
+	if err := db.Put(nil, []byte("value")); err != ErrEmptyKey {
+		t.Errorf("put nil key, want: %v, got: %v", ErrEmptyKey, err)
+	}
+	if err := db.Delete([]byte{}); err != ErrEmptyKey {
+		t.Errorf("delete empty key, want: %v, got: %v", ErrEmptyKey, err)
+	}
+	if err := db.PutMany([]KV{{Key: []byte("key"), Value: []byte("value")}, {Key: []byte{}}}); err != ErrEmptyKey {
+		t.Errorf("put many with an empty key, want: %v, got: %v", ErrEmptyKey, err)
+	}
+	if err := db.NewBatch().Put([]byte{}, []byte("value")); err != ErrEmptyKey {
+		t.Errorf("batch put empty key, want: %v, got: %v", ErrEmptyKey, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// nothing was written, so the datafiles still decode
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path, WithStrictRecovery(true))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if db.Len() != 0 {
+		t.Errorf("len, want: %d, got: %d", 0, db.Len())
+	}
+}
+
+func TestWriteBuffer(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithWriteBuffer(4096))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	fn := db.curr.Name()
+	if fi, err := os.Stat(fn); err != nil || fi.Size() != 0 {
+		t.Errorf("datafile size before sync, want: 0, got: %d (%v)", fi.Size(), err)
+	}
+	// reads see buffered entries
+	if got, err := db.Get([]byte("key-09")); err != nil || string(got) != "value" {
+		t.Errorf("get buffered entry, want: %s, got: %s (%v)", "value", got, err)
+	}
+
+	if err := db.Put([]byte("key-10"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	n := 0
+	err = data.ScanDatafile(fn, db.cfg, func(internal.Entry, int64, int64) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 11 {
+		t.Errorf("entries on disk after sync, want: %d, got: %d (%v)", 11, n, err)
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithWriteBuffer(4096), WithSyncInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	// the buffered entries reach the datafile without an explicit Sync
+	fn := db.curr.Name()
+	deadline := time.Now().Add(time.Second)
+	for {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			t.Fatalf("stat error: %v", err)
+		}
+		if fi.Size() == db.curr.Size() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("datafile size, want: %d, got: %d", db.curr.Size(), fi.Size())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// a copy of the datafile as a crash would leave it
+	crashed := tempDir(t)
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(crashed, filepath.Base(fn)), data, 0600); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	copied, err := Open(crashed)
+	if err != nil {
+		t.Fatalf("open copy error: %v", err)
+	}
+	defer copied.Close()
+	if copied.Len() != 10 {
+		t.Errorf("keys after crash, want: %d, got: %d", 10, copied.Len())
+	}
+}
+
+func TestGetWithMeta(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	before := time.Now().UnixNano()
+	if err := db.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	after := time.Now().UnixNano()
+	value, meta, err := db.GetWithMeta([]byte("b"))
+	if err != nil || string(value) != "2" {
+		t.Fatalf("get with meta, want: %s, got: %s (%v)", "2", value, err)
+	}
+	// two 35 byte entries, see TestRotationBoundary
+	if meta.FileID != 0 || meta.Offset != 35 || meta.Size != 35 {
+		t.Errorf("meta location, want: 0/35/35, got: %d/%d/%d", meta.FileID, meta.Offset, meta.Size)
+	}
+	if meta.Timestamp < before || meta.Timestamp > after {
+		t.Errorf("meta timestamp, want: between %d and %d, got: %d", before, after, meta.Timestamp)
+	}
+	if _, _, err := db.GetWithMeta([]byte("c")); err != ErrKeyNotFound {
+		t.Errorf("get with meta of a missing key, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if _, got, err := db.GetWithMeta([]byte("b")); err != nil || got != meta {
+		t.Errorf("meta after reopen, want: %+v, got: %+v (%v)", meta, got, err)
+	}
+}
+
+func TestCorruptSize(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	// an index claiming a 1TB entry
+	item := internal.Item{FileID: 0, Offset: 0, Size: 1 << 40}
+	tree := index.NewTree(index.ART)
+	tree.Insert([]byte("key"), item)
+	wm := index.Watermark{FileID: 1}
+	if err := index.NewIndexer(internal.DiscardLogger, 0600).Save(tree, filepath.Join(path, "index"), wm); err != nil {
+		t.Fatalf("save index error: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if got, _ := db.t.Search([]byte("key")); got != item {
+		t.Fatalf("crafted item, want: %+v, got: %+v", item, got)
+	}
+	if _, err := db.Get([]byte("key")); !errors.Is(err, ErrCorruptSize) {
+		t.Errorf("get, want: %v, got: %v", ErrCorruptSize, err)
+	}
+}
+
+func TestGetIOError(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	item, _ := db.t.Search([]byte("b"))
+	// cuts the last entry short
+	if err := os.Truncate(db.curr.Name(), item.Offset+1); err != nil {
+		t.Fatalf("truncate error: %v", err)
+	}
+	_, err = db.Get([]byte("b"))
+	ioErr, ok := AsIOError(err)
+	if !ok {
+		t.Fatalf("get error, want: IOError, got: %v", err)
+	}
+	if ioErr.FileID != item.FileID || ioErr.Offset != item.Offset {
+		t.Errorf("get error location, want: %d/%d, got: %d/%d", item.FileID, item.Offset, ioErr.FileID, ioErr.Offset)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithWriteBuffer(4096))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	f, err := os.Open(db.curr.Name())
+	if err != nil {
+		t.Fatalf("open datafile error: %v", err)
+	}
+	defer f.Close()
+	if data, _ := ioutil.ReadAll(f); len(data) != 0 {
+		t.Errorf("datafile bytes before flush, want: 0, got: %d", len(data))
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if want := db.curr.Size(); int64(len(data)) != want {
+		t.Errorf("datafile bytes after flush, want: %d, got: %d", want, len(data))
+	}
+	if !bytes.Contains(data, []byte("key-09")) {
+		t.Errorf("flushed datafile doesn't contain the last key")
+	}
+}
+
+func BenchmarkWriteBuffer(b *testing.B) {
+	value := bytes.Repeat([]byte("v"), 100)
+	benchmarks := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "Unbuffered"},
+		{name: "Buffered", opts: []Option{WithWriteBuffer(64 << 10)}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			db, err := Open(tempDir(b), bm.opts...)
+			if err != nil {
+				b.Fatalf("open error: %v", err)
+			}
+			defer db.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Put([]byte(fmt.Sprintf("key-%d", i%1000)), value); err != nil {
+					b.Fatalf("put error: %v", err)
+				}
+			}
+		})
 	}
 }
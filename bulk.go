@@ -0,0 +1,178 @@
+package bitcask
+
+import (
+	"path/filepath"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/data/codec"
+)
+
+// KV is a key/value pair
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// GetMany retrieves the values of keys under a single lock, in the same order
+// as keys. The value of a key not found is nil. If an IO error occurs or a
+// checksum fails the error is returned.
+func (b *Bitcask) GetMany(keys [][]byte) ([][]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		item, found := b.t.Search(key)
+		if !found {
+			continue
+		}
+		e, err := b.read(item)
+		if err != nil {
+			return nil, err
+		}
+		if b.expired(e) {
+			continue
+		}
+		values[i] = e.Value
+	}
+	return values, nil
+}
+
+// PutMany stores all pairs under a single lock. The sizes of all pairs are
+// checked before any is written, so nothing is stored if one is too large.
+// If an IO error occurs the pairs before the failing one are stored.
+func (b *Bitcask) PutMany(pairs []KV) error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	for _, pair := range pairs {
+		if err := b.checkKey(pair.Key); err != nil {
+			return err
+		}
+		if uint64(len(pair.Value)) > b.cfg.MaxValueSize {
+			return ErrValueTooLarge
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	for _, pair := range pairs {
+		item, err := b.put(pair.Key, pair.Value)
+		if err != nil {
+			return err
+		}
+		b.insert(pair.Key, item)
+	}
+	return b.maybeSync()
+}
+
+// bulkFlushEntries and bulkFlushBytes are how many entries, or bytes of keys
+// and values, a BulkLoader buffers before writing them
+var (
+	bulkFlushEntries = 10000
+	bulkFlushBytes   = 4 << 20
+)
+
+// BulkLoader stores many keys faster than Put by buffering them and writing
+// each batch to the datafiles at once and to the index under a single lock,
+// without syncing after each write. Keys become visible batch by batch,
+// Finish writes the last batch and syncs the datafile and the index. The keys
+// and values passed to Put must not be modified until then. A BulkLoader is
+// not safe for concurrent use.
+type BulkLoader struct {
+	db      *Bitcask
+	entries []internal.Entry
+	size    int
+}
+
+// NewBulkLoader returns a BulkLoader storing keys in the database
+func (b *Bitcask) NewBulkLoader() *BulkLoader {
+	return &BulkLoader{db: b}
+}
+
+// Put adds storing key and value, writing the buffered keys once there are
+// enough of them
+func (l *BulkLoader) Put(key, value []byte) error {
+	if err := l.db.checkPut(key, value); err != nil {
+		return err
+	}
+	l.entries = append(l.entries, l.db.newEntry(key, value))
+	l.size += len(key) + len(value)
+	if len(l.entries) >= bulkFlushEntries || l.size >= bulkFlushBytes {
+		return l.flush()
+	}
+	return nil
+}
+
+// Finish writes the buffered keys and syncs the current datafile and the
+// index to disk
+func (l *BulkLoader) Finish() error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	db := l.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.curr.Sync(); err != nil {
+		return err
+	}
+	return db.saveIndex(filepath.Join(db.path, "index"))
+}
+
+// flush writes the buffered entries, as many at once as fit in the current
+// datafile, and then adds them to the index. If a write fails the entries
+// written before it are kept.
+func (l *BulkLoader) flush() error {
+	if len(l.entries) == 0 {
+		return nil
+	}
+	db := l.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	encrypted := db.cfg.EncryptionKey != nil
+	var (
+		entries = l.entries
+		err     error
+	)
+	for len(entries) > 0 {
+		size := codec.EncodedSize(entries[0], encrypted, db.cfg.ChecksumSize())
+		if err = db.rotateFor(size); err != nil {
+			break
+		}
+		n, total := 1, db.curr.Size()+size
+		for ; n < len(entries); n++ {
+			size = codec.EncodedSize(entries[n], encrypted, db.cfg.ChecksumSize())
+			if total+size > int64(db.cfg.MaxDatafileSize) {
+				break
+			}
+			total += size
+		}
+		var offsets, sizes []int64
+		if offsets, sizes, err = db.curr.WriteMany(entries[:n]); err != nil {
+			break
+		}
+		for i, e := range entries[:n] {
+			db.size += sizes[i]
+			db.insert(e.Key, internal.Item{
+				FileID:    db.curr.FileID(),
+				Offset:    offsets[i],
+				Size:      sizes[i],
+				Timestamp: e.Timestamp,
+			})
+		}
+		entries = entries[n:]
+	}
+	l.entries = l.entries[:0]
+	l.size = 0
+	return err
+}
@@ -0,0 +1,158 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestGetManyPutMany(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxKeySize(16))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	var pairs []KV
+	for i := 0; i < 100; i++ {
+		pairs = append(pairs, KV{Key: []byte(fmt.Sprintf("key-%d", i)), Value: []byte(fmt.Sprintf("value-%d", i))})
+	}
+	if err := db.PutMany(pairs); err != nil {
+		t.Fatalf("put many error: %v", err)
+	}
+	db.Delete([]byte("key-5"))
+
+	keys := [][]byte{[]byte("missing")}
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key)
+	}
+	values, err := db.GetMany(keys)
+	if err != nil {
+		t.Fatalf("get many error: %v", err)
+	}
+	if len(values) != len(keys) {
+		t.Fatalf("values, want: %d, got: %d", len(keys), len(values))
+	}
+	for i, key := range keys {
+		want, err := db.Get(key)
+		if err != nil && err != ErrKeyNotFound {
+			t.Fatalf("get error: %v", err)
+		}
+		if !bytes.Equal(values[i], want) || (want == nil) != (values[i] == nil) {
+			t.Errorf("get many %s, want: %q, got: %q", key, want, values[i])
+		}
+	}
+
+	err = db.PutMany([]KV{{Key: []byte("ok"), Value: []byte("value")}, {Key: []byte("key too large for it"), Value: []byte("value")}})
+	if err != ErrKeyTooLarge {
+		t.Errorf("put many large key, want: %v, got: %v", ErrKeyTooLarge, err)
+	}
+	if db.Has([]byte("ok")) {
+		t.Errorf("put many with a large key stored pairs")
+	}
+}
+
+func benchmarkPut(b *testing.B, many bool) {
+	db, err := Open(tempDir(b), WithMaxDatafileSize(1<<30))
+	if err != nil {
+		b.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	pairs := make([]KV, 100)
+	for i := range pairs {
+		pairs[i] = KV{Key: []byte(fmt.Sprintf("key-%d", i)), Value: []byte("value")}
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if many {
+				db.PutMany(pairs)
+				continue
+			}
+			for _, pair := range pairs {
+				db.Put(pair.Key, pair.Value)
+			}
+		}
+	})
+}
+
+func BenchmarkPutLoop(b *testing.B) { benchmarkPut(b, false) }
+func BenchmarkPutMany(b *testing.B) { benchmarkPut(b, true) }
+
+func TestBulkLoader(t *testing.T) {
+	defer func(n int) { bulkFlushEntries = n }(bulkFlushEntries)
+	bulkFlushEntries = 100
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(4096))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	l := db.NewBulkLoader()
+	for i := 0; i < 1050; i++ {
+		if err := l.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("value-%04d", i))); err != nil {
+			t.Fatalf("bulk put error: %v", err)
+		}
+	}
+	// the last 50 keys are still buffered
+	if n := db.Len(); n != 1000 {
+		t.Errorf("keys before finish, want: 1000, got: %d", n)
+	}
+	if err := l.Put(bytes.Repeat([]byte("k"), 65), nil); err != ErrKeyTooLarge {
+		t.Errorf("bulk put large key, want: %v, got: %v", ErrKeyTooLarge, err)
+	}
+	if err := l.Finish(); err != nil {
+		t.Fatalf("finish error: %v", err)
+	}
+	if n := db.Len(); n != 1050 {
+		t.Errorf("keys after finish, want: 1050, got: %d", n)
+	}
+	if stats, _ := db.Stats(false); stats.Datafiles < 2 {
+		t.Errorf("datafiles, want: rotated, got: %d", stats.Datafiles)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 1050; i++ {
+		want := fmt.Sprintf("value-%04d", i)
+		if got, err := db.Get([]byte(fmt.Sprintf("key-%04d", i))); err != nil || string(got) != want {
+			t.Errorf("get, want: %s, got: %s (%v)", want, got, err)
+		}
+	}
+}
+
+func benchmarkLoad(b *testing.B, bulk bool) {
+	const keys = 1 << 20
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		db, err := Open(tempDir(b), WithMaxDatafileSize(64<<20))
+		if err != nil {
+			b.Fatalf("open error: %v", err)
+		}
+		b.StartTimer()
+		l := db.NewBulkLoader()
+		for i := 0; i < keys; i++ {
+			key, value := []byte(fmt.Sprintf("key-%d", i)), []byte("value")
+			if bulk {
+				err = l.Put(key, value)
+			} else {
+				err = db.Put(key, value)
+			}
+			if err != nil {
+				b.Fatalf("put error: %v", err)
+			}
+		}
+		if err := l.Finish(); err != nil {
+			b.Fatalf("finish error: %v", err)
+		}
+		b.StopTimer()
+		db.Close()
+	}
+}
+
+func BenchmarkLoadPut(b *testing.B)  { benchmarkLoad(b, false) }
+func BenchmarkLoadBulk(b *testing.B) { benchmarkLoad(b, true) }
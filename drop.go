@@ -0,0 +1,82 @@
+package bitcask
+
+import (
+	"os"
+	"path/filepath"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/data"
+	"jay.com/bitcask/internal/index"
+)
+
+// DropAll deletes all keys at once by removing the index and every datafile
+// along with its hint file and bloom filter, then starts over with an empty
+// datafile. Unlike DeleteAll no tombstones are written, so the store doesn't
+// grow first, but it isn't append-only: a crash while dropping leaves the
+// keys of the datafiles not removed yet, the oldest are removed first. It
+// fails with ErrTxnsOpen while transactions are open, if removing a file
+// fails the database is closed.
+func (b *Bitcask) DropAll() error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if len(b.txns) > 0 {
+		return ErrTxnsOpen
+	}
+	// the ids of the dropped datafiles aren't reused, so that the items of a
+	// Snapshot taken before fail with ErrDatafileMissing rather than reading
+	// the entries written since
+	next := b.nextID()
+	err := b.dropFiles()
+	if err == nil {
+		b.curr, err = b.openDatafile(next, false)
+	}
+	if err != nil {
+		if !b.inMemory {
+			b.fail()
+		}
+		return err
+	}
+	b.publishDeleteAll()
+	b.datafiles = make(map[int]data.DataFile)
+	b.blooms = make(map[int]*index.Bloom)
+	b.t = newTree(b.cfg)
+	b.keys = 0
+	b.size = 0
+	b.cfg.Logger.Printf("dropped all keys")
+	return nil
+}
+
+// dropFiles closes and removes the index and the datafiles, oldest first, with
+// their hint files and bloom filters
+func (b *Bitcask) dropFiles() error {
+	if !b.inMemory {
+		err := os.Remove(filepath.Join(b.path, "index"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, df := range append(getSortedDatafiles(b.datafiles), b.curr) {
+		if err := df.Close(); err != nil {
+			return err
+		}
+		if b.inMemory {
+			continue
+		}
+		if err := os.Remove(df.Name()); err != nil {
+			return err
+		}
+		if err := removeHint(df.Name()); err != nil {
+			return err
+		}
+	}
+	if b.inMemory {
+		return nil
+	}
+	return internal.FsyncDir(b.path)
+}
@@ -0,0 +1,148 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"testing"
+)
+
+func TestDropAll(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(100), WithBloomFilter())
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	// leave an index behind too
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	db, err = Open(path, WithMaxDatafileSize(100), WithBloomFilter())
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+
+	if err := db.DropAll(); err != nil {
+		t.Fatalf("drop all error: %v", err)
+	}
+	if n := db.Len(); n != 0 {
+		t.Errorf("len after drop, want: 0, got: %d", n)
+	}
+	if _, err := db.Get([]byte("key-05")); err != ErrKeyNotFound {
+		t.Errorf("get dropped key, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	if err := db.Put([]byte("new"), []byte("value")); err != nil {
+		t.Fatalf("put after drop error: %v", err)
+	}
+	// the ids of the dropped datafiles aren't reused
+	id := db.curr.FileID()
+	if id == 0 {
+		t.Errorf("datafile id after drop, want: > 0, got: %d", id)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		t.Fatalf("read dir error: %v", err)
+	}
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	want := []string{
+		fmt.Sprintf("%09d.bloom", id), fmt.Sprintf("%09d.data", id), fmt.Sprintf("%09d.hint", id),
+		"config.json", "index",
+	}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("files after drop, want: %v, got: %v", want, names)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen after drop error: %v", err)
+	}
+	defer db.Close()
+	if n := db.Len(); n != 1 {
+		t.Errorf("len after reopen, want: 1, got: %d", n)
+	}
+	if got, err := db.Get([]byte("new")); err != nil || string(got) != "value" {
+		t.Errorf("get after reopen, want: value, got: %s (%v)", got, err)
+	}
+}
+
+func TestDropAllInMemory(t *testing.T) {
+	db, err := OpenInMemory(WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.DropAll(); err != nil {
+		t.Fatalf("drop all error: %v", err)
+	}
+	if n := db.Len(); n != 0 || len(db.datafiles) != 0 {
+		t.Errorf("after drop, want: no keys nor datafiles, got: %d keys, %d datafiles", n, len(db.datafiles))
+	}
+	if err := db.Put([]byte("new"), []byte("value")); err != nil {
+		t.Fatalf("put after drop error: %v", err)
+	}
+	if got, err := db.Get([]byte("new")); err != nil || string(got) != "value" {
+		t.Errorf("get after drop, want: value, got: %s (%v)", got, err)
+	}
+}
+
+func TestDropAllSnapshot(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("a"), []byte("AAAA")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	if err := db.DropAll(); err != nil {
+		t.Fatalf("drop all error: %v", err)
+	}
+	if err := db.Put([]byte("b"), []byte("BBBB")); err != nil {
+		t.Fatalf("put after drop error: %v", err)
+	}
+	if got, err := snap.Get([]byte("a")); !errors.Is(err, ErrDatafileMissing) {
+		t.Errorf("snapshot get after drop, want: %v, got: %s (%v)", ErrDatafileMissing, got, err)
+	}
+}
+
+func TestDropAllTxnsOpen(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	txn := db.Begin()
+	if err := db.DropAll(); err != ErrTxnsOpen {
+		t.Errorf("drop all with open transaction, want: %v, got: %v", ErrTxnsOpen, err)
+	}
+	txn.Rollback()
+	if !db.Has([]byte("key")) {
+		t.Errorf("has key after failed drop, want: true, got: false")
+	}
+}
@@ -0,0 +1,136 @@
+package bitcask
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"jay.com/bitcask/internal"
+)
+
+var errInvalidExportLine = errors.New("error: invalid export line")
+
+// Export writes all live keys and values to w, one pair per line as the
+// base64 encoded key and value separated by a space, followed by the expiry
+// in unix nanoseconds for keys with a TTL. The format doesn't depend on the
+// datafile format, so it can move data between incompatible versions.
+func (b *Bitcask) Export(w io.Writer) (err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	bw := bufio.NewWriter(w)
+	b.t.ForEach(func(_ []byte, item internal.Item) bool {
+		var e internal.Entry
+		if e, err = b.read(item); err != nil {
+			return false
+		}
+		if b.expired(e) {
+			return true
+		}
+		line := base64.StdEncoding.EncodeToString(e.Key) + " " + base64.StdEncoding.EncodeToString(e.Value)
+		if e.Expiry > 0 {
+			line += " " + strconv.FormatInt(e.Expiry, 10)
+		}
+		_, err = bw.WriteString(line + "\n")
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Dump writes all live keys and values to w in key order, one per line as
+// "key => value (fileID, offset, size)" with the location of the entry, for
+// debugging. Keys and values that aren't printable UTF-8 are written hex
+// encoded with a 0x prefix. Unlike Export the output can't be imported.
+func (b *Bitcask) Dump(w io.Writer) (err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	bw := bufio.NewWriter(w)
+	b.t.ForEach(func(key []byte, item internal.Item) bool {
+		var e internal.Entry
+		if e, err = b.read(item); err != nil {
+			return false
+		}
+		if b.expired(e) {
+			return true
+		}
+		_, err = fmt.Fprintf(bw, "%s => %s (%d, %d, %d)\n", printable(key), printable(e.Value), item.FileID, item.Offset, item.Size)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// printable returns b as is if it's printable UTF-8, hex encoded otherwise
+func printable(b []byte) string {
+	if utf8.Valid(b) && strings.IndexFunc(string(b), func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return string(b)
+	}
+	return "0x" + hex.EncodeToString(b)
+}
+
+// Import puts every pair written by Export to r into the database. Pairs
+// already expired are skipped. Import isn't atomic, if it fails the pairs
+// before the failing one have been put.
+func (b *Bitcask) Import(r io.Reader) error {
+	b.mu.RLock()
+	closed := b.closed
+	b.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	scanner := bufio.NewScanner(r)
+	maxLine := base64.StdEncoding.EncodedLen(int(b.cfg.MaxKeySize)) + base64.StdEncoding.EncodedLen(int(b.cfg.MaxValueSize)) + 32
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLine)
+	for n := 1; scanner.Scan(); n++ {
+		e, err := b.parseExportLine(scanner.Text())
+		if err != nil {
+			return errors.Wrapf(err, "line %d", n)
+		}
+		if b.expired(e) {
+			continue
+		}
+		if err := b.set(e); err != nil {
+			return errors.Wrapf(err, "line %d", n)
+		}
+	}
+	return scanner.Err()
+}
+
+func (b *Bitcask) parseExportLine(line string) (internal.Entry, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) != 2 && len(fields) != 3 {
+		return internal.Entry{}, errInvalidExportLine
+	}
+	key, err := base64.StdEncoding.DecodeString(fields[0])
+	if err != nil {
+		return internal.Entry{}, errInvalidExportLine
+	}
+	value, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return internal.Entry{}, errInvalidExportLine
+	}
+	e := b.newEntry(key, value)
+	if len(fields) == 3 {
+		if e.Expiry, err = strconv.ParseInt(fields[2], 10, 64); err != nil || e.Expiry <= 0 {
+			return internal.Entry{}, errInvalidExportLine
+		}
+	}
+	return e, nil
+}
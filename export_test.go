@@ -0,0 +1,103 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestExportImport(t *testing.T) {
+	src, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer src.Close()
+	want := make(map[string][]byte)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		value := []byte(fmt.Sprintf("value %d\nwith\x00binary", i))
+		src.Put([]byte(key), value)
+		want[key] = value
+	}
+	src.Delete([]byte("key-0"))
+	delete(want, "key-0")
+	src.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour)
+	want["ttl"] = []byte("value")
+
+	var buf bytes.Buffer
+	if err := src.Export(&buf); err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != len(want) {
+		t.Errorf("exported lines, want: %d, got: %d", len(want), lines)
+	}
+
+	dst, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer dst.Close()
+	if err := dst.Import(&buf); err != nil {
+		t.Fatalf("import error: %v", err)
+	}
+	if dst.Len() != len(want) {
+		t.Errorf("imported keys, want: %d, got: %d", len(want), dst.Len())
+	}
+	for key, value := range want {
+		if got, err := dst.Get([]byte(key)); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("get %s, want: %q, got: %q (%v)", key, value, got, err)
+		}
+	}
+
+	// the TTL is carried over
+	dst.clock = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := dst.Get([]byte("ttl")); err != ErrKeyNotFound {
+		t.Errorf("get expired ttl, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+
+	if err := dst.Import(strings.NewReader("a2V5\n")); errors.Cause(err) != errInvalidExportLine {
+		t.Errorf("import invalid line, want: %v, got: %v", errInvalidExportLine, err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for _, pair := range [][2]string{
+		{"a", "1"},
+		{"b", "two words"},
+		{"c", "\x00\xff"},
+		{"d\n", "line"},
+		{"e", "gone"},
+	} {
+		if err := db.Put([]byte(pair[0]), []byte(pair[1])); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Delete([]byte("e")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.PutWithTTL([]byte("f"), []byte("expired"), -time.Second); err != nil {
+		t.Fatalf("put with ttl error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := db.Dump(&buf); err != nil {
+		t.Fatalf("dump error: %v", err)
+	}
+	// every entry takes 33 bytes plus its key and value
+	want := "a => 1 (0, 0, 35)\n" +
+		"b => two words (0, 35, 43)\n" +
+		"c => 0x00ff (0, 78, 36)\n" +
+		"0x640a => line (0, 114, 39)\n"
+	if got := buf.String(); got != want {
+		t.Errorf("dump, want:\n%s\ngot:\n%s", want, got)
+	}
+}
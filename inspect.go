@@ -0,0 +1,113 @@
+package bitcask
+
+import (
+	"path/filepath"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/config"
+	"jay.com/bitcask/internal/data"
+)
+
+// Datafile is a single datafile opened read-only by OpenDatafile, to inspect
+// its entries without opening the database
+type Datafile struct {
+	df     data.DataFile
+	cfg    *config.Config
+	offset int64
+}
+
+// DatafileEntry is an entry read from a Datafile
+type DatafileEntry struct {
+	Key   []byte
+	Value []byte
+	// Tombstone marks the entry deleting Key
+	Tombstone bool
+	// Checksum is the checksum stored with the entry, see Datafile.Verify
+	Checksum uint64
+	// Expiry and Timestamp are unix times in nanoseconds, a zero Expiry
+	// never expires
+	Expiry    int64
+	Timestamp int64
+	// Offset and Size locate the entry in the datafile, as ReadAt takes them
+	Offset int64
+	Size   int64
+}
+
+// OpenDatafile opens the datafile with the given id of the database at path
+// read-only, named and encoded as the database's config.json says. Entries
+// with keys or values larger than maxKeySize or maxValueSize fail to read, zero
+// takes the database's limits. The values of an encrypted database can't be
+// read. The datafile may be inspected while the database is open, the last
+// entry of its current datafile may then be partly written.
+func OpenDatafile(path string, id int, maxKeySize uint32, maxValueSize uint64) (*Datafile, error) {
+	cfg, err := config.Load(filepath.Join(path, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	if maxKeySize > 0 {
+		cfg.MaxKeySize = maxKeySize
+	}
+	if maxValueSize > 0 {
+		cfg.MaxValueSize = maxValueSize
+	}
+	df, err := data.NewDatafile(path, id, true, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Datafile{df: df, cfg: cfg}, nil
+}
+
+// FileID returns the id of the datafile
+func (d *Datafile) FileID() int {
+	return d.df.FileID()
+}
+
+// Size returns the size of the datafile in bytes
+func (d *Datafile) Size() int64 {
+	return d.df.Size()
+}
+
+// Read returns the next entry of the datafile, starting from the first one,
+// and io.EOF after the last one.
+func (d *Datafile) Read() (DatafileEntry, error) {
+	e, n, err := d.df.Read()
+	if err != nil {
+		return DatafileEntry{}, err
+	}
+	entry := d.newEntry(e, d.offset, n)
+	d.offset += n
+	return entry, nil
+}
+
+// ReadAt returns the entry at offset taking size bytes, as an index item or a
+// previous Read locates it.
+func (d *Datafile) ReadAt(offset, size int64) (DatafileEntry, error) {
+	e, err := d.df.ReadAt(offset, size)
+	if err != nil {
+		return DatafileEntry{}, err
+	}
+	return d.newEntry(e, offset, size), nil
+}
+
+// Verify reports whether the value of e matches its checksum
+func (d *Datafile) Verify(e DatafileEntry) bool {
+	return internal.Checksum(d.cfg.ChecksumMode, d.cfg.Checksum, e.Key, e.Value) == e.Checksum
+}
+
+// Close closes the datafile
+func (d *Datafile) Close() error {
+	return d.df.Close()
+}
+
+func (d *Datafile) newEntry(e internal.Entry, offset, size int64) DatafileEntry {
+	return DatafileEntry{
+		Key:       e.Key,
+		Value:     e.Value,
+		Tombstone: isTombstone(d.cfg, e),
+		Checksum:  e.Checksum,
+		Expiry:    e.Expiry,
+		Timestamp: e.Timestamp,
+		Offset:    offset,
+		Size:      size,
+	}
+}
@@ -0,0 +1,111 @@
+package bitcask
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestOpenDatafile(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Delete([]byte("key-02")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	tests := []struct {
+		id   int
+		want []string
+	}{
+		{id: 0, want: []string{"key-00=value-00000", "key-01=value-00001"}},
+		{id: 1, want: []string{"key-02=value-00002", "delete key-02"}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.id), func(t *testing.T) {
+			df, err := OpenDatafile(path, test.id, 0, 0)
+			if err != nil {
+				t.Fatalf("open datafile error: %v", err)
+			}
+			defer df.Close()
+
+			var (
+				got     []string
+				entries []DatafileEntry
+			)
+			for {
+				e, err := df.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("read error: %v", err)
+				}
+				if !df.Verify(e) {
+					t.Errorf("verify %s, want: true, got: false", e.Key)
+				}
+				if e.Tombstone {
+					got = append(got, fmt.Sprintf("delete %s", e.Key))
+				} else {
+					got = append(got, fmt.Sprintf("%s=%s", e.Key, e.Value))
+				}
+				entries = append(entries, e)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("entries, want: %v, got: %v", test.want, got)
+			}
+			last := entries[len(entries)-1]
+			if last.Offset+last.Size != df.Size() {
+				t.Errorf("last entry, want: ending at %d, got: %d+%d", df.Size(), last.Offset, last.Size)
+			}
+
+			for _, want := range entries {
+				e, err := df.ReadAt(want.Offset, want.Size)
+				if err != nil || string(e.Key) != string(want.Key) || string(e.Value) != string(want.Value) || e.Timestamp != want.Timestamp {
+					t.Errorf("read at %d, want: %s=%s, got: %s=%s (%v)", want.Offset, want.Key, want.Value, e.Key, e.Value, err)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenDatafileErrors(t *testing.T) {
+	path := tempDir(t)
+	if _, err := OpenDatafile(path, 0, 0, 0); !os.IsNotExist(err) {
+		t.Errorf("open datafile without database, want: not exist, got: %v", err)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("a larger value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	if _, err := OpenDatafile(path, 1, 0, 0); !os.IsNotExist(err) {
+		t.Errorf("open missing datafile, want: not exist, got: %v", err)
+	}
+
+	df, err := OpenDatafile(path, 0, 0, 4)
+	if err != nil {
+		t.Fatalf("open datafile error: %v", err)
+	}
+	defer df.Close()
+	if _, err := df.Read(); err == nil {
+		t.Errorf("read value larger than max, want: error, got: nil")
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+)
+
+// Checksum modes, selecting what the checksum of an entry covers
+const (
+	ChecksumValueOnly   = "valueOnly"
+	ChecksumKeyAndValue = "keyAndValue"
+)
+
+// Checksum algorithms. CRC-32 with the IEEE polynomial is the default, an
+// empty algorithm is taken for it. ChecksumCRC64 and ChecksumXXHash64 store 8
+// byte checksums, the others 4 bytes.
+const (
+	ChecksumCRC32    = "crc32"
+	ChecksumCRC32C   = "crc32c"
+	ChecksumXXHash   = "xxhash"
+	ChecksumCRC64    = "crc64"
+	ChecksumXXHash64 = "xxhash64"
+)
+
+var (
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+	ecmaTable       = crc64.MakeTable(crc64.ECMA)
+)
+
+// ChecksumSize returns the size in bytes of the checksums computed with
+// algorithm
+func ChecksumSize(algorithm string) int {
+	switch algorithm {
+	case ChecksumCRC64, ChecksumXXHash64:
+		return 8
+	default:
+		return 4
+	}
+}
+
+// Checksum return the checksum of value computed with algorithm, or of the key
+// length, key and value if mode is ChecksumKeyAndValue. 32-bit checksums are
+// returned in the low bits.
+func Checksum(mode, algorithm string, key, value []byte) uint64 {
+	if mode != ChecksumKeyAndValue {
+		switch algorithm {
+		case ChecksumCRC32C:
+			return uint64(crc32.Checksum(value, castagnoliTable))
+		case ChecksumXXHash:
+			return uint64(xxh32Sum(value))
+		case ChecksumCRC64:
+			return crc64.Checksum(value, ecmaTable)
+		case ChecksumXXHash64:
+			return xxh64Sum(value)
+		default:
+			return uint64(crc32.ChecksumIEEE(value))
+		}
+	}
+	h := NewChecksum(mode, algorithm, key)
+	h.Write(value)
+	return SumChecksum(h)
+}
+
+// NewChecksum returns a hash computing the checksum of a value of key written
+// to it, as Checksum would once summed with SumChecksum
+func NewChecksum(mode, algorithm string, key []byte) hash.Hash {
+	var h hash.Hash
+	switch algorithm {
+	case ChecksumCRC32C:
+		h = crc32.New(castagnoliTable)
+	case ChecksumXXHash:
+		h = newXXH32()
+	case ChecksumCRC64:
+		h = crc64.New(ecmaTable)
+	case ChecksumXXHash64:
+		h = newXXH64()
+	default:
+		h = crc32.NewIEEE()
+	}
+	if mode == ChecksumKeyAndValue {
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(key)))
+		h.Write(size)
+		h.Write(key)
+	}
+	return h
+}
+
+// SumChecksum returns the checksum of the bytes written to h, a hash returned
+// by NewChecksum
+func SumChecksum(h hash.Hash) uint64 {
+	switch h := h.(type) {
+	case hash.Hash64:
+		return h.Sum64()
+	case hash.Hash32:
+		return uint64(h.Sum32())
+	default:
+		panic("unsupported checksum hash")
+	}
+}
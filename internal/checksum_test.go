@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestXXH32(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint32
+	}{
+		{"", 0x02cc5d05},
+		{"a", 0x550d7456},
+		{"abc", 0x32d153ff},
+		{"Nobody inspects the spammish repetition", 0xe2293b2f},
+	}
+	for _, test := range tests {
+		if got := xxh32Sum([]byte(test.in)); got != test.want {
+			t.Errorf("xxh32 of %q, want: %#x, got: %#x", test.in, test.want, got)
+		}
+	}
+}
+
+func TestXXH64(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	}
+	for _, test := range tests {
+		if got := xxh64Sum([]byte(test.in)); got != test.want {
+			t.Errorf("xxh64 of %q, want: %#x, got: %#x", test.in, test.want, got)
+		}
+	}
+}
+
+func TestChecksumSize(t *testing.T) {
+	for algorithm, want := range map[string]int{ChecksumCRC32: 4, ChecksumCRC32C: 4, ChecksumXXHash: 4, ChecksumCRC64: 8, ChecksumXXHash64: 8} {
+		if got := ChecksumSize(algorithm); got != want {
+			t.Errorf("%s checksum size, want: %d, got: %d", algorithm, want, got)
+		}
+	}
+}
+
+func TestChecksumStreaming(t *testing.T) {
+	key := []byte("key")
+	value := bytes.Repeat([]byte("0123456789abcdef"), 10)[:151]
+	for _, algorithm := range []string{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash, ChecksumCRC64, ChecksumXXHash64} {
+		for _, mode := range []string{ChecksumValueOnly, ChecksumKeyAndValue} {
+			want := Checksum(mode, algorithm, key, value)
+			// written in uneven pieces crossing the xxHash stripes
+			h := NewChecksum(mode, algorithm, key)
+			for i := 0; i < len(value); i += 7 {
+				end := i + 7
+				if end > len(value) {
+					end = len(value)
+				}
+				h.Write(value[i:end])
+			}
+			if got := SumChecksum(h); got != want {
+				t.Errorf("%s %s streamed checksum, want: %#x, got: %#x", algorithm, mode, want, got)
+			}
+		}
+	}
+	if Checksum(ChecksumValueOnly, ChecksumCRC32, nil, value) == Checksum(ChecksumValueOnly, ChecksumCRC32C, nil, value) {
+		t.Errorf("crc32 and crc32c checksums are the same")
+	}
+}
+
+func BenchmarkChecksum(b *testing.B) {
+	for _, size := range []int{64, 4096, 65536} {
+		value := bytes.Repeat([]byte("v"), size)
+		for _, algorithm := range []string{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash, ChecksumCRC64, ChecksumXXHash64} {
+			b.Run(fmt.Sprintf("%s/%d", algorithm, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					Checksum(ChecksumValueOnly, algorithm, nil, value)
+				}
+			})
+		}
+	}
+}
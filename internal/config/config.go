@@ -4,16 +4,67 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"jay.com/bitcask/internal"
+)
+
+var (
+	errInvalidMaxDatafileSize = errors.New("error: invalid config: max datafile size must be positive")
+	errInvalidMaxKeySize      = errors.New("error: invalid config: max key size must be positive")
+	errInvalidMaxValueSize    = errors.New("error: invalid config: max value size must be positive")
 )
 
 type Config struct {
-	MaxDatafileSize int    `json:max_datafile_size`
-	MaxKeySize      uint32 `json:max_key_size`
-	MaxValueSize    uint64 `json:max_value_size`
-	Sync            bool   `json:sync`
+	MaxDatafileSize    int              `json:"max_datafile_size"`
+	MaxKeySize         uint32           `json:"max_key_size"`
+	MaxValueSize       uint64           `json:"max_value_size"`
+	Sync               bool             `json:"sync"`
+	Version            int              `json:"version"`
+	ChecksumMode       string           `json:"checksum_mode"`
+	Checksum           string           `json:"checksum"`
+	DatafileFormat     string           `json:"datafile_format"`
+	Compression        string           `json:"compression"`
+	EncryptionCheck    string           `json:"encryption_check"`
+	EncryptionKey      []byte           `json:"-"`
+	Logger             internal.Logger  `json:"-"`
+	Metrics            internal.Metrics `json:"-"`
+	AutoMergeThreshold float64          `json:"-"`
+	TempDir            string           `json:"-"`
+	Index              string           `json:"-"`
+	ChunkSize          uint64           `json:"-"`
+	MaxOpenFiles       int              `json:"-"`
+	WriteBuffer        int              `json:"-"`
+	BloomFilter        bool             `json:"-"`
+	OpenTimeout        time.Duration    `json:"-"`
+	SyncInterval       time.Duration    `json:"-"`
+	FileMode           os.FileMode      `json:"-"`
+	DirMode            os.FileMode      `json:"-"`
+	StrictRecovery     bool             `json:"-"`
+	StrictDelete       bool             `json:"-"`
+	ForceIndexRebuild  bool             `json:"-"`
+	RepairOnOpen       bool             `json:"-"`
+	Readonly           bool             `json:"-"`
+	ForceConfig        bool             `json:"-"`
+
+	// OnCorruption decides what to do with corrupt entries, see
+	// WithOnCorruption
+	OnCorruption internal.CorruptionHandler `json:"-"`
+	// KeyTransform normalizes keys before indexing, see WithKeyTransform
+	KeyTransform func([]byte) []byte `json:"-"`
 }
 
-//Load config from file
+// legacyConfig holds the fields saved under their Go names before their tags
+// were fixed
+type legacyConfig struct {
+	MaxDatafileSize int    `json:"MaxDatafileSize"`
+	MaxKeySize      uint32 `json:"MaxKeySize"`
+	MaxValueSize    uint64 `json:"MaxValueSize"`
+	Sync            bool   `json:"Sync"`
+}
+
+// Load config from file
 func Load(path string) (*Config, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -23,13 +74,53 @@ func Load(path string) (*Config, error) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["max_datafile_size"]; !ok {
+		var legacy legacyConfig
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return nil, err
+		}
+		cfg.MaxDatafileSize = legacy.MaxDatafileSize
+		cfg.MaxKeySize = legacy.MaxKeySize
+		cfg.MaxValueSize = legacy.MaxValueSize
+		cfg.Sync = legacy.Sync
+	}
+	// databases created before checksum algorithms could be chosen use CRC-32
+	if cfg.Checksum == "" {
+		cfg.Checksum = internal.ChecksumCRC32
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
+// Validate returns an error if the sizes of c can't work, a max datafile size
+// that isn't positive would rotate on every write and a zero max key or value
+// size would reject every entry.
+func (c *Config) Validate() error {
+	if c.MaxDatafileSize <= 0 {
+		return errors.Wrapf(errInvalidMaxDatafileSize, "got %d", c.MaxDatafileSize)
+	}
+	if c.MaxKeySize == 0 {
+		return errInvalidMaxKeySize
+	}
+	if c.MaxValueSize == 0 {
+		return errInvalidMaxValueSize
+	}
+	return nil
+}
+
 // Save config to specific file
 func (c *Config) Save(path string) error {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, c.FileModeOr(0600))
 	if err != nil {
 		return err
 	}
@@ -45,3 +136,26 @@ func (c *Config) Save(path string) error {
 	}
 	return nil
 }
+
+// FileModeOr returns the permissions of created files, or def if none are set
+func (c *Config) FileModeOr(def os.FileMode) os.FileMode {
+	if c.FileMode != 0 {
+		return c.FileMode
+	}
+	return def
+}
+
+// DirModeOr returns the permissions of created directories, or def if none
+// are set
+func (c *Config) DirModeOr(def os.FileMode) os.FileMode {
+	if c.DirMode != 0 {
+		return c.DirMode
+	}
+	return def
+}
+
+// ChecksumSize returns the size in bytes of the entry checksums, 8 for a
+// 64-bit checksum algorithm and 4 otherwise
+func (c *Config) ChecksumSize() int {
+	return internal.ChecksumSize(c.Checksum)
+}
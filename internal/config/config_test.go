@@ -0,0 +1,134 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// tempDir returns a new temporary directory removed once the test ends
+func tempDir(tb testing.TB) string {
+	dir, err := ioutil.TempDir("", "bitcask-")
+	if err != nil {
+		tb.Fatalf("temp dir error: %v", err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestLoadSnakeCase(t *testing.T) {
+	path := filepath.Join(tempDir(t), "config.json")
+	data := `{"max_datafile_size": 1024, "max_key_size": 32, "max_value_size": 4096, "sync": true,
+		"version": 4, "checksum_mode": "keyAndValue", "checksum": "xxhash", "datafile_format": "%09d.data",
+		"auto_merge_threshold": 0.5, "compression": "gzip", "encryption_check": "check"}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	want := Config{
+		MaxDatafileSize: 1024,
+		MaxKeySize:      32,
+		MaxValueSize:    4096,
+		Sync:            true,
+		Version:         4,
+		ChecksumMode:    "keyAndValue",
+		Checksum:        "xxhash",
+		DatafileFormat:  "%09d.data",
+		Compression:     "gzip",
+		EncryptionCheck: "check",
+	}
+	// the auto merge threshold of older configs isn't loaded, it's an option
+	// of each Open
+	if !reflect.DeepEqual(*cfg, want) {
+		t.Errorf("load, want: %+v, got: %+v", want, *cfg)
+	}
+}
+
+func TestLoadLegacy(t *testing.T) {
+	path := filepath.Join(tempDir(t), "config.json")
+	data := `{"MaxDatafileSize": 1024, "MaxKeySize": 32, "MaxValueSize": 4096, "Sync": true, "version": 4}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if cfg.MaxDatafileSize != 1024 || cfg.MaxKeySize != 32 || cfg.MaxValueSize != 4096 || !cfg.Sync {
+		t.Errorf("load legacy, want sizes 1024, 32, 4096 and sync, got: %+v", *cfg)
+	}
+	if cfg.Checksum != "crc32" {
+		t.Errorf("load legacy checksum, want: crc32, got: %q", cfg.Checksum)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(tempDir(t), "config.json")
+	want := Config{
+		MaxDatafileSize: 1 << 20,
+		MaxKeySize:      64,
+		MaxValueSize:    1 << 16,
+		Sync:            true,
+		Version:         4,
+		ChecksumMode:    "valueOnly",
+		Checksum:        "crc32c",
+		DatafileFormat:  "%09d.data",
+		Compression:     "none",
+	}
+	if err := want.Save(path); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("round trip, want: %+v, got: %+v", want, *got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Config{MaxDatafileSize: 1024, MaxKeySize: 32, MaxValueSize: 4096}
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		err    error
+	}{
+		{"zero max datafile size", func(c *Config) { c.MaxDatafileSize = 0 }, errInvalidMaxDatafileSize},
+		{"negative max datafile size", func(c *Config) { c.MaxDatafileSize = -1 }, errInvalidMaxDatafileSize},
+		{"zero max key size", func(c *Config) { c.MaxKeySize = 0 }, errInvalidMaxKeySize},
+		{"zero max value size", func(c *Config) { c.MaxValueSize = 0 }, errInvalidMaxValueSize},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("validate valid config, want: nil, got: %v", err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := valid
+			test.modify(&cfg)
+			if err := cfg.Validate(); errors.Cause(err) != test.err {
+				t.Errorf("validate, want: %v, got: %v", test.err, err)
+			}
+			path := filepath.Join(tempDir(t), "config.json")
+			if err := cfg.Save(path); errors.Cause(err) != test.err {
+				t.Errorf("save, want: %v, got: %v", test.err, err)
+			}
+			// written by hand, bypassing Save
+			data, _ := json.Marshal(cfg)
+			if err := ioutil.WriteFile(path, data, 0600); err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+			if _, err := Load(path); errors.Cause(err) != test.err {
+				t.Errorf("load, want: %v, got: %v", test.err, err)
+			}
+		})
+	}
+}
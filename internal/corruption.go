@@ -0,0 +1,17 @@
+package internal
+
+// Action is what to do with a corrupt entry
+type Action int
+
+const (
+	// ActionAbort fails the operation finding the corrupt entry
+	ActionAbort Action = iota
+	// ActionSkip ignores the corrupt entry
+	ActionSkip
+	// ActionDelete deletes the key of the corrupt entry
+	ActionDelete
+)
+
+// CorruptionHandler decides what to do with the corrupt entry of key in the
+// datafile with the given id, err tells how it is corrupt
+type CorruptionHandler func(key []byte, fileID int, err error) Action
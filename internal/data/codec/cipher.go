@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+)
+
+// NewCipher returns the AES-GCM cipher encrypting values with key, which must
+// be 16, 24 or 32 bytes long. A nil key returns a nil cipher, values are then
+// stored in plaintext.
+func NewCipher(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, nonceSize)
+}
+
+// KeyCheck returns a value identifying key without revealing it, persisted to
+// tell a wrong encryption key from the right one.
+func KeyCheck(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("bitcask encryption key check"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
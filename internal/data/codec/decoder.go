@@ -1,8 +1,13 @@
 package codec
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/cipher"
 	"encoding/binary"
 	"io"
+	"io/ioutil"
+	"math"
 
 	"github.com/pkg/errors"
 	"jay.com/bitcask/internal"
@@ -12,19 +17,37 @@ var (
 	errInvalidKeyOrValueSize = errors.New("key/value size is invalid")
 	errCantDecodeOnNilEntry  = errors.New("can't decode on nil entry")
 	errTruncatedData         = errors.New("data is truncated")
+	errValueTooLarge         = errors.New("decompressed value is too large")
+	errMissingKey            = errors.New("value is encrypted but no key is set")
+	errDecryptFailed         = errors.New("failed decrypt value")
+	errSizeMismatch          = errors.New("entry sizes don't match its length")
+
+	// ErrNotPlain is returned by ValueSection for a compressed or encrypted
+	// value, which can't be read in place
+	ErrNotPlain = errors.New("value is compressed or encrypted")
+
+	// ErrCorruptSize is returned for an entry said to be larger than
+	// MaxEncodedSize allows
+	ErrCorruptSize = errors.New("error: corrupt entry size")
 )
 
 type Decoder struct {
 	r            io.Reader
 	maxKeySize   uint32
 	maxValueSize uint64
+	aead         cipher.AEAD
+	checksumSize int
 }
 
-func NewDecoder(r io.Reader, maxKeySize uint32, maxValueSize uint64) *Decoder {
+// NewDecoder return decoder, encrypted values are decrypted with aead.
+// Checksums are read as checksumSize bytes, 4 or 8.
+func NewDecoder(r io.Reader, maxKeySize uint32, maxValueSize uint64, aead cipher.AEAD, checksumSize int) *Decoder {
 	return &Decoder{
 		r:            r,
 		maxKeySize:   maxKeySize,
 		maxValueSize: maxValueSize,
+		aead:         aead,
+		checksumSize: checksumSize,
 	}
 }
 
@@ -32,34 +55,107 @@ func (d *Decoder) Decode(e *internal.Entry) (int64, error) {
 	if e == nil {
 		return 0, errCantDecodeOnNilEntry
 	}
-	keyValueSizeBuf := make([]byte, keySize+valueSize)
+	keyValueSizeBuf := make([]byte, headerSize)
 	if _, err := io.ReadFull(d.r, keyValueSizeBuf); err != nil {
 		return 0, err
 	}
-	actualKeySize, actualValueSize, err := getKeyValueSizes(keyValueSizeBuf, d.maxKeySize, d.maxValueSize)
+	flags := keyValueSizeBuf[keySize+valueSize]
+	actualKeySize, actualValueSize, err := getKeyValueSizes(keyValueSizeBuf, flags, d.maxKeySize, d.maxValueSize)
 	if err != nil {
 		return 0, err
 	}
-	buf := make([]byte, uint64(actualKeySize)+actualValueSize+checksumSize)
+	var nonce []byte
+	if flags&flagEncrypted != 0 {
+		nonce = make([]byte, nonceSize)
+		if _, err := io.ReadFull(d.r, nonce); err != nil {
+			return 0, errTruncatedData
+		}
+	}
+	buf := make([]byte, uint64(actualKeySize)+actualValueSize+uint64(trailerSize(d.checksumSize)))
 	if _, err := io.ReadFull(d.r, buf); err != nil {
 		return 0, errTruncatedData
 	}
-	decodeWithoutPrefix(buf, actualKeySize, e)
-	return int64(keySize + valueSize + uint64(actualKeySize) + actualValueSize + checksumSize), nil
+	decodeWithoutPrefix(buf, actualKeySize, d.checksumSize, e)
+	e.Tombstone = flags&flagTombstone != 0
+	if e.Value, err = decodeValue(e.Key, e.Value, flags, nonce, d.maxValueSize, d.aead); err != nil {
+		return 0, err
+	}
+	return encodedSize(int(actualKeySize), int(actualValueSize), len(nonce), d.checksumSize), nil
 }
 
-func DecodeEntry(b []byte, e *internal.Entry, maxKeySize uint32, maxValueSize uint64) error {
-	actualKeySize, _, err := getKeyValueSizes(b, maxKeySize, maxValueSize)
+func DecodeEntry(b []byte, e *internal.Entry, maxKeySize uint32, maxValueSize uint64, aead cipher.AEAD, checksumSize int) error {
+	if len(b) < headerSize {
+		return errTruncatedData
+	}
+	flags := b[keySize+valueSize]
+	actualKeySize, actualValueSize, err := getKeyValueSizes(b, flags, maxKeySize, maxValueSize)
 	if err != nil {
 		return errors.Wrap(err, "key/value sizes are invalid")
 	}
-	decodeWithoutPrefix(b[keySize+valueSize:], actualKeySize, e)
-	return nil
+	// the index and the datafile disagree if b isn't exactly the entry
+	nonceLen := 0
+	if flags&flagEncrypted != 0 {
+		nonceLen = nonceSize
+	}
+	if n := encodedSize(int(actualKeySize), int(actualValueSize), nonceLen, checksumSize); n != int64(len(b)) {
+		return errors.Wrapf(errSizeMismatch, "entry of %d bytes in %d", n, len(b))
+	}
+	b = b[headerSize:]
+	var nonce []byte
+	if nonceLen > 0 {
+		nonce, b = b[:nonceSize], b[nonceSize:]
+	}
+	decodeWithoutPrefix(b, actualKeySize, checksumSize, e)
+	e.Tombstone = flags&flagTombstone != 0
+	e.Value, err = decodeValue(e.Key, e.Value, flags, nonce, maxValueSize, aead)
+	return err
 }
 
-func getKeyValueSizes(b []byte, maxKeySize uint32, maxValueSize uint64) (uint32, uint64, error) {
+// ValueSection decodes the entry at offset in r without reading its value,
+// returning the entry with its key, checksum, expiry and timestamp set and the
+// section of r holding the value.
+func ValueSection(r io.ReaderAt, offset int64, maxKeySize uint32, maxValueSize uint64, checksumSize int) (internal.Entry, *io.SectionReader, error) {
+	var e internal.Entry
+	header := make([]byte, headerSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return e, nil, errTruncatedData
+	}
+	flags := header[keySize+valueSize]
+	if flags != 0 {
+		return e, nil, ErrNotPlain
+	}
+	actualKeySize, actualValueSize, err := getKeyValueSizes(header, flags, maxKeySize, maxValueSize)
+	if err != nil {
+		return e, nil, err
+	}
+	e.Key = make([]byte, actualKeySize)
+	if _, err := r.ReadAt(e.Key, offset+headerSize); err != nil {
+		return e, nil, errTruncatedData
+	}
+	valueOffset := offset + headerSize + int64(actualKeySize)
+	trailer := make([]byte, trailerSize(checksumSize))
+	if _, err := r.ReadAt(trailer, valueOffset+int64(actualValueSize)); err != nil {
+		return e, nil, errTruncatedData
+	}
+	e.Checksum = getChecksum(trailer[:checksumSize])
+	e.Expiry = int64(binary.BigEndian.Uint64(trailer[checksumSize:]))
+	e.Timestamp = int64(binary.BigEndian.Uint64(trailer[checksumSize+expirySize:]))
+	return e, io.NewSectionReader(r, valueOffset, int64(actualValueSize)), nil
+}
+
+// IsTruncated reports whether err is the error of decoding an entry cut short
+// by the end of its data, as left behind by a crash while writing it.
+func IsTruncated(err error) bool {
+	return err == io.ErrUnexpectedEOF || errors.Cause(err) == errTruncatedData
+}
+
+func getKeyValueSizes(b []byte, flags byte, maxKeySize uint32, maxValueSize uint64) (uint32, uint64, error) {
 	actualKeySize := binary.BigEndian.Uint32(b[:keySize])
 	actualValueSize := binary.BigEndian.Uint64(b[keySize:])
+	// encryption appends its authentication tag to the value
+	if flags&flagEncrypted != 0 {
+		maxValueSize += tagSize
+	}
 	if actualKeySize > maxKeySize || actualValueSize > maxValueSize || actualKeySize == 0 {
 		return 0, 0, errInvalidKeyOrValueSize
 	}
@@ -67,8 +163,60 @@ func getKeyValueSizes(b []byte, maxKeySize uint32, maxValueSize uint64) (uint32,
 	return actualKeySize, actualValueSize, nil
 }
 
-func decodeWithoutPrefix(b []byte, actualKeySize uint32, e *internal.Entry) {
+func decodeWithoutPrefix(b []byte, actualKeySize uint32, checksumSize int, e *internal.Entry) {
+	checksumOffset := len(b) - trailerSize(checksumSize)
+	expiryOffset := checksumOffset + checksumSize
 	e.Key = b[:actualKeySize]
-	e.Value = b[actualKeySize : len(b)-checksumSize]
-	e.Checksum = binary.BigEndian.Uint32(b[len(b)-checksumSize:])
+	e.Value = b[actualKeySize:checksumOffset]
+	e.Checksum = getChecksum(b[checksumOffset:expiryOffset])
+	e.Expiry = int64(binary.BigEndian.Uint64(b[expiryOffset : expiryOffset+expirySize]))
+	e.Timestamp = int64(binary.BigEndian.Uint64(b[expiryOffset+expirySize:]))
+}
+
+// getChecksum reads a checksum of 4 or 8 bytes from b
+func getChecksum(b []byte) uint64 {
+	if len(b) == 8 {
+		return binary.BigEndian.Uint64(b)
+	}
+	return uint64(binary.BigEndian.Uint32(b))
+}
+
+// decodeValue returns the plaintext of a stored value, decrypting then
+// decompressing it as its flags say.
+func decodeValue(key, value []byte, flags byte, nonce []byte, maxValueSize uint64, aead cipher.AEAD) ([]byte, error) {
+	if flags&flagEncrypted != 0 {
+		if aead == nil {
+			return nil, errMissingKey
+		}
+		var err error
+		if value, err = aead.Open(nil, nonce, value, key); err != nil {
+			return nil, errDecryptFailed
+		}
+	}
+	if flags&flagGzip != 0 {
+		return decompress(value, maxValueSize)
+	}
+	return value, nil
+}
+
+func decompress(b []byte, maxValueSize uint64) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed decompress value")
+	}
+	defer zr.Close()
+	// read one byte past the limit to tell a value of exactly maxValueSize
+	// from a larger one
+	limit := int64(math.MaxInt64)
+	if maxValueSize < math.MaxInt64 {
+		limit = int64(maxValueSize) + 1
+	}
+	value, err := ioutil.ReadAll(io.LimitReader(zr, limit))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed decompress value")
+	}
+	if uint64(len(value)) > maxValueSize {
+		return nil, errValueTooLarge
+	}
+	return value, nil
 }
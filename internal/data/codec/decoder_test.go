@@ -11,7 +11,7 @@ import (
 )
 
 func TestDecodeOnNilEntry(t *testing.T) {
-	d := NewDecoder(&bytes.Buffer{}, 1, 1)
+	d := NewDecoder(&bytes.Buffer{}, 1, 1, nil, 4)
 	_, err := d.Decode(nil)
 	if !errors.Is(err, errCantDecodeOnNilEntry) {
 		t.Errorf("expected: %v, but got: %v", errCantDecodeOnNilEntry, err)
@@ -19,12 +19,12 @@ func TestDecodeOnNilEntry(t *testing.T) {
 }
 
 func TestShortPrefix(t *testing.T) {
-	b := make([]byte, keySize+valueSize)
+	b := make([]byte, headerSize)
 	binary.BigEndian.PutUint32(b, 1)
 	binary.BigEndian.PutUint64(b[keySize:], 1)
 	trancate := 2
 	buf := bytes.NewBuffer(b[0 : len(b)-trancate])
-	d := NewDecoder(buf, keySize, valueSize)
+	d := NewDecoder(buf, keySize, valueSize, nil, 4)
 	_, err := d.Decode(&internal.Entry{})
 	if !errors.Is(err, io.ErrUnexpectedEOF) {
 		t.Errorf("expected: %v, but got: %v", io.ErrUnexpectedEOF, err)
@@ -45,13 +45,14 @@ func TestInvalidValueKeySizes(t *testing.T) {
 	}
 
 	for _, test := range tests {
+		test := test
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
-			prefix := make([]byte, keySize+valueSize)
+			prefix := make([]byte, headerSize)
 			binary.BigEndian.PutUint32(prefix, test.keySize)
 			binary.BigEndian.PutUint64(prefix[keySize:], test.valueSize)
 			buf := bytes.NewBuffer(prefix)
-			decoder := NewDecoder(buf, maxKeySize, maxValueSize)
+			decoder := NewDecoder(buf, maxKeySize, maxValueSize, nil, 4)
 			_, err := decoder.Decode(&internal.Entry{})
 			if !errors.Is(err, errInvalidKeyOrValueSize) {
 				t.Errorf("expected: %v, but got: %v", errInvalidKeyOrValueSize, err)
@@ -59,3 +60,55 @@ func TestInvalidValueKeySizes(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeEntrySizeMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := NewEncoder(&buf, CompressionNone, nil, 4).Encode(internal.NewEntry([]byte("key"), []byte("value")))
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	b := buf.Bytes()
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{name: "short", b: b[:n-1]},
+		{name: "long", b: append(append([]byte{}, b...), 0)},
+		{name: "cut in key", b: b[:headerSize+1]},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := DecodeEntry(test.b, &internal.Entry{}, 10, 10, nil, 4)
+			if !errors.Is(err, errSizeMismatch) {
+				t.Errorf("expected: %v, but got: %v", errSizeMismatch, err)
+			}
+		})
+	}
+}
+
+func TestDecodeExpiry(t *testing.T) {
+	entry := internal.NewEntry([]byte("key"), []byte("value"))
+	entry.Expiry = 1600000000
+	entry.Timestamp = 1500000000
+	var buf bytes.Buffer
+	n, err := NewEncoder(&buf, CompressionNone, nil, 4).Encode(entry)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+
+	var got internal.Entry
+	m, err := NewDecoder(&buf, 10, 10, nil, 4).Decode(&got)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if m != n {
+		t.Errorf("decode size, want: %d, got: %d", n, m)
+	}
+	if got.Expiry != entry.Expiry || got.Checksum != entry.Checksum {
+		t.Errorf("decode expiry and checksum, want: %d/%d, got: %d/%d", entry.Expiry, entry.Checksum, got.Expiry, got.Checksum)
+	}
+	if got.Timestamp != entry.Timestamp {
+		t.Errorf("decode timestamp, want: %d, got: %d", entry.Timestamp, got.Timestamp)
+	}
+}
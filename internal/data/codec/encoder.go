@@ -2,38 +2,189 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/binary"
+	"hash"
 	"io"
+	"math"
 
 	"github.com/pkg/errors"
 	"jay.com/bitcask/internal"
 )
 
 const (
-	keySize      = 4
-	valueSize    = 8
-	checksumSize = 4
+	keySize       = 4
+	valueSize     = 8
+	flagsSize     = 1
+	expirySize    = 8
+	timestampSize = 8
+	headerSize    = keySize + valueSize + flagsSize
 )
 
+// trailerSize returns the size of the checksum, expiry and timestamp following
+// the value, checksumSize is 4 or 8 bytes depending on the checksum algorithm.
+func trailerSize(checksumSize int) int {
+	return checksumSize + expirySize + timestampSize
+}
+
+// putChecksum writes checksum to b, checksumSize bytes long
+func putChecksum(b []byte, checksum uint64) {
+	if len(b) == 8 {
+		binary.BigEndian.PutUint64(b, checksum)
+	} else {
+		binary.BigEndian.PutUint32(b, uint32(checksum))
+	}
+}
+
+// flagGzip marks a value stored gzip compressed, flagEncrypted a value stored
+// encrypted with its nonce following the flags, flagTombstone an entry
+// deleting its key.
+const (
+	flagGzip      = 1 << 0
+	flagEncrypted = 1 << 1
+	flagTombstone = 1 << 2
+)
+
+// Compression algorithms supported by Encoder.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// Version is the version of the entry format written by Encoder, MinVersion is
+// the oldest version still readable. Version 2 added checksum modes, version 3
+// added the flags byte used for compression, version 4 the timestamp, version
+// 5 the tombstone flag.
+const (
+	Version    = 5
+	MinVersion = 4
+)
+
+// TombstoneVersion is the first version marking tombstones with a flag, in
+// older versions every entry with an empty value is a tombstone.
+const TombstoneVersion = 5
+
 // Encoder
 type Encoder struct {
-	w *bufio.Writer
+	w           *bufio.Writer
+	out         io.Writer
+	compression string
+	aead        cipher.AEAD
+	// buffered leaves entries in w until it's full or flushed
+	buffered     bool
+	checksumSize int
 }
 
-// NewEncoder return encoder
-func NewEncoder(w io.Writer) *Encoder {
+// NewEncoder return encoder, values are compressed with compression and, if
+// aead isn't nil, encrypted with it. Checksums are stored in checksumSize
+// bytes, 4 or 8.
+func NewEncoder(w io.Writer, compression string, aead cipher.AEAD, checksumSize int) *Encoder {
 	return &Encoder{
-		w: bufio.NewWriter(w),
+		w:            bufio.NewWriter(w),
+		out:          w,
+		compression:  compression,
+		aead:         aead,
+		checksumSize: checksumSize,
 	}
 }
 
+// NewBufferedEncoder is like NewEncoder but keeps entries in a buffer of the
+// given size, writing them to w only once it's full or on Flush.
+func NewBufferedEncoder(w io.Writer, size int, compression string, aead cipher.AEAD, checksumSize int) *Encoder {
+	return &Encoder{
+		w:            bufio.NewWriterSize(w, size),
+		out:          w,
+		compression:  compression,
+		aead:         aead,
+		buffered:     true,
+		checksumSize: checksumSize,
+	}
+}
+
+// Flush writes the buffered entries to the underlying writer
+func (e *Encoder) Flush() error {
+	if err := e.w.Flush(); err != nil {
+		return errors.Wrap(err, "failed flush data")
+	}
+	return nil
+}
+
+// Discard drops the buffered entries without writing them
+func (e *Encoder) Discard() {
+	e.w.Reset(e.out)
+}
+
+// Buffered returns the number of bytes of entries not written yet
+func (e *Encoder) Buffered() int {
+	return e.w.Buffered()
+}
+
 // Encode entry
 // msg protocol:
-// keyLen | valueLen | key | value | checksum(value)
+// keyLen | valueLen | flags | [nonce] | key | value | checksum(value) | expiry | timestamp
+// valueLen is the length of the stored, possibly compressed and encrypted,
+// value. The nonce is only present for encrypted values. The checksum is always
+// over the plaintext value, 4 or 8 bytes as the encoder was created with.
 func (e *Encoder) Encode(entry internal.Entry) (int64, error) {
-	sizeBuf := make([]byte, keySize+valueSize)
+	n, err := e.encode(entry)
+	if err != nil {
+		return 0, err
+	}
+	if !e.buffered {
+		if err := e.w.Flush(); err != nil {
+			return 0, errors.Wrap(err, "failed flush data")
+		}
+	}
+	return n, nil
+}
+
+// EncodeMany encodes entries like Encode, writing them all at once. It
+// returns the size of each entry.
+func (e *Encoder) EncodeMany(entries []internal.Entry) ([]int64, error) {
+	sizes := make([]int64, len(entries))
+	for i, entry := range entries {
+		n, err := e.encode(entry)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = n
+	}
+	if !e.buffered {
+		if err := e.w.Flush(); err != nil {
+			return nil, errors.Wrap(err, "failed flush data")
+		}
+	}
+	return sizes, nil
+}
+
+// encode writes entry to the buffer
+func (e *Encoder) encode(entry internal.Entry) (int64, error) {
+	value, flags, err := e.compress(entry.Value)
+	if err != nil {
+		return 0, err
+	}
+	if entry.Tombstone {
+		flags |= flagTombstone
+	}
+	var nonce []byte
+	// empty values, like those of tombstones, are stored as is
+	if e.aead != nil && len(value) > 0 {
+		nonce = make([]byte, nonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return 0, errors.Wrap(err, "failed generate nonce")
+		}
+		value = e.aead.Seal(nil, nonce, value, entry.Key)
+		flags |= flagEncrypted
+	}
+
+	sizeBuf := make([]byte, headerSize, headerSize+len(nonce))
 	binary.BigEndian.PutUint32(sizeBuf[0:keySize], uint32(len(entry.Key)))
-	binary.BigEndian.PutUint64(sizeBuf[keySize:keySize+valueSize], uint64(len(entry.Value)))
+	binary.BigEndian.PutUint64(sizeBuf[keySize:keySize+valueSize], uint64(len(value)))
+	sizeBuf[keySize+valueSize] = flags
+	sizeBuf = append(sizeBuf, nonce...)
 	if _, err := e.w.Write(sizeBuf); err != nil {
 		return 0, errors.Wrap(err, "failed write key & value length prefix")
 	}
@@ -42,17 +193,121 @@ func (e *Encoder) Encode(entry internal.Entry) (int64, error) {
 		return 0, errors.Wrap(err, "failed write key")
 	}
 
-	if _, err := e.w.Write(entry.Value); err != nil {
+	if _, err := e.w.Write(value); err != nil {
 		return 0, errors.Wrap(err, "failed write value")
 	}
 
-	checksumBuf := make([]byte, checksumSize)
-	binary.BigEndian.PutUint32(checksumBuf, entry.Checksum)
+	checksumBuf := make([]byte, e.checksumSize)
+	putChecksum(checksumBuf, entry.Checksum)
 	if _, err := e.w.Write(checksumBuf); err != nil {
 		return 0, errors.Wrap(err, "failed write checksum")
 	}
-	if err := e.w.Flush(); err != nil {
-		return 0, errors.Wrap(err, "failed flush data")
+
+	expiryBuf := make([]byte, expirySize)
+	binary.BigEndian.PutUint64(expiryBuf, uint64(entry.Expiry))
+	if _, err := e.w.Write(expiryBuf); err != nil {
+		return 0, errors.Wrap(err, "failed write expiry")
+	}
+
+	timestampBuf := make([]byte, timestampSize)
+	binary.BigEndian.PutUint64(timestampBuf, uint64(entry.Timestamp))
+	if _, err := e.w.Write(timestampBuf); err != nil {
+		return 0, errors.Wrap(err, "failed write timestamp")
+	}
+	return encodedSize(len(entry.Key), len(value), len(nonce), e.checksumSize), nil
+}
+
+// EncodeFrom encodes entry with the value of the given size read from r rather
+// than entry.Value, without holding it in memory. The value is stored as is,
+// neither compressed nor encrypted, and its checksum is taken from h once
+// the value has been written to it, see internal.SumChecksum.
+func (e *Encoder) EncodeFrom(entry internal.Entry, r io.Reader, size int64, h hash.Hash) (int64, error) {
+	sizeBuf := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(sizeBuf[0:keySize], uint32(len(entry.Key)))
+	binary.BigEndian.PutUint64(sizeBuf[keySize:keySize+valueSize], uint64(size))
+	if _, err := e.w.Write(sizeBuf); err != nil {
+		return 0, errors.Wrap(err, "failed write key & value length prefix")
+	}
+
+	if _, err := e.w.Write(entry.Key); err != nil {
+		return 0, errors.Wrap(err, "failed write key")
+	}
+
+	if _, err := io.CopyN(io.MultiWriter(e.w, h), r, size); err != nil {
+		return 0, errors.Wrap(err, "failed write value")
+	}
+
+	trailer := make([]byte, trailerSize(e.checksumSize))
+	putChecksum(trailer[:e.checksumSize], internal.SumChecksum(h))
+	binary.BigEndian.PutUint64(trailer[e.checksumSize:], uint64(entry.Expiry))
+	binary.BigEndian.PutUint64(trailer[e.checksumSize+expirySize:], uint64(entry.Timestamp))
+	if _, err := e.w.Write(trailer); err != nil {
+		return 0, errors.Wrap(err, "failed write checksum")
+	}
+	if !e.buffered {
+		if err := e.w.Flush(); err != nil {
+			return 0, errors.Wrap(err, "failed flush data")
+		}
+	}
+	return encodedSize(len(entry.Key), int(size), 0, e.checksumSize), nil
+}
+
+// EncodedSize returns the size of entry once encoded without compression, an
+// upper bound of its size when compressed. encrypted tells whether values are
+// encrypted, adding a nonce and an authentication tag to non-empty values.
+func EncodedSize(entry internal.Entry, encrypted bool, checksumSize int) int64 {
+	if encrypted && len(entry.Value) > 0 {
+		return encodedSize(len(entry.Key), len(entry.Value)+tagSize, nonceSize, checksumSize)
+	}
+	return encodedSize(len(entry.Key), len(entry.Value), 0, checksumSize)
+}
+
+// MaxEncodedSize returns the size of the largest entry with a key and value
+// within the given limits, encrypted tells whether values are encrypted.
+func MaxEncodedSize(maxKeySize uint32, maxValueSize uint64, encrypted bool, checksumSize int) int64 {
+	overhead := uint64(headerSize + trailerSize(checksumSize) + nonceSize + tagSize)
+	if maxValueSize > math.MaxInt64-overhead-uint64(maxKeySize) {
+		return math.MaxInt64
+	}
+	if !encrypted {
+		overhead -= nonceSize + tagSize
+	}
+	return int64(overhead + uint64(maxKeySize) + maxValueSize)
+}
+
+// ValueSize returns the size of the value stored in an entry of size bytes
+// with a key of keyLen bytes, less the nonce and authentication tag of an
+// encrypted value. A compressed value is counted compressed.
+func ValueSize(keyLen int, size int64, encrypted bool, checksumSize int) int64 {
+	n := size - encodedSize(keyLen, 0, 0, checksumSize)
+	if encrypted && n > 0 {
+		n -= nonceSize + tagSize
+	}
+	return n
+}
+
+// encodedSize returns the size of an encoded entry given the lengths of its
+// key, stored value, nonce and checksum
+func encodedSize(keyLen, valueLen, nonceLen, checksumSize int) int64 {
+	return int64(headerSize + nonceLen + keyLen + valueLen + trailerSize(checksumSize))
+}
+
+// compress returns the value to store and its flags. The compressed form is
+// only used when it is actually smaller.
+func (e *Encoder) compress(value []byte) ([]byte, byte, error) {
+	if e.compression != CompressionGzip || len(value) == 0 {
+		return value, 0, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(value); err != nil {
+		return nil, 0, errors.Wrap(err, "failed compress value")
+	}
+	if err := zw.Close(); err != nil {
+		return nil, 0, errors.Wrap(err, "failed compress value")
+	}
+	if buf.Len() >= len(value) {
+		return value, 0, nil
 	}
-	return int64(keySize + valueSize + len(entry.Key) + len(entry.Value) + checksumSize), nil
+	return buf.Bytes(), flagGzip, nil
 }
@@ -2,6 +2,7 @@ package codec
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"jay.com/bitcask/internal"
@@ -13,13 +14,13 @@ func TestEncode(t *testing.T) {
 
 	entry := internal.NewEntry(key, value)
 	var buf bytes.Buffer
-	encoder := NewEncoder(&buf)
+	encoder := NewEncoder(&buf, CompressionNone, nil, 4)
 	n, err := encoder.Encode(entry)
 	if err != nil {
 		t.Errorf("encode err : %v", err)
 		return
 	}
-	want := 4 + 8 + len(key) + len(value) + 4
+	want := 4 + 8 + 1 + len(key) + len(value) + 4 + 8 + 8
 	if n != int64(want) {
 		t.Errorf("encode size err, want: %d, got: %d", n, want)
 	}
@@ -36,6 +37,11 @@ func TestEncode(t *testing.T) {
 		t.Errorf("keysize error, want: %d, got: %d", value, vn)
 	}
 
+	flags, err := buf.ReadByte()
+	if err != nil || flags != 0 {
+		t.Errorf("flags error, want: %d, got: %d", 0, flags)
+	}
+
 	readKey := make([]byte, len(key))
 	rkn, err := buf.Read(readKey)
 	if rkn != len(key) {
@@ -54,3 +60,201 @@ func TestEncode(t *testing.T) {
 		t.Errorf("key error, want: %v, got: %v", value, readValue)
 	}
 }
+
+func TestEncodeCompression(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      []byte
+		compressed bool
+	}{
+		{name: "compressible", value: bytes.Repeat([]byte("a"), 1024), compressed: true},
+		{name: "incompressible", value: []byte("myvalue"), compressed: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := internal.NewEntry([]byte("mykey"), test.value)
+			var buf bytes.Buffer
+			n, err := NewEncoder(&buf, CompressionGzip, nil, 4).Encode(entry)
+			if err != nil {
+				t.Fatalf("encode err: %v", err)
+			}
+			raw := int64(headerSize + len(entry.Key) + len(test.value) + trailerSize(4))
+			if compressed := n < raw; compressed != test.compressed {
+				t.Errorf("compressed, want: %v, got: %v (size %d, raw %d)", test.compressed, compressed, n, raw)
+			}
+
+			var got internal.Entry
+			m, err := NewDecoder(&buf, 10, 2048, nil, 4).Decode(&got)
+			if err != nil {
+				t.Fatalf("decode err: %v", err)
+			}
+			if m != n {
+				t.Errorf("decode size, want: %d, got: %d", n, m)
+			}
+			if !bytes.Equal(got.Value, test.value) || got.Checksum != entry.Checksum {
+				t.Errorf("decode value, want: %q, got: %q", test.value, got.Value)
+			}
+		})
+	}
+}
+
+func TestDecodeCompressedTooLarge(t *testing.T) {
+	entry := internal.NewEntry([]byte("mykey"), bytes.Repeat([]byte("a"), 1024))
+	var buf bytes.Buffer
+	if _, err := NewEncoder(&buf, CompressionGzip, nil, 4).Encode(entry); err != nil {
+		t.Fatalf("encode err: %v", err)
+	}
+	_, err := NewDecoder(&buf, 10, 512, nil, 4).Decode(&internal.Entry{})
+	if err != errValueTooLarge {
+		t.Errorf("expected: %v, but got: %v", errValueTooLarge, err)
+	}
+}
+
+func TestEncodeEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 32)
+	aead, err := NewCipher(key)
+	if err != nil {
+		t.Fatalf("cipher err: %v", err)
+	}
+	entry := internal.NewEntry([]byte("mykey"), []byte("myvalue"))
+	var buf bytes.Buffer
+	n, err := NewEncoder(&buf, CompressionGzip, aead, 4).Encode(entry)
+	if err != nil {
+		t.Fatalf("encode err: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), entry.Value) {
+		t.Errorf("encoded entry holds the plaintext value")
+	}
+	b := append([]byte(nil), buf.Bytes()...)
+
+	var got internal.Entry
+	m, err := NewDecoder(&buf, 10, 10, aead, 4).Decode(&got)
+	if err != nil {
+		t.Fatalf("decode err: %v", err)
+	}
+	if m != n || !bytes.Equal(got.Value, entry.Value) || got.Checksum != entry.Checksum {
+		t.Errorf("decode, want: %q (%d bytes), got: %q (%d bytes)", entry.Value, n, got.Value, m)
+	}
+
+	wrong, _ := NewCipher(bytes.Repeat([]byte("w"), 32))
+	if err := DecodeEntry(b, &internal.Entry{}, 10, 10, wrong, 4); err != errDecryptFailed {
+		t.Errorf("decode with wrong key, want: %v, got: %v", errDecryptFailed, err)
+	}
+	if err := DecodeEntry(b, &internal.Entry{}, 10, 10, nil, 4); err != errMissingKey {
+		t.Errorf("decode without key, want: %v, got: %v", errMissingKey, err)
+	}
+
+	// empty values stay empty
+	buf.Reset()
+	if _, err := NewEncoder(&buf, CompressionNone, aead, 4).Encode(internal.NewEntry([]byte("mykey"), nil)); err != nil {
+		t.Fatalf("encode err: %v", err)
+	}
+	if err := DecodeEntry(buf.Bytes(), &got, 10, 10, nil, 4); err != nil || len(got.Value) != 0 {
+		t.Errorf("decode empty value, want empty value, got: %q (%v)", got.Value, err)
+	}
+}
+
+func TestEncodeTombstone(t *testing.T) {
+	tombstone := internal.NewEntry([]byte("deleted"), nil)
+	tombstone.Tombstone = true
+	for _, entry := range []internal.Entry{tombstone, internal.NewEntry([]byte("empty"), nil)} {
+		var buf bytes.Buffer
+		if _, err := NewEncoder(&buf, CompressionGzip, nil, 4).Encode(entry); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+		var got internal.Entry
+		if err := DecodeEntry(buf.Bytes(), &got, 10, 10, nil, 4); err != nil || got.Tombstone != entry.Tombstone {
+			t.Errorf("decode %s, want tombstone: %v, got: %v (%v)", entry.Key, entry.Tombstone, got.Tombstone, err)
+		}
+		got = internal.Entry{}
+		if _, err := NewDecoder(&buf, 10, 10, nil, 4).Decode(&got); err != nil || got.Tombstone != entry.Tombstone {
+			t.Errorf("stream decode %s, want tombstone: %v, got: %v (%v)", entry.Key, entry.Tombstone, got.Tombstone, err)
+		}
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	aead, _ := NewCipher(bytes.Repeat([]byte("k"), 16))
+	for _, entry := range []internal.Entry{
+		internal.NewEntry([]byte("mykey"), []byte("myvalue")),
+		internal.NewEntry([]byte("mykey"), nil),
+	} {
+		for _, encrypted := range []bool{false, true} {
+			for _, checksumSize := range []int{4, 8} {
+				var buf bytes.Buffer
+				enc := NewEncoder(&buf, CompressionNone, nil, checksumSize)
+				if encrypted {
+					enc = NewEncoder(&buf, CompressionNone, aead, checksumSize)
+				}
+				n, err := enc.Encode(entry)
+				if err != nil {
+					t.Fatalf("encode err: %v", err)
+				}
+				if n != int64(buf.Len()) {
+					t.Errorf("encode size of %q (encrypted %v, checksum %d), want: %d, got: %d", entry.Value, encrypted, checksumSize, buf.Len(), n)
+				}
+				if size := EncodedSize(entry, encrypted, checksumSize); size != int64(buf.Len()) {
+					t.Errorf("encoded size of %q (encrypted %v, checksum %d), want: %d, got: %d", entry.Value, encrypted, checksumSize, buf.Len(), size)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeFrom(t *testing.T) {
+	key := []byte("mykey")
+	value := []byte("myvalue")
+	entry := internal.NewEntryWithChecksumMode(internal.ChecksumKeyAndValue, key, value)
+
+	var want, got bytes.Buffer
+	if _, err := NewEncoder(&want, CompressionNone, nil, 4).Encode(entry); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	h := internal.NewChecksum(internal.ChecksumKeyAndValue, internal.ChecksumCRC32, key)
+	n, err := NewEncoder(&got, CompressionNone, nil, 4).EncodeFrom(entry, bytes.NewReader(value), int64(len(value)), h)
+	if err != nil {
+		t.Fatalf("encode from error: %v", err)
+	}
+	if n != int64(got.Len()) || !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("encode from, want: %v, got: %v (%d)", want.Bytes(), got.Bytes(), n)
+	}
+}
+
+func TestEncode64BitChecksum(t *testing.T) {
+	key := []byte("mykey")
+	value := []byte("myvalue")
+	entry := internal.NewEntryWithChecksum(internal.ChecksumKeyAndValue, internal.ChecksumXXHash64, key, value)
+	if entry.Checksum>>32 == 0 {
+		t.Fatalf("checksum %#x has no high bits to round trip", entry.Checksum)
+	}
+
+	var buf bytes.Buffer
+	n, err := NewEncoder(&buf, CompressionNone, nil, 8).Encode(entry)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if want := EncodedSize(entry, false, 4) + 4; n != want {
+		t.Errorf("encode size, want: %d, got: %d", want, n)
+	}
+	var got internal.Entry
+	if err := DecodeEntry(buf.Bytes(), &got, 10, 10, nil, 8); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if got.Checksum != entry.Checksum || !bytes.Equal(got.Value, value) || got.Timestamp != entry.Timestamp {
+		t.Errorf("decode, want: %#x %s, got: %#x %s", entry.Checksum, value, got.Checksum, got.Value)
+	}
+	// the 32-bit layout doesn't match the entry's length
+	if err := DecodeEntry(buf.Bytes(), &got, 10, 10, nil, 4); !errors.Is(err, errSizeMismatch) {
+		t.Errorf("decode as 32-bit, want: %v, got: %v", errSizeMismatch, err)
+	}
+
+	var from bytes.Buffer
+	h := internal.NewChecksum(internal.ChecksumKeyAndValue, internal.ChecksumXXHash64, key)
+	if _, err := NewEncoder(&from, CompressionNone, nil, 8).EncodeFrom(entry, bytes.NewReader(value), int64(len(value)), h); err != nil {
+		t.Fatalf("encode from error: %v", err)
+	}
+	if !bytes.Equal(from.Bytes(), buf.Bytes()) {
+		t.Errorf("encode from, want: %v, got: %v", buf.Bytes(), from.Bytes())
+	}
+}
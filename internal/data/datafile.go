@@ -1,7 +1,11 @@
 package data
 
 import (
+	"bufio"
+	"crypto/cipher"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
@@ -9,13 +13,10 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/exp/mmap"
 	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/config"
 	"jay.com/bitcask/internal/data/codec"
 )
 
-const (
-	defaultDatafileFilename = "%09d.data"
-)
-
 var (
 	errReadOnly  = errors.New("error: read only datafile")
 	errReadError = errors.New("error: read error")
@@ -26,65 +27,86 @@ type DataFile interface {
 	Name() string
 	Size() int64
 	Sync() error
+	Flush() error
 	Read() (internal.Entry, int64, error)
 	ReadAt(offset, size int64) (internal.Entry, error)
 	Write(internal.Entry) (int64, int64, error)
+	WriteMany(entries []internal.Entry) ([]int64, []int64, error)
+	WriteFrom(e internal.Entry, r io.Reader, size int64, h hash.Hash) (int64, int64, error)
 	Close() error
 }
 
 type datafile struct {
-	mu           sync.Mutex
-	r            *os.File
+	mu sync.Mutex
+	r  *os.File
+	// ra maps a read-only datafile on its first ReadAt
+	raOnce       sync.Once
 	ra           *mmap.ReaderAt
+	raErr        error
 	w            *os.File
 	id           int
 	offset       int64
 	maxKeySize   uint32
 	maxValueSize uint64
+	aead         cipher.AEAD
+	checksumSize int
 	enc          *codec.Encoder
 	dec          *codec.Decoder
 }
 
-func NewDatafile(path string, id int, readonly bool, maxKeySize uint32, maxValueSize uint64) (DataFile, error) {
+// NewDatafile opens the datafile with the given id in path, named, sized,
+// compressed, encrypted and created with the permissions cfg says.
+func NewDatafile(path string, id int, readonly bool, cfg *config.Config) (DataFile, error) {
 	var (
 		r   *os.File
-		ra  *mmap.ReaderAt
 		w   *os.File
 		err error
 	)
-	fn := filepath.Join(path, fmt.Sprintf(defaultDatafileFilename, id))
+	aead, err := codec.NewCipher(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	fn := filepath.Join(path, fmt.Sprintf(cfg.DatafileFormat, id))
 	if !readonly {
-		w, err = os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		created := !internal.Exists(fn)
+		w, err = os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_APPEND, cfg.FileModeOr(0640))
 		if err != nil {
 			return nil, err
 		}
+		// a new datafile isn't durable until its directory entry is
+		if created {
+			if err := internal.FsyncDir(path); err != nil {
+				w.Close()
+				return nil, err
+			}
+		}
 	}
 	r, err = os.Open(fn)
 	if err != nil {
 		return nil, err
 	}
-	ra, err = mmap.Open(fn)
-	if err != nil {
-		return nil, err
-	}
 	stat, err := os.Stat(fn)
 	if err != nil {
 		return nil, err
 	}
 	offset := stat.Size()
-	enc := codec.NewEncoder(w)
-	dec := codec.NewDecoder(r, maxKeySize, maxValueSize)
+	enc := codec.NewEncoder(w, cfg.Compression, aead, cfg.ChecksumSize())
+	if cfg.WriteBuffer > 0 {
+		enc = codec.NewBufferedEncoder(w, cfg.WriteBuffer, cfg.Compression, aead, cfg.ChecksumSize())
+	}
+	dec := codec.NewDecoder(r, cfg.MaxKeySize, cfg.MaxValueSize, aead, cfg.ChecksumSize())
 
 	return &datafile{
 		id:           id,
 		r:            r,
 		w:            w,
-		ra:           ra,
 		offset:       offset,
 		enc:          enc,
 		dec:          dec,
-		maxKeySize:   maxKeySize,
-		maxValueSize: maxValueSize,
+		maxKeySize:   cfg.MaxKeySize,
+		maxValueSize: cfg.MaxValueSize,
+		aead:         aead,
+		checksumSize: cfg.ChecksumSize(),
 	}, nil
 }
 
@@ -108,7 +130,20 @@ func (d *datafile) Sync() error {
 	}
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	return d.w.Sync()
+	if err := d.enc.Flush(); err != nil {
+		return ioError("write", d.id, d.offset, err)
+	}
+	return ioError("sync", d.id, d.offset, d.w.Sync())
+}
+
+// Flush writes the buffered entries to the file without syncing it
+func (d *datafile) Flush() error {
+	if d.w == nil {
+		return errReadOnly
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return ioError("write", d.id, d.offset, d.enc.Flush())
 }
 
 func (d *datafile) Read() (e internal.Entry, n int64, err error) {
@@ -119,23 +154,38 @@ func (d *datafile) Read() (e internal.Entry, n int64, err error) {
 }
 
 func (d *datafile) ReadAt(offset, size int64) (e internal.Entry, err error) {
+	// a corrupt size mustn't make it allocate whatever it says
+	if size <= 0 || size > codec.MaxEncodedSize(d.maxKeySize, d.maxValueSize, d.aead != nil, d.checksumSize) {
+		return e, ioError("read", d.id, offset, errors.Wrapf(codec.ErrCorruptSize, "size %d", size))
+	}
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	b := make([]byte, size)
 	var n int
 	if d.w == nil {
+		d.raOnce.Do(func() {
+			d.ra, d.raErr = mmap.Open(d.r.Name())
+		})
+		if d.raErr != nil {
+			return e, ioError("read", d.id, offset, d.raErr)
+		}
 		n, err = d.ra.ReadAt(b, offset)
 	} else {
+		// the entry may still be in the encoder's buffer
+		if d.enc.Buffered() > 0 {
+			if err = d.enc.Flush(); err != nil {
+				return e, ioError("write", d.id, d.offset, err)
+			}
+		}
 		n, err = d.r.ReadAt(b, offset)
 	}
 	if err != nil {
-		return
+		return e, ioError("read", d.id, offset, err)
 	}
 	if int64(n) != size {
-		err = errReadError
-		return
+		return e, ioError("read", d.id, offset, errReadError)
 	}
-	codec.DecodeEntry(b, &e, d.maxKeySize, d.maxValueSize)
+	err = codec.DecodeEntry(b, &e, d.maxKeySize, d.maxValueSize, d.aead, d.checksumSize)
 	return
 }
 
@@ -146,15 +196,66 @@ func (d *datafile) Write(e internal.Entry) (offset int64, size int64, err error)
 	e.Offset = d.offset
 	n, err := d.enc.Encode(e)
 	if err != nil {
-		return -1, 0, err
+		return -1, 0, ioError("write", d.id, e.Offset, err)
 	}
 	d.offset += n
 	return e.Offset, n, nil
 }
 
+// WriteMany writes entries at once, returning the offset and size of each. A
+// failed write leaves the datafile as it was.
+func (d *datafile) WriteMany(entries []internal.Entry) (offsets []int64, sizes []int64, err error) {
+	if d.w == nil {
+		return nil, nil, errReadOnly
+	}
+	// only the entries are buffered in case they must be dropped
+	if err := d.enc.Flush(); err != nil {
+		return nil, nil, ioError("write", d.id, d.offset, err)
+	}
+	sizes, err = d.enc.EncodeMany(entries)
+	if err != nil {
+		d.enc.Discard()
+		if terr := d.w.Truncate(d.offset); terr != nil {
+			return nil, nil, ioError("truncate", d.id, d.offset, terr)
+		}
+		return nil, nil, ioError("write", d.id, d.offset, err)
+	}
+	offsets = make([]int64, len(sizes))
+	for i, n := range sizes {
+		offsets[i] = d.offset
+		d.offset += n
+	}
+	return offsets, sizes, nil
+}
+
+// WriteFrom writes e with the value of the given size read from r, see
+// codec.Encoder.EncodeFrom. A failed write leaves the datafile as it was.
+func (d *datafile) WriteFrom(e internal.Entry, r io.Reader, size int64, h hash.Hash) (offset int64, n int64, err error) {
+	if d.w == nil {
+		return -1, 0, errReadOnly
+	}
+	// only the entry is buffered in case it must be dropped
+	if err := d.enc.Flush(); err != nil {
+		return -1, 0, ioError("write", d.id, d.offset, err)
+	}
+	n, err = d.enc.EncodeFrom(e, r, size, h)
+	if err != nil {
+		d.enc.Discard()
+		if terr := d.w.Truncate(d.offset); terr != nil {
+			return -1, 0, ioError("truncate", d.id, d.offset, terr)
+		}
+		return -1, 0, ioError("write", d.id, d.offset, err)
+	}
+	offset = d.offset
+	d.offset += n
+	return offset, n, nil
+}
+
 func (d *datafile) Close() error {
 	defer func() {
-		d.ra.Close()
+		if d.ra != nil {
+			d.ra.Close()
+		}
 		d.r.Close()
 	}()
 	if d.w == nil {
@@ -166,3 +267,43 @@ func (d *datafile) Close() error {
 	}
 	return d.w.Close()
 }
+
+// ScanDatafile decodes the datafile at path from its start to its end, named,
+// sized and encrypted as cfg says, calling fn with every entry along with its
+// offset and encoded size, which ReadAt takes to read it again. It stops at
+// the first error fn or decoding returns.
+func ScanDatafile(path string, cfg *config.Config, fn func(e internal.Entry, offset, size int64) error) error {
+	return ScanDatafileFrom(path, cfg, 0, fn)
+}
+
+// ScanDatafileFrom is like ScanDatafile but starts at offset, which must be
+// that of an entry or the end of the datafile.
+func ScanDatafileFrom(path string, cfg *config.Config, offset int64, fn func(e internal.Entry, offset, size int64) error) error {
+	aead, err := codec.NewCipher(cfg.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	dec := codec.NewDecoder(bufio.NewReader(f), cfg.MaxKeySize, cfg.MaxValueSize, aead, cfg.ChecksumSize())
+	for {
+		var e internal.Entry
+		n, err := dec.Decode(&e)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(e, offset, n); err != nil {
+			return err
+		}
+		offset += n
+	}
+}
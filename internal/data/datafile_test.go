@@ -0,0 +1,142 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/config"
+)
+
+// tempDir returns a new temporary directory removed once the test ends
+func tempDir(tb testing.TB) string {
+	dir, err := ioutil.TempDir("", "bitcask-")
+	if err != nil {
+		tb.Fatalf("temp dir error: %v", err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		MaxKeySize:     64,
+		MaxValueSize:   1 << 16,
+		DatafileFormat: internal.DefaultDatafileFormat,
+	}
+}
+
+func TestLazyMmap(t *testing.T) {
+	path := tempDir(t)
+	cfg := testConfig()
+	w, err := NewDatafile(path, 0, false, cfg)
+	if err != nil {
+		t.Fatalf("open writable error: %v", err)
+	}
+	offset, n, err := w.Write(internal.NewEntry([]byte("key"), []byte("value")))
+	if err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close writable error: %v", err)
+	}
+
+	df, err := NewDatafile(path, 0, true, cfg)
+	if err != nil {
+		t.Fatalf("open read-only error: %v", err)
+	}
+	defer df.Close()
+	if df.(*datafile).ra != nil {
+		t.Errorf("mmap before read, want: nil, got: mapped")
+	}
+	for i := 0; i < 2; i++ {
+		e, err := df.ReadAt(offset, n)
+		if err != nil || string(e.Value) != "value" {
+			t.Errorf("read at, want: %s, got: %s (%v)", "value", e.Value, err)
+		}
+	}
+	if df.(*datafile).ra == nil {
+		t.Errorf("mmap after read, want: mapped, got: nil")
+	}
+}
+
+func TestScanDatafile(t *testing.T) {
+	path := tempDir(t)
+	cfg := testConfig()
+	df, err := NewDatafile(path, 0, false, cfg)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer df.Close()
+	var offsets, sizes []int64
+	for _, value := range []string{"a", "bb", "", "dddd"} {
+		offset, n, err := df.Write(internal.NewEntry([]byte("key-"+value), []byte(value)))
+		if err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+		offsets = append(offsets, offset)
+		sizes = append(sizes, n)
+	}
+
+	i := 0
+	err = ScanDatafile(df.Name(), cfg, func(e internal.Entry, offset, size int64) error {
+		if i >= len(offsets) || offset != offsets[i] || size != sizes[i] {
+			t.Errorf("entry %d location, want: %d/%d, got: %d/%d", i, offsets[i], sizes[i], offset, size)
+		}
+		got, err := df.ReadAt(offset, size)
+		if err != nil || !bytes.Equal(got.Key, e.Key) || !bytes.Equal(got.Value, e.Value) {
+			t.Errorf("read at scanned location, want: %s, got: %s (%v)", e.Key, got.Key, err)
+		}
+		i++
+		return nil
+	})
+	if err != nil || i != len(offsets) {
+		t.Errorf("scan, want: %d entries, got: %d (%v)", len(offsets), i, err)
+	}
+
+	stop := errors.New("stop")
+	if err := ScanDatafile(df.Name(), cfg, func(internal.Entry, int64, int64) error { return stop }); err != stop {
+		t.Errorf("scan stopped by fn, want: %v, got: %v", stop, err)
+	}
+}
+
+func TestIOError(t *testing.T) {
+	path := tempDir(t)
+	cfg := testConfig()
+	df, err := NewDatafile(path, 3, false, cfg)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer df.Close()
+	offset, n, err := df.Write(internal.NewEntry([]byte("key"), []byte("value")))
+	if err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	// reading past the end of the datafile
+	_, err = df.ReadAt(offset+n, n)
+	ioErr, ok := AsIOError(err)
+	if !ok {
+		t.Fatalf("read error, want: IOError, got: %v", err)
+	}
+	if ioErr.Op != "read" || ioErr.FileID != 3 || ioErr.Offset != offset+n {
+		t.Errorf("read error, want: read/3/%d, got: %s/%d/%d", offset+n, ioErr.Op, ioErr.FileID, ioErr.Offset)
+	}
+
+	// writing to a closed file
+	df.(*datafile).w.Close()
+	_, _, err = df.Write(internal.NewEntry([]byte("key"), []byte("value")))
+	ioErr, ok = AsIOError(err)
+	if !ok {
+		t.Fatalf("write error, want: IOError, got: %v", err)
+	}
+	if ioErr.Op != "write" || ioErr.FileID != 3 || ioErr.Offset != n {
+		t.Errorf("write error, want: write/3/%d, got: %s/%d/%d", n, ioErr.Op, ioErr.FileID, ioErr.Offset)
+	}
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("write error cause, want: %v, got: %v", os.ErrClosed, err)
+	}
+}
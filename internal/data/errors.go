@@ -0,0 +1,47 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// IOError is the failure of an operation on a datafile, reading or writing
+// at Offset
+type IOError struct {
+	Op     string
+	FileID int
+	Offset int64
+	Err    error
+}
+
+func (e *IOError) Error() string {
+	return fmt.Sprintf("%s datafile %d at offset %d: %v", e.Op, e.FileID, e.Offset, e.Err)
+}
+
+// Cause returns the underlying error, see errors.Cause
+func (e *IOError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying error, see errors.Unwrap
+func (e *IOError) Unwrap() error {
+	return e.Err
+}
+
+// AsIOError returns the IOError err wraps, if any
+func AsIOError(err error) (*IOError, bool) {
+	var ioErr *IOError
+	if errors.As(err, &ioErr) {
+		return ioErr, true
+	}
+	return nil, false
+}
+
+// ioError wraps err, if any, with the operation on the datafile id at offset
+func ioError(op string, id int, offset int64, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &IOError{Op: op, FileID: id, Offset: offset, Err: err}
+}
@@ -0,0 +1,174 @@
+package data
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/config"
+	"jay.com/bitcask/internal/data/codec"
+)
+
+// NewMemDatafile returns an empty datafile with the given id held in memory,
+// encoded as cfg says. It stays writable and readable until it's dropped,
+// Sync and Close only flush the encoder's buffer.
+func NewMemDatafile(id int, cfg *config.Config) (DataFile, error) {
+	aead, err := codec.NewCipher(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	d := &memDatafile{
+		id:           id,
+		name:         fmt.Sprintf(cfg.DatafileFormat, id),
+		maxKeySize:   cfg.MaxKeySize,
+		maxValueSize: cfg.MaxValueSize,
+		aead:         aead,
+		checksumSize: cfg.ChecksumSize(),
+	}
+	d.enc = codec.NewEncoder(&d.buf, cfg.Compression, aead, cfg.ChecksumSize())
+	if cfg.WriteBuffer > 0 {
+		d.enc = codec.NewBufferedEncoder(&d.buf, cfg.WriteBuffer, cfg.Compression, aead, cfg.ChecksumSize())
+	}
+	d.dec = codec.NewDecoder(&memReader{d: d}, cfg.MaxKeySize, cfg.MaxValueSize, aead, cfg.ChecksumSize())
+	return d, nil
+}
+
+type memDatafile struct {
+	mu           sync.Mutex
+	buf          bytes.Buffer
+	id           int
+	name         string
+	offset       int64
+	maxKeySize   uint32
+	maxValueSize uint64
+	aead         cipher.AEAD
+	checksumSize int
+	enc          *codec.Encoder
+	dec          *codec.Decoder
+}
+
+// memReader reads the datafile sequentially for Read
+type memReader struct {
+	d   *memDatafile
+	pos int
+}
+
+func (r *memReader) Read(p []byte) (int, error) {
+	b := r.d.buf.Bytes()
+	if r.pos >= len(b) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+func (d *memDatafile) FileID() int {
+	return d.id
+}
+
+func (d *memDatafile) Name() string {
+	return d.name
+}
+
+func (d *memDatafile) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.offset
+}
+
+func (d *memDatafile) Sync() error {
+	return d.Flush()
+}
+
+func (d *memDatafile) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return ioError("write", d.id, d.offset, d.enc.Flush())
+}
+
+func (d *memDatafile) Read() (e internal.Entry, n int64, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n, err = d.dec.Decode(&e)
+	return
+}
+
+func (d *memDatafile) ReadAt(offset, size int64) (e internal.Entry, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.enc.Buffered() > 0 {
+		if err = d.enc.Flush(); err != nil {
+			return e, ioError("write", d.id, d.offset, err)
+		}
+	}
+	b := d.buf.Bytes()
+	if size <= 0 || size > codec.MaxEncodedSize(d.maxKeySize, d.maxValueSize, d.aead != nil, d.checksumSize) {
+		return e, ioError("read", d.id, offset, errors.Wrapf(codec.ErrCorruptSize, "size %d", size))
+	}
+	if offset < 0 || offset+size > int64(len(b)) {
+		return e, ioError("read", d.id, offset, errReadError)
+	}
+	err = codec.DecodeEntry(b[offset:offset+size], &e, d.maxKeySize, d.maxValueSize, d.aead, d.checksumSize)
+	return
+}
+
+func (d *memDatafile) Write(e internal.Entry) (int64, int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e.Offset = d.offset
+	n, err := d.enc.Encode(e)
+	if err != nil {
+		return -1, 0, ioError("write", d.id, e.Offset, err)
+	}
+	d.offset += n
+	return e.Offset, n, nil
+}
+
+// WriteMany writes entries at once like datafile.WriteMany
+func (d *memDatafile) WriteMany(entries []internal.Entry) ([]int64, []int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.enc.Flush(); err != nil {
+		return nil, nil, ioError("write", d.id, d.offset, err)
+	}
+	sizes, err := d.enc.EncodeMany(entries)
+	if err != nil {
+		d.enc.Discard()
+		d.buf.Truncate(int(d.offset))
+		return nil, nil, ioError("write", d.id, d.offset, err)
+	}
+	offsets := make([]int64, len(sizes))
+	for i, n := range sizes {
+		offsets[i] = d.offset
+		d.offset += n
+	}
+	return offsets, sizes, nil
+}
+
+// WriteFrom writes e with its value read from r like datafile.WriteFrom
+func (d *memDatafile) WriteFrom(e internal.Entry, r io.Reader, size int64, h hash.Hash) (int64, int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.enc.Flush(); err != nil {
+		return -1, 0, ioError("write", d.id, d.offset, err)
+	}
+	n, err := d.enc.EncodeFrom(e, r, size, h)
+	if err != nil {
+		d.enc.Discard()
+		d.buf.Truncate(int(d.offset))
+		return -1, 0, ioError("write", d.id, d.offset, err)
+	}
+	offset := d.offset
+	d.offset += n
+	return offset, n, nil
+}
+
+func (d *memDatafile) Close() error {
+	return d.Flush()
+}
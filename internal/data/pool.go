@@ -0,0 +1,171 @@
+package data
+
+import (
+	"container/list"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/config"
+)
+
+// Pool bounds the number of open read-only datafiles. Datafiles opened
+// through it are only opened when read, and the least recently used ones are
+// closed once more than max are open. Datafiles in the middle of a read are
+// never closed, so max may be exceeded while many are read concurrently.
+type Pool struct {
+	mu  sync.Mutex
+	max int
+	// lru holds the open datafiles, the most recently used first
+	lru *list.List
+}
+
+// NewPool returns a Pool keeping at most max datafiles open
+func NewPool(max int) *Pool {
+	return &Pool{max: max, lru: list.New()}
+}
+
+// Open returns the read-only datafile with the given id in path, opened on
+// demand. Its sequential reads with Read restart from the beginning if it's
+// closed in between, which only happens when other datafiles are read.
+func (p *Pool) Open(path string, id int, cfg *config.Config) (DataFile, error) {
+	name := filepath.Join(path, fmt.Sprintf(cfg.DatafileFormat, id))
+	stat, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledDatafile{
+		pool: p,
+		path: path,
+		id:   id,
+		cfg:  cfg,
+		name: name,
+		size: stat.Size(),
+	}, nil
+}
+
+// acquire opens df if needed and keeps it open until release
+func (p *Pool) acquire(df *pooledDatafile) (DataFile, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if df.df == nil {
+		f, err := NewDatafile(df.path, df.id, true, df.cfg)
+		if err != nil {
+			return nil, err
+		}
+		df.df = f
+		df.elem = p.lru.PushFront(df)
+	} else {
+		p.lru.MoveToFront(df.elem)
+	}
+	df.refs++
+	p.evict()
+	return df.df, nil
+}
+
+func (p *Pool) release(df *pooledDatafile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	df.refs--
+	p.evict()
+}
+
+// evict closes the least recently used datafiles not being read until at
+// most max are open. It must be called with p.mu held.
+func (p *Pool) evict() {
+	for elem := p.lru.Back(); elem != nil && p.lru.Len() > p.max; {
+		prev := elem.Prev()
+		if df := elem.Value.(*pooledDatafile); df.refs == 0 {
+			df.close()
+		}
+		elem = prev
+	}
+}
+
+// pooledDatafile is a read-only datafile opened by its Pool on demand
+type pooledDatafile struct {
+	pool *Pool
+	path string
+	id   int
+	cfg  *config.Config
+	name string
+	size int64
+
+	// df is the open datafile, nil if closed, and elem its element in the
+	// pool's lru, both guarded by pool.mu like refs, the number of reads
+	// using df
+	df   DataFile
+	elem *list.Element
+	refs int
+}
+
+func (d *pooledDatafile) FileID() int {
+	return d.id
+}
+
+func (d *pooledDatafile) Name() string {
+	return d.name
+}
+
+func (d *pooledDatafile) Size() int64 {
+	return d.size
+}
+
+func (d *pooledDatafile) Sync() error {
+	return errReadOnly
+}
+
+func (d *pooledDatafile) Flush() error {
+	return errReadOnly
+}
+
+func (d *pooledDatafile) Read() (internal.Entry, int64, error) {
+	df, err := d.pool.acquire(d)
+	if err != nil {
+		return internal.Entry{}, 0, err
+	}
+	defer d.pool.release(d)
+	return df.Read()
+}
+
+func (d *pooledDatafile) ReadAt(offset, size int64) (internal.Entry, error) {
+	df, err := d.pool.acquire(d)
+	if err != nil {
+		return internal.Entry{}, err
+	}
+	defer d.pool.release(d)
+	return df.ReadAt(offset, size)
+}
+
+func (d *pooledDatafile) Write(internal.Entry) (int64, int64, error) {
+	return -1, 0, errReadOnly
+}
+
+func (d *pooledDatafile) WriteMany([]internal.Entry) ([]int64, []int64, error) {
+	return nil, nil, errReadOnly
+}
+
+func (d *pooledDatafile) WriteFrom(internal.Entry, io.Reader, int64, hash.Hash) (int64, int64, error) {
+	return -1, 0, errReadOnly
+}
+
+func (d *pooledDatafile) Close() error {
+	d.pool.mu.Lock()
+	defer d.pool.mu.Unlock()
+	return d.close()
+}
+
+// close closes the open datafile, it must be called with pool.mu held
+func (d *pooledDatafile) close() error {
+	if d.df == nil {
+		return nil
+	}
+	err := d.df.Close()
+	d.pool.lru.Remove(d.elem)
+	d.df, d.elem = nil, nil
+	return err
+}
@@ -1,21 +1,40 @@
 package internal
 
-import "hash/crc32"
+import "time"
 
 // Entry wrap key, value, offset and value checksum
 type Entry struct {
-	Checksum uint32
+	Checksum uint64
 	Key      []byte
 	Offset   int64
 	Value    []byte
+	// Expiry is the unix time in nanoseconds after which the entry expires,
+	// zero means it never expires
+	Expiry int64
+	// Timestamp is the unix time in nanoseconds the entry was created at
+	Timestamp int64
+	// Tombstone marks the entry deleting its key, its value is empty
+	Tombstone bool
 }
 
 // NewEntry return new entry
 func NewEntry(key, value []byte) Entry {
-	checksum := crc32.ChecksumIEEE(value)
+	return NewEntryWithChecksumMode(ChecksumValueOnly, key, value)
+}
+
+// NewEntryWithChecksumMode return new entry whose checksum is computed
+// according to mode
+func NewEntryWithChecksumMode(mode string, key, value []byte) Entry {
+	return NewEntryWithChecksum(mode, ChecksumCRC32, key, value)
+}
+
+// NewEntryWithChecksum return new entry whose checksum is computed according
+// to mode with the given algorithm
+func NewEntryWithChecksum(mode, algorithm string, key, value []byte) Entry {
 	return Entry{
-		Checksum: checksum,
-		Key:      key,
-		Value:    value,
+		Checksum:  Checksum(mode, algorithm, key, value),
+		Key:       key,
+		Value:     value,
+		Timestamp: time.Now().UnixNano(),
 	}
 }
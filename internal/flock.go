@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package internal
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned by Flock when the lock is held by another process
+var ErrLocked = errors.New("error: locked by another process")
+
+// Flock creates the file at path and takes an exclusive advisory lock on it.
+// The lock is released by the OS once the returned file is closed, even if the
+// process exits without closing it.
+func Flock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
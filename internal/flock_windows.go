@@ -0,0 +1,16 @@
+package internal
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned by Flock when the lock is held by another process
+var ErrLocked = errors.New("error: locked by another process")
+
+// Flock creates the file at path. Advisory locks are not supported on windows
+// so the file is not locked.
+func Flock(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package internal
+
+import "os"
+
+// FsyncDir flushes the directory at path, making the files created in it
+// durable
+func FsyncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
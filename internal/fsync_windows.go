@@ -0,0 +1,6 @@
+package internal
+
+// FsyncDir does nothing, directories can't be flushed on windows
+func FsyncDir(path string) error {
+	return nil
+}
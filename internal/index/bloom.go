@@ -0,0 +1,100 @@
+package index
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// bitsPerKey and hashes give a false positive rate of about 1%
+const (
+	bitsPerKey = 10
+	hashes     = 7
+)
+
+var errInvalidBloom = errors.New("invalid bloom filter")
+
+// Bloom is a bloom filter of the keys of a datafile, telling for sure when a
+// key isn't in it
+type Bloom struct {
+	bits []byte
+	k    uint8
+}
+
+// NewBloom returns a bloom filter holding keys
+func NewBloom(keys [][]byte) *Bloom {
+	n := len(keys) * bitsPerKey
+	if n < 64 {
+		n = 64
+	}
+	b := &Bloom{bits: make([]byte, (n+7)/8), k: hashes}
+	for _, key := range keys {
+		b.add(key)
+	}
+	return b
+}
+
+// MayContain returns false if key is certainly not in the filter
+func (b *Bloom) MayContain(key []byte) bool {
+	h1, h2 := bloomHashes(key)
+	m := uint32(len(b.bits) * 8)
+	for i := uint32(0); i < uint32(b.k); i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bloom) add(key []byte) {
+	h1, h2 := bloomHashes(key)
+	m := uint32(len(b.bits) * 8)
+	for i := uint32(0); i < uint32(b.k); i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// bloomHashes derives the two hashes combined into the k hashes of a key
+func bloomHashes(key []byte) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// SaveBloom writes b to path with the given permissions
+func SaveBloom(path string, b *Bloom, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, 1+int32Size, 1+int32Size+len(b.bits))
+	buf[0] = b.k
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(b.bits)))
+	if _, err := f.Write(append(buf, b.bits...)); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// LoadBloom reads the bloom filter saved at path
+func LoadBloom(path string) (*Bloom, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 1+int32Size || buf[0] == 0 {
+		return nil, errInvalidBloom
+	}
+	n := binary.BigEndian.Uint32(buf[1:])
+	if n == 0 || uint64(len(buf)) != 1+int32Size+uint64(n) {
+		return nil, errInvalidBloom
+	}
+	return &Bloom{bits: buf[1+int32Size:], k: buf[0]}, nil
+}
@@ -0,0 +1,38 @@
+package index
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestBloom(t *testing.T) {
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+	}
+	path := filepath.Join(tempDir(t), "000000000.bloom")
+	if err := SaveBloom(path, NewBloom(keys), 0600); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+	b, err := LoadBloom(path)
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+
+	for _, key := range keys {
+		if !b.MayContain(key) {
+			t.Fatalf("present key %s filtered out", key)
+		}
+	}
+	positives := 0
+	const absent = 10000
+	for i := 0; i < absent; i++ {
+		if b.MayContain([]byte(fmt.Sprintf("absent-%d", i))) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / absent; rate > 0.03 {
+		t.Errorf("false positive rate, want: <= 0.03, got: %.4f", rate)
+	}
+}
@@ -0,0 +1,64 @@
+package index
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"jay.com/bitcask/internal"
+)
+
+// SaveHint writes a hint file to path holding the key and item of every entry
+// of a datafile, in the order they were written, using the index encoding. The
+// file is created with the given permissions.
+func SaveHint(path string, keys [][]byte, items []internal.Item, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if err := writeHeader(w); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if err := writeKey(key, w); err != nil {
+			return err
+		}
+		if err := writeItem(items[i], w); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// LoadHint reads the hint file at path and calls fn for every record in order
+func LoadHint(path string, maxKeySize uint32, fn func(key []byte, item internal.Item)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	version, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		key, err := readKey(r, maxKeySize)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		item, err := readItem(r, version)
+		if err != nil {
+			return err
+		}
+		fn(key, item)
+	}
+}
@@ -1,78 +1,112 @@
 package index
 
 import (
+	"bufio"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"os"
 
 	"github.com/pkg/errors"
-	art "github.com/plar/go-adaptive-radix-tree"
 	"jay.com/bitcask/internal"
 )
 
 const (
-	int32Size  = 4
-	int64Size  = 8
-	fileIDSize = int32Size
-	offsetSize = int64Size
-	sizeSize   = int64Size
+	int32Size     = 4
+	int64Size     = 8
+	fileIDSize    = int32Size
+	offsetSize    = int64Size
+	sizeSize      = int64Size
+	timestampSize = int64Size
 )
 
+// magic starts index and hint files since version 2, followed by a version
+// byte. Version 1 files have no header, their first bytes are the size of a
+// key, which is never as large as magic reads, and their items no timestamp.
+// Since version 3 the header of index files is followed by a watermark.
+const (
+	magic   = "\xffIDX"
+	Version = 3
+)
+
+// Watermark is how far into the datafiles an index reflects them: every entry
+// before Offset in the datafile FileID, and in the datafiles before it.
+type Watermark struct {
+	FileID int
+	Offset int64
+}
+
 var (
 	errTruncatedKeySize = errors.New("key size is truncated")
 	errTruncatedKeyData = errors.New("key data is truncated")
 	errTruncatedData    = errors.New("data is truncated")
 	errKeySizeTooLarge  = errors.New("key size too large")
+	errUnknownVersion   = errors.New("unknown index version")
 )
 
 type Indexer interface {
-	Load(path string, maxKeySize uint32) (art.Tree, bool, error)
-	Save(t art.Tree, path string) error
+	// Load inserts the items of the index file at path into t, returning its
+	// watermark, nil for files older than version 3, and reports whether the
+	// file exists
+	Load(t Tree, path string, maxKeySize uint32) (*Watermark, bool, error)
+	Save(t Tree, path string, wm Watermark) error
 }
 
-func NewIndexer() *indexer {
-	return &indexer{}
+// NewIndexer returns an Indexer logging to logger and saving index files with
+// the given permissions
+func NewIndexer(logger internal.Logger, mode os.FileMode) *indexer {
+	return &indexer{logger: logger, mode: mode}
 }
 
 type indexer struct {
+	logger internal.Logger
+	mode   os.FileMode
 }
 
-func (i *indexer) Load(path string, maxKeySize uint32) (art.Tree, bool, error) {
-	fmt.Println(path)
-	t := art.New()
+func (i *indexer) Load(t Tree, path string, maxKeySize uint32) (*Watermark, bool, error) {
+	i.logger.Printf("loading index %s", path)
 	if !internal.Exists(path) {
-		return t, false, nil
+		return nil, false, nil
 	}
 	f, err := os.Open(path)
 	if err != nil {
-		return t, true, err
+		return nil, true, err
 	}
-	if err := readIndex(t, f, maxKeySize); err != nil {
-		return t, true, err
+	defer f.Close()
+	wm, err := readIndex(t, bufio.NewReader(f), maxKeySize)
+	if err != nil {
+		return nil, true, err
 	}
-	return t, true, nil
+	return wm, true, nil
 }
 
-func (i *indexer) Save(t art.Tree, path string) error {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+func (i *indexer) Save(t Tree, path string, wm Watermark) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, i.mode)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	if err := writeIndex(t, f); err != nil {
+	w := bufio.NewWriter(f)
+	if err := writeIndex(t, w, wm); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
 		return err
 	}
 	return f.Sync()
 }
 
-func writeIndex(t art.Tree, w io.Writer) (err error) {
-	t.ForEach(func(node art.Node) (cont bool) {
-		err = writeKey(node.Key(), w)
+func writeIndex(t Tree, w io.Writer, wm Watermark) (err error) {
+	if err := writeHeader(w); err != nil {
+		return err
+	}
+	if err := writeWatermark(wm, w); err != nil {
+		return err
+	}
+	t.ForEach(func(key []byte, item internal.Item) bool {
+		err = writeKey(key, w)
 		if err != nil {
 			return false
 		}
-		item := node.Value().(internal.Item)
 		err = writeItem(item, w)
 		if err != nil {
 			return false
@@ -82,22 +116,74 @@ func writeIndex(t art.Tree, w io.Writer) (err error) {
 	return
 }
 
-func readIndex(t art.Tree, r io.Reader, maxKeySize uint32) error {
+func readIndex(t Tree, r *bufio.Reader, maxKeySize uint32) (*Watermark, error) {
+	version, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	var wm *Watermark
+	if version >= 3 {
+		if wm, err = readWatermark(r); err != nil {
+			return nil, err
+		}
+	}
 	for {
 		key, err := readKey(r, maxKeySize)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return nil, err
 		}
-		item, err := readItem(r)
+		item, err := readItem(r, version)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		t.Insert(key, item)
 	}
-	return nil
+	return wm, nil
+}
+
+func writeHeader(w io.Writer) error {
+	_, err := w.Write(append([]byte(magic), Version))
+	return err
+}
+
+// readHeader returns the version of the index or hint file read by r,
+// consuming its header if it has one
+func readHeader(r *bufio.Reader) (int, error) {
+	b, err := r.Peek(len(magic) + 1)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if len(b) < len(magic)+1 || string(b[:len(magic)]) != magic {
+		return 1, nil
+	}
+	version := int(b[len(magic)])
+	if version < 2 || version > Version {
+		return 0, errors.Wrapf(errUnknownVersion, "version %d", version)
+	}
+	_, err = r.Discard(len(b))
+	return version, err
+}
+
+func writeWatermark(wm Watermark, w io.Writer) error {
+	buf := make([]byte, fileIDSize+offsetSize)
+	binary.BigEndian.PutUint32(buf[:fileIDSize], uint32(wm.FileID))
+	binary.BigEndian.PutUint64(buf[fileIDSize:], uint64(wm.Offset))
+	_, err := w.Write(buf)
+	return err
+}
+
+func readWatermark(r io.Reader) (*Watermark, error) {
+	buf := make([]byte, fileIDSize+offsetSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, errors.Wrap(errTruncatedData, err.Error())
+	}
+	return &Watermark{
+		FileID: int(binary.BigEndian.Uint32(buf[:fileIDSize])),
+		Offset: int64(binary.BigEndian.Uint64(buf[fileIDSize:])),
+	}, nil
 }
 
 func writeKey(b []byte, w io.Writer) error {
@@ -135,24 +221,35 @@ func readKey(r io.Reader, maxKeySize uint32) ([]byte, error) {
 }
 
 func writeItem(i internal.Item, w io.Writer) error {
-	buf := make([]byte, fileIDSize+offsetSize+sizeSize)
+	buf := make([]byte, fileIDSize+offsetSize+sizeSize+timestampSize)
 	binary.BigEndian.PutUint32(buf[:fileIDSize], uint32(i.FileID))
 	binary.BigEndian.PutUint64(buf[fileIDSize:fileIDSize+offsetSize], uint64(i.Offset))
 	binary.BigEndian.PutUint64(buf[fileIDSize+offsetSize:], uint64(i.Size))
+	binary.BigEndian.PutUint64(buf[fileIDSize+offsetSize+sizeSize:], uint64(i.Timestamp))
 	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	return nil
 }
 
-func readItem(r io.Reader) (internal.Item, error) {
-	buf := make([]byte, fileIDSize+offsetSize+sizeSize)
+// readItem reads an item of an index or hint file of the given version, only
+// items since version 2 hold a timestamp
+func readItem(r io.Reader, version int) (internal.Item, error) {
+	size := fileIDSize + offsetSize + sizeSize
+	if version >= 2 {
+		size += timestampSize
+	}
+	buf := make([]byte, size)
 	if _, err := io.ReadFull(r, buf); err != nil {
 		return internal.Item{}, errors.Wrap(errTruncatedData, err.Error())
 	}
-	return internal.Item{
+	item := internal.Item{
 		FileID: int(binary.BigEndian.Uint32(buf[:fileIDSize])),
 		Offset: int64(binary.BigEndian.Uint64(buf[fileIDSize : fileIDSize+offsetSize])),
-		Size:   int64(binary.BigEndian.Uint64(buf[fileIDSize+offsetSize:])),
-	}, nil
+		Size:   int64(binary.BigEndian.Uint64(buf[fileIDSize+offsetSize : fileIDSize+offsetSize+sizeSize])),
+	}
+	if version >= 2 {
+		item.Timestamp = int64(binary.BigEndian.Uint64(buf[fileIDSize+offsetSize+sizeSize:]))
+	}
+	return item, nil
 }
@@ -0,0 +1,153 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"jay.com/bitcask/internal"
+)
+
+// tempDir returns a new temporary directory removed once the test ends
+func tempDir(tb testing.TB) string {
+	dir, err := ioutil.TempDir("", "bitcask-")
+	if err != nil {
+		tb.Fatalf("temp dir error: %v", err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestReadIndexV1(t *testing.T) {
+	items := map[string]internal.Item{
+		"a": {FileID: 1, Offset: 0, Size: 50},
+		"b": {FileID: 2, Offset: 50, Size: 60},
+	}
+	var buf bytes.Buffer
+	for _, key := range []string{"a", "b"} {
+		item := items[key]
+		b := make([]byte, int32Size+len(key)+fileIDSize+offsetSize+sizeSize)
+		binary.BigEndian.PutUint32(b, uint32(len(key)))
+		copy(b[int32Size:], key)
+		rest := b[int32Size+len(key):]
+		binary.BigEndian.PutUint32(rest, uint32(item.FileID))
+		binary.BigEndian.PutUint64(rest[fileIDSize:], uint64(item.Offset))
+		binary.BigEndian.PutUint64(rest[fileIDSize+offsetSize:], uint64(item.Size))
+		buf.Write(b)
+	}
+
+	tree := NewTree(ART)
+	wm, err := readIndex(tree, bufio.NewReader(&buf), 64)
+	if err != nil {
+		t.Fatalf("read version 1 index error: %v", err)
+	}
+	if wm != nil {
+		t.Errorf("watermark of version 1 index, want: nil, got: %+v", wm)
+	}
+	if tree.Size() != len(items) {
+		t.Errorf("keys, want: %d, got: %d", len(items), tree.Size())
+	}
+	for key, want := range items {
+		if got, found := tree.Search([]byte(key)); !found || got != want {
+			t.Errorf("item of %s, want: %+v, got: %+v", key, want, got)
+		}
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	path := filepath.Join(tempDir(t), "index")
+	tree := NewTree(Hashmap)
+	want := internal.Item{FileID: 3, Offset: 100, Size: 50, Timestamp: 1600000000}
+	tree.Insert([]byte("key"), want)
+	indexer := NewIndexer(internal.DiscardLogger, 0600)
+	wm := Watermark{FileID: 3, Offset: 150}
+	if err := indexer.Save(tree, path, wm); err != nil {
+		t.Fatalf("save error: %v", err)
+	}
+
+	loaded := NewTree(ART)
+	got, found, err := indexer.Load(loaded, path, 64)
+	if err != nil || !found {
+		t.Fatalf("load, want: found, got: %v (%v)", found, err)
+	}
+	if got == nil || *got != wm {
+		t.Errorf("watermark, want: %+v, got: %+v", wm, got)
+	}
+	if got, _ := loaded.Search([]byte("key")); got != want {
+		t.Errorf("item, want: %+v, got: %+v", want, got)
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+	path := filepath.Join(tempDir(t), "index")
+	if err := ioutil.WriteFile(path, append([]byte(magic), Version+1), 0600); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	indexer := NewIndexer(internal.DiscardLogger, 0600)
+	if _, _, err := indexer.Load(NewTree(ART), path, 64); err == nil {
+		t.Errorf("load of a newer version, want error, got: nil")
+	}
+}
+
+func TestHintV1(t *testing.T) {
+	path := filepath.Join(tempDir(t), "000000000.hint")
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 3})
+	buf.WriteString("key")
+	item := make([]byte, fileIDSize+offsetSize+sizeSize)
+	binary.BigEndian.PutUint64(item[fileIDSize+offsetSize:], 50)
+	buf.Write(item)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	var got []internal.Item
+	err := LoadHint(path, 64, func(key []byte, item internal.Item) {
+		got = append(got, item)
+	})
+	if err != nil || len(got) != 1 || got[0] != (internal.Item{Size: 50}) {
+		t.Errorf("load version 1 hint, want: one item of size 50, got: %+v (%v)", got, err)
+	}
+}
+
+func TestTreeForEachFrom(t *testing.T) {
+	keys := []string{"a", "ab", "abc", "abd", "ac", "b", "ba", "b\xff", "c\x00", "zz", "\xff\xff"}
+	sort.Strings(keys)
+	for _, kind := range []string{ART, Hashmap} {
+		tree := NewTree(kind)
+		for i, key := range keys {
+			tree.Insert([]byte(key), internal.Item{FileID: i})
+		}
+		for _, start := range []string{"", "a", "aa", "ab", "abb", "abz", "b", "b\xff\x00", "c", "zzz", "\xff\xff\xff"} {
+			var want []string
+			for _, key := range keys {
+				if key >= start {
+					want = append(want, key)
+				}
+			}
+			var got []string
+			tree.ForEachFrom([]byte(start), func(key []byte, _ internal.Item) bool {
+				got = append(got, string(key))
+				return true
+			})
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+				t.Errorf("%s from %q, want: %q, got: %q", kind, start, want, got)
+			}
+		}
+
+		n := 0
+		tree.ForEachFrom([]byte("ab"), func(_ []byte, _ internal.Item) bool {
+			n++
+			return n < 3
+		})
+		if n != 3 {
+			t.Errorf("%s stopped after, want: 3, got: %d", kind, n)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package index
+
+import (
+	"sort"
+
+	art "github.com/plar/go-adaptive-radix-tree"
+	"jay.com/bitcask/internal"
+)
+
+// Kinds of in-memory index
+const (
+	ART     = "art"
+	Hashmap = "hashmap"
+)
+
+// Tree is the in-memory index mapping keys to the items of their entries.
+// Iteration is in lexicographic key order and stops once fn returns false.
+type Tree interface {
+	// Insert sets the item of key and reports whether key existed before
+	Insert(key []byte, item internal.Item) (updated bool)
+	Search(key []byte) (internal.Item, bool)
+	// Delete removes key and reports whether it existed
+	Delete(key []byte) (deleted bool)
+	Size() int
+	ForEach(fn func(key []byte, item internal.Item) bool)
+	// ForEachPrefix iterates over the keys with prefix, all keys if prefix
+	// is empty
+	ForEachPrefix(prefix []byte, fn func(key []byte, item internal.Item) bool)
+	// ForEachFrom iterates over the keys not less than start, all keys if
+	// start is empty
+	ForEachFrom(start []byte, fn func(key []byte, item internal.Item) bool)
+}
+
+// NewTree returns an empty Tree of the given kind, ART or Hashmap, or nil for
+// an unknown kind.
+func NewTree(kind string) Tree {
+	switch kind {
+	case ART:
+		return &artTree{t: art.New()}
+	case Hashmap:
+		return &mapTree{m: make(map[string]internal.Item)}
+	}
+	return nil
+}
+
+// NewTransformTree returns a Tree passing keys through fn before using them
+// with t, so that keys fn maps to the same key are one. Iteration yields keys
+// as fn returned them.
+func NewTransformTree(t Tree, fn func([]byte) []byte) Tree {
+	return &transformTree{t: t, fn: fn}
+}
+
+type transformTree struct {
+	t  Tree
+	fn func([]byte) []byte
+}
+
+func (t *transformTree) Insert(key []byte, item internal.Item) bool {
+	return t.t.Insert(t.fn(key), item)
+}
+
+func (t *transformTree) Search(key []byte) (internal.Item, bool) {
+	return t.t.Search(t.fn(key))
+}
+
+func (t *transformTree) Delete(key []byte) bool {
+	return t.t.Delete(t.fn(key))
+}
+
+func (t *transformTree) Size() int {
+	return t.t.Size()
+}
+
+func (t *transformTree) ForEach(fn func(key []byte, item internal.Item) bool) {
+	t.t.ForEach(fn)
+}
+
+func (t *transformTree) ForEachPrefix(prefix []byte, fn func(key []byte, item internal.Item) bool) {
+	if len(prefix) > 0 {
+		prefix = t.fn(prefix)
+	}
+	t.t.ForEachPrefix(prefix, fn)
+}
+
+func (t *transformTree) ForEachFrom(start []byte, fn func(key []byte, item internal.Item) bool) {
+	if len(start) > 0 {
+		start = t.fn(start)
+	}
+	t.t.ForEachFrom(start, fn)
+}
+
+// artTree is a Tree backed by an adaptive radix tree, keeping keys ordered
+type artTree struct {
+	t art.Tree
+}
+
+func (t *artTree) Insert(key []byte, item internal.Item) bool {
+	_, updated := t.t.Insert(key, item)
+	return updated
+}
+
+func (t *artTree) Search(key []byte) (internal.Item, bool) {
+	value, found := t.t.Search(key)
+	if !found {
+		return internal.Item{}, false
+	}
+	return value.(internal.Item), true
+}
+
+func (t *artTree) Delete(key []byte) bool {
+	_, deleted := t.t.Delete(key)
+	return deleted
+}
+
+func (t *artTree) Size() int {
+	return t.t.Size()
+}
+
+func (t *artTree) ForEach(fn func(key []byte, item internal.Item) bool) {
+	t.ForEachPrefix(nil, fn)
+}
+
+// ForEachPrefix works around the art traversal visiting inner nodes, not
+// matching anything for an empty prefix and ignoring the callback's result.
+func (t *artTree) ForEachPrefix(prefix []byte, fn func(key []byte, item internal.Item) bool) {
+	stopped := false
+	cb := func(node art.Node) bool {
+		if stopped {
+			return false
+		}
+		if node.Kind() != art.Leaf {
+			return true
+		}
+		stopped = !fn(node.Key(), node.Value().(internal.Item))
+		return !stopped
+	}
+	if len(prefix) == 0 {
+		t.t.ForEach(cb)
+		return
+	}
+	t.t.ForEachPrefix(prefix, cb)
+}
+
+// ForEachFrom seeks start with prefix walks, as the art iterator can't seek:
+// the keys not less than start are those with prefix start followed by those
+// with each shorter prefix of start and then a greater byte, longest first.
+func (t *artTree) ForEachFrom(start []byte, fn func(key []byte, item internal.Item) bool) {
+	if len(start) == 0 {
+		t.ForEach(fn)
+		return
+	}
+	stopped := false
+	walk := func(key []byte, item internal.Item) bool {
+		stopped = !fn(key, item)
+		return !stopped
+	}
+	t.ForEachPrefix(start, walk)
+	prefix := append([]byte(nil), start...)
+	for i := len(start) - 1; i >= 0 && !stopped; i-- {
+		for c := int(start[i]) + 1; c <= 0xff && !stopped; c++ {
+			prefix[i] = byte(c)
+			t.ForEachPrefix(prefix[:i+1], walk)
+		}
+	}
+}
+
+// mapTree is a Tree backed by a hash map, with faster lookups than artTree
+// but sorting the keys on every iteration
+type mapTree struct {
+	m map[string]internal.Item
+}
+
+func (t *mapTree) Insert(key []byte, item internal.Item) bool {
+	_, updated := t.m[string(key)]
+	t.m[string(key)] = item
+	return updated
+}
+
+func (t *mapTree) Search(key []byte) (internal.Item, bool) {
+	item, found := t.m[string(key)]
+	return item, found
+}
+
+func (t *mapTree) Delete(key []byte) bool {
+	_, deleted := t.m[string(key)]
+	delete(t.m, string(key))
+	return deleted
+}
+
+func (t *mapTree) Size() int {
+	return len(t.m)
+}
+
+func (t *mapTree) ForEach(fn func(key []byte, item internal.Item) bool) {
+	t.ForEachPrefix(nil, fn)
+}
+
+func (t *mapTree) ForEachPrefix(prefix []byte, fn func(key []byte, item internal.Item) bool) {
+	keys := make([]string, 0, len(t.m))
+	for key := range t.m {
+		if len(key) >= len(prefix) && key[:len(prefix)] == string(prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn([]byte(key), t.m[key]) {
+			return
+		}
+	}
+}
+
+func (t *mapTree) ForEachFrom(start []byte, fn func(key []byte, item internal.Item) bool) {
+	keys := make([]string, 0, len(t.m))
+	for key := range t.m {
+		if key >= string(start) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn([]byte(key), t.m[key]) {
+			return
+		}
+	}
+}
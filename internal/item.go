@@ -1,7 +1,11 @@
 package internal
 
 type Item struct {
-	FileID int   `json: fileID`
-	Offset int64 `json: offset`
-	Size   int64 `json: size`
+	FileID int   `json:"fileID"`
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+	// Timestamp is the unix time in nanoseconds the entry was written at,
+	// zero if unknown because the item was loaded from a version 1 index or
+	// hint file
+	Timestamp int64 `json:"timestamp"`
 }
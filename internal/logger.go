@@ -0,0 +1,14 @@
+package internal
+
+// Logger receives diagnostic messages such as index loads, datafile rotations
+// and merge progress
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
+
+// DiscardLogger is a Logger dropping all messages
+var DiscardLogger Logger = discardLogger{}
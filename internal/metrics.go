@@ -0,0 +1,24 @@
+package internal
+
+import "time"
+
+// Metrics receives measurements of database operations
+type Metrics interface {
+	// RecordGet is called after a Get found its key or not
+	RecordGet(hit bool, d time.Duration)
+	RecordPut(d time.Duration)
+	RecordDelete(d time.Duration)
+	// RecordMerge is called after a merge with the number of bytes it freed
+	RecordMerge(reclaimed int64)
+}
+
+type discardMetrics struct{}
+
+func (discardMetrics) RecordGet(hit bool, d time.Duration) {}
+func (discardMetrics) RecordPut(d time.Duration)           {}
+func (discardMetrics) RecordDelete(d time.Duration)        {}
+func (discardMetrics) RecordMerge(reclaimed int64)         {}
+
+// DiscardMetrics is a Metrics dropping all measurements, operations don't
+// take them at all when it's used
+var DiscardMetrics Metrics = discardMetrics{}
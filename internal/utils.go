@@ -1,12 +1,14 @@
 package internal
 
 import (
-	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/pkg/errors"
 )
 
 // Exists tell path exists
@@ -15,9 +17,40 @@ func Exists(path string) bool {
 	return err == nil
 }
 
-// GetDatafiles get *.data files from path
-func GetDatafiles(path string) ([]string, error) {
-	fns, err := filepath.Glob(fmt.Sprintf("%s/*.data", path))
+// DefaultDatafileFormat is the default filename format of datafiles
+const DefaultDatafileFormat = "%09d.data"
+
+var errInvalidDatafileFormat = errors.New("error: datafile format must hold one integer verb and end with .data")
+
+// SplitDatafileFormat returns the parts of a datafile filename format before
+// and after its integer verb. The format must hold a single %d verb, optionally
+// with flags and width, contain no glob meta characters or path separators
+// and end with .data.
+func SplitDatafileFormat(format string) (prefix, suffix string, err error) {
+	if !strings.HasSuffix(format, ".data") || strings.ContainsAny(format, `*?[\/`) {
+		return "", "", errInvalidDatafileFormat
+	}
+	start := strings.IndexByte(format, '%')
+	if start < 0 {
+		return "", "", errInvalidDatafileFormat
+	}
+	end := start + 1
+	for end < len(format) && strings.IndexByte("0123456789-+ ", format[end]) >= 0 {
+		end++
+	}
+	if end == len(format) || format[end] != 'd' || strings.IndexByte(format[end+1:], '%') >= 0 {
+		return "", "", errInvalidDatafileFormat
+	}
+	return format[:start], format[end+1:], nil
+}
+
+// GetDatafiles get the datafiles named after format from path
+func GetDatafiles(path, format string) ([]string, error) {
+	prefix, suffix, err := SplitDatafileFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	fns, err := filepath.Glob(filepath.Join(path, prefix+"*"+suffix))
 	if err != nil {
 		return nil, err
 	}
@@ -25,13 +58,16 @@ func GetDatafiles(path string) ([]string, error) {
 	return fns, nil
 }
 
-// ParseIds return int filenames
-func ParseIds(fns []string) ([]int, error) {
-	ids := make([]int, len(fns))
+// ParseIds return the ids of datafiles named after format
+func ParseIds(fns []string, format string) ([]int, error) {
+	prefix, suffix, err := SplitDatafileFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(fns))
 	for _, fn := range fns {
 		base := filepath.Base(fn)
-		ext := filepath.Ext(fn)
-		id, err := strconv.ParseInt(strings.TrimSuffix(base, ext), 10, 64)
+		id, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(base, prefix), suffix), 10, 64)
 		if err != nil {
 			return nil, err
 		}
@@ -40,3 +76,35 @@ func ParseIds(fns []string) ([]int, error) {
 	sort.Ints(ids)
 	return ids, nil
 }
+
+// HintPath return the path of the hint file of the datafile at path
+func HintPath(path string) string {
+	return strings.TrimSuffix(path, ".data") + ".hint"
+}
+
+// BloomPath return the path of the bloom filter of the datafile at path
+func BloomPath(path string) string {
+	return strings.TrimSuffix(path, ".data") + ".bloom"
+}
+
+// CopyFile copies the file at src to dst, creating or truncating dst
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, stat.Mode())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
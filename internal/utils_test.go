@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIds(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		fns    []string
+		want   []int
+	}{
+		{
+			name:   "default format",
+			format: DefaultDatafileFormat,
+			fns:    []string{"/tmp/db/000000010.data", "/tmp/db/000000003.data"},
+			want:   []int{3, 10},
+		},
+		{
+			name:   "custom format",
+			format: "shard-1-%012d.data",
+			fns:    []string{"/tmp/db/shard-1-001000000000.data", "/tmp/db/shard-1-000000000007.data"},
+			want:   []int{7, 1000000000},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids, err := ParseIds(test.fns, test.format)
+			if err != nil {
+				t.Fatalf("parse ids error: %v", err)
+			}
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("parse ids, want: %v, got: %v", test.want, ids)
+			}
+		})
+	}
+
+	if _, err := ParseIds([]string{"/tmp/db/foo.data"}, DefaultDatafileFormat); err == nil {
+		t.Errorf("parse invalid id, want error, got: nil")
+	}
+}
+
+func TestSplitDatafileFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		prefix string
+		suffix string
+		valid  bool
+	}{
+		{format: "%09d.data", prefix: "", suffix: ".data", valid: true},
+		{format: "shard-%d.data", prefix: "shard-", suffix: ".data", valid: true},
+		{format: "%d-%d.data", valid: false},
+		{format: "%09s.data", valid: false},
+		{format: "%09d.log", valid: false},
+		{format: "dir/%09d.data", valid: false},
+		{format: "*%09d.data", valid: false},
+		{format: "data.data", valid: false},
+	}
+	for _, test := range tests {
+		prefix, suffix, err := SplitDatafileFormat(test.format)
+		if (err == nil) != test.valid {
+			t.Errorf("split %q, want valid: %v, got error: %v", test.format, test.valid, err)
+			continue
+		}
+		if prefix != test.prefix || suffix != test.suffix {
+			t.Errorf("split %q, want: %q %q, got: %q %q", test.format, test.prefix, test.suffix, prefix, suffix)
+		}
+	}
+}
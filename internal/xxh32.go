@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// xxh32 is the 32-bit xxHash with a zero seed, see
+// https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+const (
+	xxhPrime1 uint32 = 2654435761
+	xxhPrime2 uint32 = 2246822519
+	xxhPrime3 uint32 = 3266489917
+	xxhPrime4 uint32 = 668265263
+	xxhPrime5 uint32 = 374761393
+
+	// the initial accumulators, xxhPrime1+xxhPrime2 and -xxhPrime1 wrapped
+	// around
+	xxhInit1 uint32 = 606290984
+	xxhInit4 uint32 = 1640531535
+)
+
+func xxhRound(acc, lane uint32) uint32 {
+	return bits.RotateLeft32(acc+lane*xxhPrime2, 13) * xxhPrime1
+}
+
+// xxh32Sum returns the xxh32 hash of b
+func xxh32Sum(b []byte) uint32 {
+	n := len(b)
+	var h uint32
+	if n >= 16 {
+		v1, v2, v3, v4 := xxhInit1, xxhPrime2, uint32(0), xxhInit4
+		for ; len(b) >= 16; b = b[16:] {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint32(b[0:]))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint32(b[4:]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint32(b[8:]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint32(b[12:]))
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = xxhPrime5
+	}
+	return xxhFinish(h+uint32(n), b)
+}
+
+// xxhFinish mixes the last bytes of the input, fewer than 16, into h
+func xxhFinish(h uint32, b []byte) uint32 {
+	for ; len(b) >= 4; b = b[4:] {
+		h += binary.LittleEndian.Uint32(b) * xxhPrime3
+		h = bits.RotateLeft32(h, 17) * xxhPrime4
+	}
+	for _, c := range b {
+		h += uint32(c) * xxhPrime5
+		h = bits.RotateLeft32(h, 11) * xxhPrime1
+	}
+	h ^= h >> 15
+	h *= xxhPrime2
+	h ^= h >> 13
+	h *= xxhPrime3
+	h ^= h >> 16
+	return h
+}
+
+// xxh32 computes xxh32Sum of the bytes written to it, a hash.Hash32
+type xxh32 struct {
+	v1, v2, v3, v4 uint32
+	total          int
+	mem            [16]byte
+	n              int
+}
+
+func newXXH32() *xxh32 {
+	h := &xxh32{}
+	h.Reset()
+	return h
+}
+
+func (h *xxh32) Reset() {
+	h.v1, h.v2, h.v3, h.v4 = xxhInit1, xxhPrime2, 0, xxhInit4
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxh32) Size() int {
+	return 4
+}
+
+func (h *xxh32) BlockSize() int {
+	return 16
+}
+
+func (h *xxh32) Write(b []byte) (int, error) {
+	n := len(b)
+	h.total += n
+	if h.n+len(b) < 16 {
+		h.n += copy(h.mem[h.n:], b)
+		return n, nil
+	}
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], b)
+		h.stripe(h.mem[:])
+		b = b[c:]
+		h.n = 0
+	}
+	for ; len(b) >= 16; b = b[16:] {
+		h.stripe(b)
+	}
+	h.n = copy(h.mem[:], b)
+	return n, nil
+}
+
+func (h *xxh32) stripe(b []byte) {
+	h.v1 = xxhRound(h.v1, binary.LittleEndian.Uint32(b[0:]))
+	h.v2 = xxhRound(h.v2, binary.LittleEndian.Uint32(b[4:]))
+	h.v3 = xxhRound(h.v3, binary.LittleEndian.Uint32(b[8:]))
+	h.v4 = xxhRound(h.v4, binary.LittleEndian.Uint32(b[12:]))
+}
+
+func (h *xxh32) Sum32() uint32 {
+	var sum uint32
+	if h.total >= 16 {
+		sum = bits.RotateLeft32(h.v1, 1) + bits.RotateLeft32(h.v2, 7) + bits.RotateLeft32(h.v3, 12) + bits.RotateLeft32(h.v4, 18)
+	} else {
+		sum = xxhPrime5
+	}
+	return xxhFinish(sum+uint32(h.total), h.mem[:h.n])
+}
+
+func (h *xxh32) Sum(b []byte) []byte {
+	s := h.Sum32()
+	return append(b, byte(s>>24), byte(s>>16), byte(s>>8), byte(s))
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// xxh64 is the 64-bit xxHash with a zero seed, see
+// https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+const (
+	xxh64Prime1 uint64 = 11400714785074694791
+	xxh64Prime2 uint64 = 14029467366897019727
+	xxh64Prime3 uint64 = 1609587929392839161
+	xxh64Prime4 uint64 = 9650029242287828579
+	xxh64Prime5 uint64 = 2870177450012600261
+
+	// the initial accumulators, xxh64Prime1+xxh64Prime2 and -xxh64Prime1
+	// wrapped around
+	xxh64Init1 uint64 = 6983438078262162902
+	xxh64Init4 uint64 = 7046029288634856825
+)
+
+func xxh64Round(acc, lane uint64) uint64 {
+	return bits.RotateLeft64(acc+lane*xxh64Prime2, 31) * xxh64Prime1
+}
+
+func xxh64Merge(h, v uint64) uint64 {
+	return (h^xxh64Round(0, v))*xxh64Prime1 + xxh64Prime4
+}
+
+// xxh64Converge folds the four accumulators into one
+func xxh64Converge(v1, v2, v3, v4 uint64) uint64 {
+	h := bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+	h = xxh64Merge(h, v1)
+	h = xxh64Merge(h, v2)
+	h = xxh64Merge(h, v3)
+	return xxh64Merge(h, v4)
+}
+
+// xxh64Sum returns the xxh64 hash of b
+func xxh64Sum(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1, v2, v3, v4 := xxh64Init1, xxh64Prime2, uint64(0), xxh64Init4
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxh64Round(v1, binary.LittleEndian.Uint64(b[0:]))
+			v2 = xxh64Round(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = xxh64Round(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = xxh64Round(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = xxh64Converge(v1, v2, v3, v4)
+	} else {
+		h = xxh64Prime5
+	}
+	return xxh64Finish(h+uint64(n), b)
+}
+
+// xxh64Finish mixes the last bytes of the input, fewer than 32, into h
+func xxh64Finish(h uint64, b []byte) uint64 {
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxh64Round(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxh64Prime1 + xxh64Prime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxh64Prime1
+		h = bits.RotateLeft64(h, 23)*xxh64Prime2 + xxh64Prime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxh64Prime5
+		h = bits.RotateLeft64(h, 11) * xxh64Prime1
+	}
+	h ^= h >> 33
+	h *= xxh64Prime2
+	h ^= h >> 29
+	h *= xxh64Prime3
+	h ^= h >> 32
+	return h
+}
+
+// xxh64 computes xxh64Sum of the bytes written to it, a hash.Hash64
+type xxh64 struct {
+	v1, v2, v3, v4 uint64
+	total          int
+	mem            [32]byte
+	n              int
+}
+
+func newXXH64() *xxh64 {
+	h := &xxh64{}
+	h.Reset()
+	return h
+}
+
+func (h *xxh64) Reset() {
+	h.v1, h.v2, h.v3, h.v4 = xxh64Init1, xxh64Prime2, 0, xxh64Init4
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxh64) Size() int {
+	return 8
+}
+
+func (h *xxh64) BlockSize() int {
+	return 32
+}
+
+func (h *xxh64) Write(b []byte) (int, error) {
+	n := len(b)
+	h.total += n
+	if h.n+len(b) < 32 {
+		h.n += copy(h.mem[h.n:], b)
+		return n, nil
+	}
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], b)
+		h.stripe(h.mem[:])
+		b = b[c:]
+		h.n = 0
+	}
+	for ; len(b) >= 32; b = b[32:] {
+		h.stripe(b)
+	}
+	h.n = copy(h.mem[:], b)
+	return n, nil
+}
+
+func (h *xxh64) stripe(b []byte) {
+	h.v1 = xxh64Round(h.v1, binary.LittleEndian.Uint64(b[0:]))
+	h.v2 = xxh64Round(h.v2, binary.LittleEndian.Uint64(b[8:]))
+	h.v3 = xxh64Round(h.v3, binary.LittleEndian.Uint64(b[16:]))
+	h.v4 = xxh64Round(h.v4, binary.LittleEndian.Uint64(b[24:]))
+}
+
+func (h *xxh64) Sum64() uint64 {
+	var sum uint64
+	if h.total >= 32 {
+		sum = xxh64Converge(h.v1, h.v2, h.v3, h.v4)
+	} else {
+		sum = xxh64Prime5
+	}
+	return xxh64Finish(sum+uint64(h.total), h.mem[:h.n])
+}
+
+func (h *xxh64) Sum(b []byte) []byte {
+	s := h.Sum64()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], s)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,169 @@
+package bitcask
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// largeMagic starts the manifest of a value stored with PutLarge, which is
+// followed by the number of chunks and the total size
+var largeMagic = []byte("bitcask-large\x00")
+
+const largeManifestSize = 14 + 4 + 8
+
+var errCorruptManifest = errors.New("error: corrupt large value manifest")
+
+// PutLarge stores a value of any size by splitting it into chunks no larger
+// than the chunk size, stored under the keys key#0, key#1 and so on, and a
+// manifest stored under key. The chunks of a previous large value of key that
+// aren't overwritten are deleted. Read the value back with GetLarge.
+func (b *Bitcask) PutLarge(key, value []byte) error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	chunkSize := b.cfg.MaxValueSize
+	if b.cfg.ChunkSize > 0 && b.cfg.ChunkSize < chunkSize {
+		chunkSize = b.cfg.ChunkSize
+	}
+	chunks := (uint64(len(value)) + chunkSize - 1) / chunkSize
+	if uint32(len(chunkKey(key, int(chunks)))) > b.cfg.MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	old, err := b.manifest(key)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(chunks); i++ {
+		start := uint64(i) * chunkSize
+		end := start + chunkSize
+		if end > uint64(len(value)) {
+			end = uint64(len(value))
+		}
+		if err := b.putItem(chunkKey(key, i), value[start:end]); err != nil {
+			return err
+		}
+	}
+	manifest := make([]byte, largeManifestSize)
+	copy(manifest, largeMagic)
+	binary.BigEndian.PutUint32(manifest[len(largeMagic):], uint32(chunks))
+	binary.BigEndian.PutUint64(manifest[len(largeMagic)+4:], uint64(len(value)))
+	if err := b.putItem(key, manifest); err != nil {
+		return err
+	}
+	for i := int(chunks); i < old; i++ {
+		if _, err := b.putTombstone(chunkKey(key, i)); err != nil {
+			return err
+		}
+		b.remove(chunkKey(key, i))
+	}
+	return b.maybeSync()
+}
+
+// GetLarge retrieves a value stored with PutLarge. A value stored with Put is
+// returned as is.
+func (b *Bitcask) GetLarge(key []byte) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	manifest, found, err := b.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	if !bytes.HasPrefix(manifest, largeMagic) {
+		return manifest, nil
+	}
+	chunks, size, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, 0, size)
+	for i := 0; i < chunks; i++ {
+		chunk, found, err := b.lookup(chunkKey(key, i))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, errors.Wrapf(errCorruptManifest, "missing chunk %d", i)
+		}
+		value = append(value, chunk...)
+	}
+	if uint64(len(value)) != size {
+		return nil, errors.Wrapf(errCorruptManifest, "size %d, chunks hold %d", size, len(value))
+	}
+	return value, nil
+}
+
+// DeleteLarge deletes a value stored with PutLarge along with its chunks. A
+// value stored with Put is deleted like Delete does.
+func (b *Bitcask) DeleteLarge(key []byte) error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	chunks, err := b.manifest(key)
+	if err != nil {
+		return err
+	}
+	keys := [][]byte{key}
+	for i := 0; i < chunks; i++ {
+		keys = append(keys, chunkKey(key, i))
+	}
+	_, err = b.deleteKeys(context.Background(), keys)
+	return err
+}
+
+// manifest returns the number of chunks of the large value of key, zero if
+// key doesn't hold one. It must be called with b.mu held.
+func (b *Bitcask) manifest(key []byte) (int, error) {
+	value, found, err := b.lookup(key)
+	if err != nil || !found || !bytes.HasPrefix(value, largeMagic) {
+		return 0, err
+	}
+	chunks, _, err := parseManifest(value)
+	return chunks, err
+}
+
+// putItem writes value for key and updates the index without syncing. It must
+// be called with b.mu held for writing.
+func (b *Bitcask) putItem(key, value []byte) error {
+	item, err := b.put(key, value)
+	if err != nil {
+		return err
+	}
+	b.insert(key, item)
+	return nil
+}
+
+func parseManifest(manifest []byte) (chunks int, size uint64, err error) {
+	if len(manifest) != largeManifestSize {
+		return 0, 0, errCorruptManifest
+	}
+	chunks = int(binary.BigEndian.Uint32(manifest[len(largeMagic):]))
+	size = binary.BigEndian.Uint64(manifest[len(largeMagic)+4:])
+	return chunks, size, nil
+}
+
+func chunkKey(key []byte, i int) []byte {
+	return append(append(append([]byte{}, key...), '#'), strconv.Itoa(i)...)
+}
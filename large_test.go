@@ -0,0 +1,71 @@
+package bitcask
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPutLarge(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxValueSize(64), WithChunkSize(16))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("0123456789"), 25)
+	if err := db.PutLarge([]byte("blob"), value); err != nil {
+		t.Fatalf("put large error: %v", err)
+	}
+	got, err := db.GetLarge([]byte("blob"))
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("get large, want: %d bytes, got: %d bytes (%v)", len(value), len(got), err)
+	}
+	if db.Len() != 1+16 {
+		t.Errorf("len, want: %d, got: %d", 1+16, db.Len())
+	}
+
+	// a smaller value drops the chunks it no longer needs
+	value = []byte("a smaller value of three chunks")
+	if err := db.PutLarge([]byte("blob"), value); err != nil {
+		t.Fatalf("overwrite error: %v", err)
+	}
+	got, err = db.GetLarge([]byte("blob"))
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("get large after overwrite, want: %s, got: %s (%v)", value, got, err)
+	}
+	if db.Len() != 1+2 {
+		t.Errorf("len after overwrite, want: %d, got: %d", 1+2, db.Len())
+	}
+	if db.Has([]byte("blob#2")) {
+		t.Errorf("has dropped chunk, want: false, got: true")
+	}
+
+	if err := db.DeleteLarge([]byte("blob")); err != nil {
+		t.Fatalf("delete large error: %v", err)
+	}
+	if db.Len() != 0 {
+		t.Errorf("len after delete, want: %d, got: %d", 0, db.Len())
+	}
+	if _, err := db.GetLarge([]byte("blob")); err != ErrKeyNotFound {
+		t.Errorf("get large after delete, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestGetLargeSmallValue(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	got, err := db.GetLarge([]byte("key"))
+	if err != nil || string(got) != "value" {
+		t.Errorf("get large, want: %s, got: %s (%v)", "value", got, err)
+	}
+	if err := db.PutLarge(bytes.Repeat([]byte("k"), 64), []byte("value")); err != ErrKeyTooLarge {
+		t.Errorf("put large with a key leaving no room for chunk keys, want: %v, got: %v", ErrKeyTooLarge, err)
+	}
+}
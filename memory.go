@@ -0,0 +1,43 @@
+package bitcask
+
+import (
+	"time"
+
+	"jay.com/bitcask/internal/data"
+	"jay.com/bitcask/internal/index"
+)
+
+// OpenInMemory opens an empty database held in memory rather than in files,
+// e.g. for tests that shouldn't touch the disk. Nothing is persisted, its
+// entries are lost on Close. Merge, Backup and Relocate return ErrInMemory, as
+// does opening it read-only.
+func OpenInMemory(options ...Option) (*Bitcask, error) {
+	cfg := newDefaultConfig()
+	if err := applyOptions(cfg, options); err != nil {
+		return nil, err
+	}
+	if cfg.Readonly {
+		return nil, ErrInMemory
+	}
+	curr, err := data.NewMemDatafile(0, cfg)
+	if err != nil {
+		return nil, err
+	}
+	bitcask := &Bitcask{
+		options:   options,
+		cfg:       cfg,
+		curr:      curr,
+		datafiles: make(map[int]data.DataFile),
+		blooms:    make(map[int]*index.Bloom),
+		t:         newTree(cfg),
+		clock:     time.Now,
+		done:      make(chan struct{}),
+		txns:      make(map[*Txn]struct{}),
+		inMemory:  true,
+	}
+	if cfg.SyncInterval > 0 {
+		bitcask.wg.Add(1)
+		go bitcask.syncEvery(cfg.SyncInterval)
+	}
+	return bitcask, nil
+}
@@ -0,0 +1,88 @@
+package bitcask
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpenInMemory(t *testing.T) {
+	// nothing may be written, not even relative to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd error: %v", err)
+	}
+	dir := tempDir(t)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("chdir error: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	db, err := OpenInMemory(WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Delete([]byte("key-03")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.Put([]byte("key-05"), []byte("value-new")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	// two 50 byte entries per datafile, see TestRotationBoundary
+	if len(db.datafiles) < 5 {
+		t.Errorf("datafiles, want: at least %d, got: %d", 5, len(db.datafiles))
+	}
+	if db.Len() != 9 {
+		t.Errorf("len, want: %d, got: %d", 9, db.Len())
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		want := fmt.Sprintf("value-%05d", i)
+		switch i {
+		case 3:
+			if _, err := db.Get([]byte(key)); err != ErrKeyNotFound {
+				t.Errorf("get deleted key, want: %v, got: %v", ErrKeyNotFound, err)
+			}
+			continue
+		case 5:
+			want = "value-new"
+		}
+		if got, err := db.Get([]byte(key)); err != nil || string(got) != want {
+			t.Errorf("get %s, want: %s, got: %s (%v)", key, want, got, err)
+		}
+	}
+	r, err := db.GetReader([]byte("key-07"))
+	if err != nil {
+		t.Fatalf("get reader error: %v", err)
+	}
+	if got, _ := ioutil.ReadAll(r); string(got) != "value-00007" {
+		t.Errorf("get reader, want: %s, got: %s", "value-00007", got)
+	}
+	r.Close()
+	if err := db.Merge(); err != ErrInMemory {
+		t.Errorf("merge, want: %v, got: %v", ErrInMemory, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	if _, err := db.Get([]byte("key-00")); err != ErrClosed {
+		t.Errorf("get after close, want: %v, got: %v", ErrClosed, err)
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir error: %v", err)
+	}
+	if len(fis) != 0 {
+		t.Errorf("files written, want: none, got: %d", len(fis))
+	}
+}
@@ -0,0 +1,218 @@
+package bitcask
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/data"
+	"jay.com/bitcask/internal/data/codec"
+)
+
+// mergeDir is the directory under the database path where a merge writes its
+// datafiles before moving them in, unless WithTempDir is given. A leftover one
+// is from a merge that didn't finish and is removed on open.
+const mergeDir = "merge"
+
+// checkTempDir checks that the datafiles a merge writes in dir can be moved to
+// path, i.e. that both are on the same filesystem, by moving a file across.
+// Merge only finds out once it has already rotated the current datafile.
+func checkTempDir(dir, path string) error {
+	f, err := ioutil.TempFile(dir, "bitcask-probe-")
+	if err != nil {
+		return errors.Wrapf(errInvalidTempDir, "%v", err)
+	}
+	f.Close()
+	probe := filepath.Join(path, filepath.Base(f.Name()))
+	if err := os.Rename(f.Name(), probe); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(errInvalidTempDir, "%v", err)
+	}
+	return os.Remove(probe)
+}
+
+// mergeOutput is the datafiles written by a merge and the new items of the
+// keys rewritten to them
+type mergeOutput struct {
+	ids     []int
+	keys    [][]byte
+	items   []internal.Item
+	expired [][]byte
+	size    int64
+}
+
+// merge rewrites the live entries of the datafiles in merged and removes
+// them. merged must hold the oldest datafiles, so that all older entries of
+// the keys it drops go too. It must be called with b.mu held for writing.
+//
+// The live entries are written to new datafiles in mergeDir, numbered after
+// the current datafile, which only then are moved in. Until they are the
+// database is unchanged, afterwards they at worst duplicate live entries.
+func (b *Bitcask) merge(ctx context.Context, merged map[int]bool) error {
+	if b.inMemory {
+		return ErrInMemory
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	dir := filepath.Join(b.path, mergeDir)
+	if b.cfg.TempDir != "" {
+		var err error
+		if dir, err = ioutil.TempDir(b.cfg.TempDir, "bitcask-merge-"); err != nil {
+			return err
+		}
+	}
+	out, err := b.buildMerge(ctx, dir, merged)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	size := b.size
+	if err := b.swapMerge(dir, merged, out); err != nil {
+		return err
+	}
+	b.cfg.Metrics.RecordMerge(size - b.size)
+	return nil
+}
+
+// buildMerge writes the live entries of the datafiles in merged to new
+// datafiles in dir, leaving the database unchanged.
+func (b *Bitcask) buildMerge(ctx context.Context, dir string, merged map[int]bool) (*mergeOutput, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, b.cfg.DirModeOr(0755)); err != nil {
+		return nil, err
+	}
+
+	var (
+		keys  [][]byte
+		items []internal.Item
+	)
+	b.t.ForEach(func(key []byte, item internal.Item) bool {
+		if merged[item.FileID] {
+			keys = append(keys, key)
+			items = append(items, item)
+		}
+		return true
+	})
+	b.cfg.Logger.Printf("merging %d datafiles, rewriting %d keys", len(merged), len(keys))
+
+	out := &mergeOutput{}
+	var df data.DataFile
+	defer func() {
+		if df != nil {
+			df.Close()
+		}
+	}()
+	id := b.curr.FileID()
+	encrypted := b.cfg.EncryptionKey != nil
+	for i, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e, err := b.read(items[i])
+		if err != nil {
+			return nil, err
+		}
+		// the key as written, key is already transformed by the index
+		key = append([]byte(nil), e.Key...)
+		// expired entries are dropped, every datafile holding an older entry
+		// of the key is removed too so no tombstone is needed
+		if b.expired(e) {
+			out.expired = append(out.expired, key)
+			continue
+		}
+		if df == nil || df.Size() > 0 && df.Size()+codec.EncodedSize(e, encrypted, b.cfg.ChecksumSize()) > int64(b.cfg.MaxDatafileSize) {
+			if df != nil {
+				err := df.Close()
+				df = nil
+				if err != nil {
+					return nil, err
+				}
+			}
+			id++
+			if df, err = data.NewDatafile(dir, id, false, b.cfg); err != nil {
+				return nil, err
+			}
+			out.ids = append(out.ids, id)
+		}
+		offset, n, err := df.Write(e)
+		if err != nil {
+			return nil, err
+		}
+		out.keys = append(out.keys, key)
+		out.items = append(out.items, internal.Item{
+			FileID:    id,
+			Offset:    offset,
+			Size:      n,
+			Timestamp: e.Timestamp,
+		})
+		out.size += n
+	}
+	if df != nil {
+		err := df.Close()
+		df = nil
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// swapMerge moves the datafiles built by buildMerge in and removes the merged
+// ones. The current datafile moves past the new datafiles first, so that
+// entries written later stay the newest.
+func (b *Bitcask) swapMerge(dir string, merged map[int]bool, out *mergeOutput) error {
+	if len(out.ids) > 0 {
+		if err := b.rotateTo(out.ids[len(out.ids)-1] + 1); err != nil {
+			return err
+		}
+	}
+	for _, id := range out.ids {
+		name := fmt.Sprintf(b.cfg.DatafileFormat, id)
+		if err := os.Rename(filepath.Join(dir, name), filepath.Join(b.path, name)); err != nil {
+			return err
+		}
+		df, err := b.openDatafile(id, true)
+		if err != nil {
+			return err
+		}
+		b.datafiles[id] = df
+		if err := b.writeHint(id); err != nil {
+			return err
+		}
+	}
+	for i, key := range out.keys {
+		b.t.Insert(key, out.items[i])
+	}
+	for _, key := range out.expired {
+		b.remove(key)
+	}
+	b.size += out.size
+
+	for id := range merged {
+		df := b.datafiles[id]
+		if err := df.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(df.Name()); err != nil {
+			return err
+		}
+		if err := removeHint(df.Name()); err != nil {
+			return err
+		}
+		delete(b.datafiles, id)
+		delete(b.blooms, id)
+		b.size -= df.Size()
+	}
+	b.cfg.Logger.Printf("merged %d datafiles", len(merged))
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return b.saveIndex(filepath.Join(b.path, "index"))
+}
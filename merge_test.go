@@ -0,0 +1,113 @@
+package bitcask
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeCrash(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxDatafileSize(256))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("key-%d", i%20)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+
+	// build the merged datafiles but crash before moving them in
+	db.mu.Lock()
+	merged := make(map[int]bool)
+	for id := range db.datafiles {
+		merged[id] = true
+	}
+	dir := filepath.Join(path, mergeDir)
+	if _, err := db.buildMerge(context.Background(), dir, merged); err != nil {
+		t.Fatalf("build merge error: %v", err)
+	}
+	db.mu.Unlock()
+	if fns, _ := filepath.Glob(filepath.Join(dir, "*.data")); len(fns) == 0 {
+		t.Fatalf("merge datafiles, want some, got none")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path, WithMaxDatafileSize(256))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("merge dir after reopen, want removed, got: %v", err)
+	}
+	check := func() {
+		t.Helper()
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("key-%d", i))
+			want := []byte(fmt.Sprintf("value-%d", 80+i))
+			if got, err := db.Get(key); err != nil || !bytes.Equal(got, want) {
+				t.Errorf("get %s, want: %s, got: %s (%v)", key, want, got, err)
+			}
+		}
+	}
+	check()
+
+	// a merge after the crash completes
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	check()
+	if err := db.Put([]byte("key-0"), []byte("latest")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path, WithMaxDatafileSize(256))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if got, _ := db.Get([]byte("key-0")); !bytes.Equal(got, []byte("latest")) {
+		t.Errorf("get key-0 written after merge, want: latest, got: %s", got)
+	}
+}
+
+func TestMergeTempDir(t *testing.T) {
+	tmp := tempDir(t)
+	db, err := Open(tempDir(t), WithMaxDatafileSize(256), WithTempDir(tmp))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("key-%d", i%20)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	if fns, _ := filepath.Glob(filepath.Join(tmp, "*")); len(fns) != 0 {
+		t.Errorf("temp dir after merge, want empty, got: %q", fns)
+	}
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		want := []byte(fmt.Sprintf("value-%d", 80+i))
+		if got, err := db.Get(key); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("get %s, want: %s, got: %s (%v)", key, want, got, err)
+		}
+	}
+}
+
+func TestMergeTempDirInvalid(t *testing.T) {
+	tmp := filepath.Join(tempDir(t), "missing")
+	if _, err := Open(tempDir(t), WithTempDir(tmp)); !errors.Is(err, errInvalidTempDir) {
+		t.Errorf("open with missing temp dir, want: %v, got: %v", errInvalidTempDir, err)
+	}
+}
@@ -0,0 +1,89 @@
+package bitcask
+
+import (
+	"bytes"
+	"time"
+)
+
+// NamespaceSeparator separates the name of a namespace from the keys stored in
+// it, namespace names can't contain it
+const NamespaceSeparator = 0
+
+// Namespace is a handle on the keys of a database stored under the name of the
+// namespace followed by NamespaceSeparator, so that components sharing a
+// database don't collide. Keys are given and returned without the prefix.
+// Keys of the database itself shouldn't start with a namespace's prefix.
+type Namespace struct {
+	db     *Bitcask
+	prefix []byte
+}
+
+// Namespace returns the namespace ns of the database. It fails with
+// ErrInvalidNamespace if ns is empty or contains NamespaceSeparator, so that no
+// namespace holds the keys of another.
+func (b *Bitcask) Namespace(ns []byte) (*Namespace, error) {
+	if len(ns) == 0 || bytes.IndexByte(ns, NamespaceSeparator) >= 0 {
+		return nil, ErrInvalidNamespace
+	}
+	prefix := make([]byte, 0, len(ns)+1)
+	prefix = append(append(prefix, ns...), NamespaceSeparator)
+	return &Namespace{db: b, prefix: prefix}, nil
+}
+
+// key returns the key of the database storing key of the namespace
+func (n *Namespace) key(key []byte) []byte {
+	k := make([]byte, 0, len(n.prefix)+len(key))
+	return append(append(k, n.prefix...), key...)
+}
+
+// Put stores key and value in the namespace, see Bitcask.Put
+func (n *Namespace) Put(key, value []byte) error {
+	return n.db.Put(n.key(key), value)
+}
+
+// PutWithTTL stores key and value in the namespace with a ttl, see
+// Bitcask.PutWithTTL
+func (n *Namespace) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	return n.db.PutWithTTL(n.key(key), value, ttl)
+}
+
+// Get returns the value of key in the namespace, see Bitcask.Get
+func (n *Namespace) Get(key []byte) ([]byte, error) {
+	return n.db.Get(n.key(key))
+}
+
+// Has returns true if key exists in the namespace, see Bitcask.Has
+func (n *Namespace) Has(key []byte) bool {
+	return n.db.Has(n.key(key))
+}
+
+// Delete deletes key from the namespace, see Bitcask.Delete
+func (n *Namespace) Delete(key []byte) error {
+	return n.db.Delete(n.key(key))
+}
+
+// DeleteAll deletes every key of the namespace and returns the number of keys
+// deleted, see Bitcask.DeletePrefix
+func (n *Namespace) DeleteAll() (int, error) {
+	return n.db.DeletePrefix(n.prefix)
+}
+
+// Scan calls fn for every key of the namespace with the given prefix, see
+// Bitcask.Scan
+func (n *Namespace) Scan(prefix []byte, fn func(key []byte) error) error {
+	return n.db.Scan(n.key(prefix), func(key []byte) error {
+		return fn(key[len(n.prefix):])
+	})
+}
+
+// Fold calls fn with every key of the namespace and its value, see
+// Bitcask.Fold
+func (n *Namespace) Fold(fn func(key, value []byte) error) error {
+	// the keys of the namespace sort before the name followed by the byte
+	// after the separator
+	end := n.key(nil)
+	end[len(end)-1]++
+	return n.db.Range(n.prefix, end, func(key, value []byte) error {
+		return fn(key[len(n.prefix):], value)
+	})
+}
@@ -0,0 +1,100 @@
+package bitcask
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	// "users" and "user" must not see each other's keys either
+	namespaces := make(map[string]*Namespace)
+	for _, ns := range []string{"users", "orders", "user"} {
+		if namespaces[ns], err = db.Namespace([]byte(ns)); err != nil {
+			t.Fatalf("namespace %s error: %v", ns, err)
+		}
+	}
+	users, orders, user := namespaces["users"], namespaces["orders"], namespaces["user"]
+
+	for ns, n := range namespaces {
+		for _, key := range []string{"1", "2", "s1"} {
+			if err := n.Put([]byte(key), []byte(ns+"-"+key)); err != nil {
+				t.Fatalf("put error: %v", err)
+			}
+		}
+	}
+	if err := users.Delete([]byte("2")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+
+	if got, err := users.Get([]byte("1")); err != nil || string(got) != "users-1" {
+		t.Errorf("users get, want: users-1, got: %s (%v)", got, err)
+	}
+	if got, err := orders.Get([]byte("1")); err != nil || string(got) != "orders-1" {
+		t.Errorf("orders get, want: orders-1, got: %s (%v)", got, err)
+	}
+	if _, err := users.Get([]byte("2")); err != ErrKeyNotFound {
+		t.Errorf("users get deleted key, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	if !orders.Has([]byte("2")) || !user.Has([]byte("2")) {
+		t.Errorf("has key deleted in another namespace, want: true, got: false")
+	}
+
+	tests := []struct {
+		name string
+		n    *Namespace
+		want string
+	}{
+		{name: "users", n: users, want: "[1=users-1 s1=users-s1]"},
+		{name: "orders", n: orders, want: "[1=orders-1 2=orders-2 s1=orders-s1]"},
+		{name: "user", n: user, want: "[1=user-1 2=user-2 s1=user-s1]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var folded []string
+			if err := test.n.Fold(func(key, value []byte) error {
+				folded = append(folded, fmt.Sprintf("%s=%s", key, value))
+				return nil
+			}); err != nil {
+				t.Fatalf("fold error: %v", err)
+			}
+			if fmt.Sprint(folded) != test.want {
+				t.Errorf("fold, want: %s, got: %v", test.want, folded)
+			}
+			var scanned []string
+			if err := test.n.Scan([]byte("s"), func(key []byte) error {
+				scanned = append(scanned, string(key))
+				return nil
+			}); err != nil {
+				t.Fatalf("scan error: %v", err)
+			}
+			if fmt.Sprint(scanned) != "[s1]" {
+				t.Errorf("scan, want: [s1], got: %v", scanned)
+			}
+		})
+	}
+
+	if n, err := orders.DeleteAll(); err != nil || n != 3 {
+		t.Errorf("delete all, want: 3 keys, got: %d (%v)", n, err)
+	}
+	if db.Len() != 5 {
+		t.Errorf("len after deleting a namespace, want: 5, got: %d", db.Len())
+	}
+}
+
+func TestInvalidNamespace(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for _, ns := range []string{"", "a\x00b"} {
+		if n, err := db.Namespace([]byte(ns)); err != ErrInvalidNamespace || n != nil {
+			t.Errorf("namespace %q, want: %v, got: %v", ns, ErrInvalidNamespace, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//go:build !windows
+// +build !windows
+
+package bitcask
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenTimeout(t *testing.T) {
+	path := tempDir(t)
+	// opening a fifo for reading blocks until a writer opens it, standing in
+	// for a hung filesystem
+	fifo := filepath.Join(path, "000000000.data")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "index"), nil, 0600); err != nil {
+		t.Fatalf("write index error: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := Open(path, WithReadonly(true), WithOpenTimeout(50*time.Millisecond)); err != ErrOpenTimeout {
+		t.Fatalf("open, want: %v, got: %v", ErrOpenTimeout, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("open duration, want: about 50ms, got: %s", d)
+	}
+
+	// unblock the load, the datafile it opened is closed once it completes,
+	// which breaks the pipe
+	w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open fifo error: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := w.Write([]byte{0}); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			w.Close()
+			t.Fatalf("datafile still open after the load completed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Close()
+	if n := openDatafiles(t, path); n != 0 {
+		t.Errorf("open datafiles, want: 0, got: %d", n)
+	}
+}
@@ -1,6 +1,15 @@
 package bitcask
 
-import "jay.com/bitcask/internal/config"
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/config"
+	"jay.com/bitcask/internal/data/codec"
+	"jay.com/bitcask/internal/index"
+)
 
 var (
 	// DefaultMaxDatafileSize is the default maximum datafile size in bytes
@@ -14,14 +23,115 @@ var (
 
 	// DefaultSync is the default file synchronization action
 	DefaultSync = false
+
+	// DefaultDatafileFormat is the default filename format of datafiles
+	DefaultDatafileFormat = internal.DefaultDatafileFormat
+
+	// DefaultAutoMergeThreshold is the default reclaimable ratio triggering
+	// an automatic merge, zero disables automatic merging
+	DefaultAutoMergeThreshold = float64(0)
+)
+
+// autoMergeInterval is how often the reclaimable ratio is checked when
+// automatic merging is enabled
+var autoMergeInterval = time.Minute
+
+const (
+	// ChecksumValueOnly computes entry checksums over the value only
+	ChecksumValueOnly = internal.ChecksumValueOnly
+
+	// ChecksumKeyAndValue computes entry checksums over the key and value
+	ChecksumKeyAndValue = internal.ChecksumKeyAndValue
+
+	// ChecksumCRC32 computes entry checksums with CRC-32 and the IEEE
+	// polynomial, the default
+	ChecksumCRC32 = internal.ChecksumCRC32
+
+	// ChecksumCRC32C computes entry checksums with CRC-32 and the Castagnoli
+	// polynomial, hardware accelerated on most CPUs
+	ChecksumCRC32C = internal.ChecksumCRC32C
+
+	// ChecksumXXHash computes entry checksums with the 32-bit xxHash
+	ChecksumXXHash = internal.ChecksumXXHash
+
+	// ChecksumCRC64 computes 64-bit entry checksums with CRC-64 and the ECMA
+	// polynomial
+	ChecksumCRC64 = internal.ChecksumCRC64
+
+	// ChecksumXXHash64 computes 64-bit entry checksums with the 64-bit xxHash
+	ChecksumXXHash64 = internal.ChecksumXXHash64
+
+	// CompressionNone stores values as is
+	CompressionNone = codec.CompressionNone
+
+	// CompressionGzip stores values gzip compressed when that makes them smaller
+	CompressionGzip = codec.CompressionGzip
+
+	// IndexART keeps the in-memory index in an adaptive radix tree, the default
+	IndexART = index.ART
+
+	// IndexHashmap keeps the in-memory index in a hash map, making lookups
+	// faster but ordered iteration slower as the keys are sorted each time
+	IndexHashmap = index.Hashmap
+)
+
+var (
+	errInvalidMaxDatafileSize = errors.New("error: max datafile size must be positive")
+	errInvalidMaxKeySize      = errors.New("error: max key size must be positive")
+	errInvalidMaxValueSize    = errors.New("error: max value size must be positive")
+	errInvalidChunkSize       = errors.New("error: chunk size must be positive")
+	errInvalidMaxOpenFiles    = errors.New("error: max open files must be positive")
+	errInvalidWriteBuffer     = errors.New("error: write buffer size must be positive")
+	errInvalidOpenTimeout     = errors.New("error: open timeout must be positive")
+	errInvalidSyncInterval    = errors.New("error: sync interval must be positive")
+
+	errInvalidChecksumMode = errors.New("error: invalid checksum mode")
+	errInvalidChecksum     = errors.New("error: invalid checksum algorithm")
+	errInvalidCompression  = errors.New("error: invalid compression")
+	errInvalidIndex        = errors.New("error: invalid index")
+	errInvalidFileMode     = errors.New("error: file mode must only hold permission bits")
+
+	errInvalidEncryptionKey = errors.New("error: encryption key must be 16, 24 or 32 bytes")
+
+	errInvalidAutoMergeThreshold = errors.New("error: auto merge threshold must be between 0 and 1")
+
+	errInvalidTempDir = errors.New("error: temp dir must be on the same filesystem as the database")
 )
 
+// Logger receives diagnostic messages such as index loads, datafile rotations
+// and merge progress, *log.Logger satisfies it
+type Logger = internal.Logger
+
+// Metrics receives measurements of Get, Put, Delete and merges
+type Metrics = internal.Metrics
+
+// Action is what to do with a corrupt entry, see WithOnCorruption
+type Action = internal.Action
+
+const (
+	// ActionAbort fails the operation finding the corrupt entry
+	ActionAbort = internal.ActionAbort
+
+	// ActionSkip ignores the corrupt entry
+	ActionSkip = internal.ActionSkip
+
+	// ActionDelete deletes the key of the corrupt entry
+	ActionDelete = internal.ActionDelete
+)
+
+// Config is the configuration of a database, see DefaultConfig
+type Config = config.Config
+
 // Option is a function that takes a config struct and modifies it
 type Option func(*config.Config) error
 
-// WithMaxDatafileSize sets the maximum datafile size option
+// WithMaxDatafileSize sets the maximum datafile size option. Like all
+// size limits it is persisted with the database and kept on reopen.
 func WithMaxDatafileSize(size int) Option {
 	return func(cfg *config.Config) error {
+		if size <= 0 {
+			return errInvalidMaxDatafileSize
+		}
 		cfg.MaxDatafileSize = size
 		return nil
 	}
@@ -30,6 +140,9 @@ func WithMaxDatafileSize(size int) Option {
 // WithMaxKeySize sets the maximum key size option
 func WithMaxKeySize(size uint32) Option {
 	return func(cfg *config.Config) error {
+		if size == 0 {
+			return errInvalidMaxKeySize
+		}
 		cfg.MaxKeySize = size
 		return nil
 	}
@@ -38,13 +151,18 @@ func WithMaxKeySize(size uint32) Option {
 // WithMaxValueSize sets the maximum value size option
 func WithMaxValueSize(size uint64) Option {
 	return func(cfg *config.Config) error {
+		if size == 0 {
+			return errInvalidMaxValueSize
+		}
 		cfg.MaxValueSize = size
 		return nil
 	}
 }
 
 // WithSync causes Sync() to be called on every key/value written,
-// increasing durability and safety at the expense of performance
+// increasing durability and safety at the expense of performance: every
+// Put, Delete, DeleteAll and Batch commit waits for an fsync of the current
+// datafile, which typically limits write throughput to the disk's fsync rate
 func WithSync(sync bool) Option {
 	return func(cfg *config.Config) error {
 		cfg.Sync = sync
@@ -52,11 +170,343 @@ func WithSync(sync bool) Option {
 	}
 }
 
-func newDefaultConfig() *config.Config {
-	return &config.Config{
-		MaxDatafileSize: DefaultMaxDatafileSize,
-		MaxKeySize:      DefaultMaxKeySize,
-		MaxValueSize:    DefaultMaxValueSize,
-		Sync:            DefaultSync,
+// WithReadonly opens the database read-only, any modification returns
+// ErrReadOnlyDatabase. The setting is not persisted.
+func WithReadonly(readonly bool) Option {
+	return func(cfg *config.Config) error {
+		cfg.Readonly = readonly
+		return nil
+	}
+}
+
+// WithChecksumMode sets what entry checksums cover, ChecksumValueOnly or
+// ChecksumKeyAndValue. The mode is fixed when the database is created.
+func WithChecksumMode(mode string) Option {
+	return func(cfg *config.Config) error {
+		if mode != ChecksumValueOnly && mode != ChecksumKeyAndValue {
+			return errInvalidChecksumMode
+		}
+		cfg.ChecksumMode = mode
+		return nil
+	}
+}
+
+// WithChecksum sets the algorithm computing entry checksums, ChecksumCRC32,
+// ChecksumCRC32C or ChecksumXXHash, or ChecksumCRC64 or ChecksumXXHash64 whose
+// 8 byte checksums make every entry 4 bytes larger. The algorithm is fixed
+// when the database is created.
+func WithChecksum(algorithm string) Option {
+	return func(cfg *config.Config) error {
+		switch algorithm {
+		case ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash, ChecksumCRC64, ChecksumXXHash64:
+		default:
+			return errInvalidChecksum
+		}
+		cfg.Checksum = algorithm
+		return nil
+	}
+}
+
+// WithAutoMerge merges the database in the background whenever the ratio of
+// reclaimable to total datafile size exceeds threshold. Zero disables it.
+func WithAutoMerge(threshold float64) Option {
+	return func(cfg *config.Config) error {
+		if threshold < 0 || threshold >= 1 {
+			return errInvalidAutoMergeThreshold
+		}
+		cfg.AutoMergeThreshold = threshold
+		return nil
+	}
+}
+
+// WithForceConfig allows the other options to shrink the key and value size
+// limits persisted with an existing database. Entries exceeding the new limits
+// can no longer be read.
+func WithForceConfig() Option {
+	return func(cfg *config.Config) error {
+		cfg.ForceConfig = true
+		return nil
+	}
+}
+
+// WithDatafileFormat sets the filename format of datafiles, holding a single
+// integer verb for the datafile id and ending with .data, e.g. "%012d.data" or
+// "shard-1-%09d.data". The format is fixed when the database is created.
+func WithDatafileFormat(format string) Option {
+	return func(cfg *config.Config) error {
+		if _, _, err := internal.SplitDatafileFormat(format); err != nil {
+			return err
+		}
+		cfg.DatafileFormat = format
+		return nil
+	}
+}
+
+// WithCompression sets the compression applied to newly written values, either
+// CompressionNone or CompressionGzip. Each entry records whether it is
+// compressed, so the setting may change between opens.
+func WithCompression(compression string) Option {
+	return func(cfg *config.Config) error {
+		if compression != CompressionNone && compression != CompressionGzip {
+			return errInvalidCompression
+		}
+		cfg.Compression = compression
+		return nil
+	}
+}
+
+// WithEncryption encrypts values at rest with AES-GCM using key, which must be
+// 16, 24 or 32 bytes long. Keys are stored in plaintext. Encryption is fixed
+// when the database is created and the same key must be given on every open;
+// the key itself is never persisted.
+func WithEncryption(key []byte) Option {
+	return func(cfg *config.Config) error {
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return errInvalidEncryptionKey
+		}
+		cfg.EncryptionKey = append([]byte(nil), key...)
+		cfg.EncryptionCheck = codec.KeyCheck(key)
+		return nil
+	}
+}
+
+// WithTempDir sets the directory in which Merge builds new datafiles before
+// moving them into the database, by default a directory under the database
+// path. It must be on the same filesystem as the database, which Open checks
+// by moving a file from it.
+func WithTempDir(dir string) Option {
+	return func(cfg *config.Config) error {
+		cfg.TempDir = dir
+		return nil
+	}
+}
+
+// WithStrictRecovery makes Open fail with ErrChecksumFailed when rebuilding
+// the index finds a corrupt entry, by default the entry is skipped and logged.
+func WithStrictRecovery(strict bool) Option {
+	return func(cfg *config.Config) error {
+		cfg.StrictRecovery = strict
+		return nil
+	}
+}
+
+// WithKeyTransform normalizes keys with fn before indexing them, e.g. by
+// lowercasing them for case-insensitive keys, so that keys fn maps to the same
+// key are one. Entries keep the key as written, which Fold and Range pass on,
+// while Scan, Keys and the other iterations yield normalized keys, and Range
+// bounds and Scan prefixes are normalized too.
+//
+// fn must be deterministic, idempotent and the same every time the database
+// is opened, or keys indexed before won't be found.
+func WithKeyTransform(fn func(key []byte) []byte) Option {
+	return func(cfg *config.Config) error {
+		cfg.KeyTransform = fn
+		return nil
+	}
+}
+
+// WithStrictDelete makes Delete return ErrKeyNotFound for a key that doesn't
+// exist rather than writing a tombstone for it anyway.
+func WithStrictDelete() Option {
+	return func(cfg *config.Config) error {
+		cfg.StrictDelete = true
+		return nil
+	}
+}
+
+// WithForceIndexRebuild makes Open ignore the index and hint files and
+// rebuild the index from the datafiles. They are ignored anyway when a
+// datafile was modified after them.
+func WithForceIndexRebuild() Option {
+	return func(cfg *config.Config) error {
+		cfg.ForceIndexRebuild = true
+		return nil
+	}
+}
+
+// WithOnCorruption calls fn with every entry failing its checksum found while
+// rebuilding the index, by Get and by Verify, and handles it as fn returns:
+//
+// - ActionAbort fails Open with ErrChecksumFailed, Get with ErrChecksumFailed
+// and stops Verify, returning ErrChecksumFailed along with its report
+// - ActionSkip leaves the entry out of the rebuilt index, so an older entry
+// of the key may be found, makes Get return ErrKeyNotFound and Verify carry
+// on
+// - ActionDelete deletes the key, with a tombstone unless the database is
+// read-only or the index is being rebuilt
+//
+// It takes precedence over WithStrictRecovery. Without it corrupt entries are
+// skipped when rebuilding the index, returned as ErrChecksumFailed by Get and
+// reported by Verify.
+func WithOnCorruption(fn func(key []byte, fileID int, err error) Action) Option {
+	return func(cfg *config.Config) error {
+		cfg.OnCorruption = fn
+		return nil
+	}
+}
+
+// WithRepairOnOpen repairs the datafiles when opening the database like
+// Repair does. It has no effect on a read-only database.
+func WithRepairOnOpen() Option {
+	return func(cfg *config.Config) error {
+		cfg.RepairOnOpen = true
+		return nil
+	}
+}
+
+// WithIndex selects the in-memory index implementation, IndexART or
+// IndexHashmap. The index file format is the same for both.
+func WithIndex(kind string) Option {
+	return func(cfg *config.Config) error {
+		if kind != IndexART && kind != IndexHashmap {
+			return errInvalidIndex
+		}
+		cfg.Index = kind
+		return nil
+	}
+}
+
+// WithFileMode sets the permissions of the datafiles, hint files, index and
+// config the database creates. By default datafiles are created with 0640 and
+// the other files with 0600.
+func WithFileMode(mode os.FileMode) Option {
+	return func(cfg *config.Config) error {
+		if mode&^os.ModePerm != 0 {
+			return errInvalidFileMode
+		}
+		cfg.FileMode = mode
+		return nil
+	}
+}
+
+// WithDirMode sets the permissions of the directories the database creates,
+// 0755 by default.
+func WithDirMode(mode os.FileMode) Option {
+	return func(cfg *config.Config) error {
+		if mode&^os.ModePerm != 0 {
+			return errInvalidFileMode
+		}
+		cfg.DirMode = mode
+		return nil
+	}
+}
+
+// WithLogger routes diagnostic messages to logger, by default they are
+// discarded
+func WithLogger(logger Logger) Option {
+	return func(cfg *config.Config) error {
+		cfg.Logger = logger
+		return nil
 	}
 }
+
+// WithMaxOpenFiles bounds the number of read-only datafiles kept open,
+// closing the least recently used ones and reopening them when read. By
+// default all datafiles stay open. The current datafile doesn't count.
+func WithMaxOpenFiles(n int) Option {
+	return func(cfg *config.Config) error {
+		if n <= 0 {
+			return errInvalidMaxOpenFiles
+		}
+		cfg.MaxOpenFiles = n
+		return nil
+	}
+}
+
+// WithWriteBuffer buffers up to size bytes of entries before writing them to
+// the current datafile, instead of writing every entry as it's put. Buffered
+// entries are written by Sync and Close, and are lost on a crash before.
+func WithWriteBuffer(size int) Option {
+	return func(cfg *config.Config) error {
+		if size <= 0 {
+			return errInvalidWriteBuffer
+		}
+		cfg.WriteBuffer = size
+		return nil
+	}
+}
+
+// WithBloomFilter writes a bloom filter of the keys of each datafile next to
+// its hint file, so that GetFrom can tell a key is certainly not in it.
+func WithBloomFilter() Option {
+	return func(cfg *config.Config) error {
+		cfg.BloomFilter = true
+		return nil
+	}
+}
+
+// WithOpenTimeout makes Open return ErrOpenTimeout if loading the datafiles
+// and the index takes longer than timeout, e.g. on a hung network filesystem.
+// The datafiles opened by the abandoned load are closed once it completes.
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(cfg *config.Config) error {
+		if timeout <= 0 {
+			return errInvalidOpenTimeout
+		}
+		cfg.OpenTimeout = timeout
+		return nil
+	}
+}
+
+// WithSyncInterval syncs the current datafile to disk every interval in the
+// background, bounding the writes lost on a crash to those of the last
+// interval without the cost of WithSync.
+func WithSyncInterval(interval time.Duration) Option {
+	return func(cfg *config.Config) error {
+		if interval <= 0 {
+			return errInvalidSyncInterval
+		}
+		cfg.SyncInterval = interval
+		return nil
+	}
+}
+
+// WithChunkSize sets the size of the chunks PutLarge splits values into, by
+// default and at most the maximum value size.
+func WithChunkSize(size uint64) Option {
+	return func(cfg *config.Config) error {
+		if size == 0 {
+			return errInvalidChunkSize
+		}
+		cfg.ChunkSize = size
+		return nil
+	}
+}
+
+// WithMetrics reports the latency of Get, Put and Delete, whether Get found
+// its key and the bytes reclaimed by merges to metrics. By default nothing is
+// measured.
+func WithMetrics(metrics Metrics) Option {
+	return func(cfg *config.Config) error {
+		cfg.Metrics = metrics
+		return nil
+	}
+}
+
+// DefaultConfig returns the config a new database is opened with before its
+// options are applied: datafiles of up to DefaultMaxDatafileSize (1MB), keys
+// of up to DefaultMaxKeySize (64 bytes), values of up to DefaultMaxValueSize
+// (64KB), no sync after each write, CRC-32 checksums over values only, no
+// compression and no automatic merges. An existing database is opened with
+// the config persisted with it instead.
+func DefaultConfig() Config {
+	return Config{
+		MaxDatafileSize:    DefaultMaxDatafileSize,
+		MaxKeySize:         DefaultMaxKeySize,
+		MaxValueSize:       DefaultMaxValueSize,
+		Sync:               DefaultSync,
+		Version:            codec.Version,
+		ChecksumMode:       ChecksumValueOnly,
+		Checksum:           ChecksumCRC32,
+		DatafileFormat:     DefaultDatafileFormat,
+		AutoMergeThreshold: DefaultAutoMergeThreshold,
+		Compression:        CompressionNone,
+	}
+}
+
+func newDefaultConfig() *config.Config {
+	cfg := DefaultConfig()
+	return &cfg
+}
@@ -0,0 +1,171 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInvalidSizeOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+		want error
+	}{
+		{name: "zero datafile size", opt: WithMaxDatafileSize(0), want: errInvalidMaxDatafileSize},
+		{name: "negative datafile size", opt: WithMaxDatafileSize(-1), want: errInvalidMaxDatafileSize},
+		{name: "zero key size", opt: WithMaxKeySize(0), want: errInvalidMaxKeySize},
+		{name: "zero value size", opt: WithMaxValueSize(0), want: errInvalidMaxValueSize},
+		{name: "zero chunk size", opt: WithChunkSize(0), want: errInvalidChunkSize},
+		{name: "zero max open files", opt: WithMaxOpenFiles(0), want: errInvalidMaxOpenFiles},
+		{name: "zero write buffer", opt: WithWriteBuffer(0), want: errInvalidWriteBuffer},
+		{name: "zero open timeout", opt: WithOpenTimeout(0), want: errInvalidOpenTimeout},
+		{name: "zero sync interval", opt: WithSyncInterval(0), want: errInvalidSyncInterval},
+		{name: "unknown index", opt: WithIndex("btree"), want: errInvalidIndex},
+		{name: "unknown checksum", opt: WithChecksum("md5"), want: errInvalidChecksum},
+		{name: "file mode type bits", opt: WithFileMode(os.ModeDir | 0600), want: errInvalidFileMode},
+		{name: "dir mode type bits", opt: WithDirMode(os.ModeSymlink | 0700), want: errInvalidFileMode},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Open(tempDir(t), test.opt); err != test.want {
+				t.Errorf("open, want: %v, got: %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.MaxDatafileSize != 1<<20 || cfg.MaxKeySize != 64 || cfg.MaxValueSize != 1<<16 || cfg.Sync {
+		t.Errorf("defaults, want: 1MB datafiles, 64 byte keys, 64KB values and no sync, got: %+v", cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("validate, want: nil, got: %v", err)
+	}
+
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if db.cfg.MaxDatafileSize != cfg.MaxDatafileSize || db.cfg.MaxKeySize != cfg.MaxKeySize ||
+		db.cfg.MaxValueSize != cfg.MaxValueSize || db.cfg.Sync != cfg.Sync ||
+		db.cfg.ChecksumMode != cfg.ChecksumMode || db.cfg.Compression != cfg.Compression {
+		t.Errorf("open without options, want: %+v, got: %+v", cfg, *db.cfg)
+	}
+}
+
+func TestSizeOptions(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxKeySize(4), WithMaxValueSize(8), WithMaxDatafileSize(64))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("keys"), []byte("value")); err != nil {
+		t.Errorf("put within limits error: %v", err)
+	}
+	if err := db.Put([]byte("key-5"), []byte("value")); err != ErrKeyTooLarge {
+		t.Errorf("put large key, want: %v, got: %v", ErrKeyTooLarge, err)
+	}
+	if err := db.Put([]byte("key"), []byte("value-9")); err != nil {
+		t.Errorf("put value within limit error: %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("value-10!")); err != ErrValueTooLarge {
+		t.Errorf("put large value, want: %v, got: %v", ErrValueTooLarge, err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if stats, _ := db.Stats(false); stats.Datafiles < 2 {
+		t.Errorf("datafiles, want rotation past 64 bytes, got: %d", stats.Datafiles)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// the limits are persisted and apply on reopen without options
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("key-5"), []byte("value")); err != ErrKeyTooLarge {
+		t.Errorf("put large key after reopen, want: %v, got: %v", ErrKeyTooLarge, err)
+	}
+	got, err := db.Get([]byte("keys"))
+	if err != nil || !bytes.Equal(got, []byte("value")) {
+		t.Errorf("get after reopen, want: %s, got: %s (%v)", "value", got, err)
+	}
+}
+
+func TestConfigMismatch(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxKeySize(32), WithMaxValueSize(1024), WithMaxDatafileSize(4096))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	db.Close()
+
+	tests := []struct {
+		name string
+		opts []Option
+		want error
+	}{
+		{name: "smaller key size", opts: []Option{WithMaxKeySize(16)}, want: ErrConfigMismatch},
+		{name: "smaller value size", opts: []Option{WithMaxValueSize(512)}, want: ErrConfigMismatch},
+		{name: "smaller datafile size", opts: []Option{WithMaxDatafileSize(1024)}, want: nil},
+		{name: "larger sizes", opts: []Option{WithMaxKeySize(64), WithMaxValueSize(2048)}, want: nil},
+		{name: "forced smaller sizes", opts: []Option{WithMaxKeySize(16), WithMaxValueSize(512), WithForceConfig()}, want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := Open(path, test.opts...)
+			if !errors.Is(err, test.want) {
+				t.Errorf("reopen, want: %v, got: %v", test.want, err)
+			}
+			if err == nil {
+				db.Close()
+			}
+		})
+	}
+}
+
+func TestFileModes(t *testing.T) {
+	path := filepath.Join(tempDir(t), "db")
+	db, err := Open(path, WithFileMode(0600), WithDirMode(0700))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want os.FileMode
+	}{
+		{name: ".", want: 0700 | os.ModeDir},
+		{name: "config.json", want: 0600},
+		{name: "index", want: 0600},
+		{name: "000000000.data", want: 0600},
+		{name: "000000000.hint", want: 0600},
+	}
+	for _, test := range tests {
+		stat, err := os.Stat(filepath.Join(path, test.name))
+		if err != nil {
+			t.Errorf("stat %s error: %v", test.name, err)
+			continue
+		}
+		if stat.Mode() != test.want {
+			t.Errorf("mode of %s, want: %v, got: %v", test.name, test.want, stat.Mode())
+		}
+	}
+}
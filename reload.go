@@ -0,0 +1,29 @@
+package bitcask
+
+// Reload closes the datafiles and opens them again, rebuilding the index from
+// the index file and the datafiles, to pick up changes made to them by
+// another process, e.g. the writer of a database opened read-only or an
+// out-of-process merge. It fails with ErrTxnsOpen while transactions are
+// open, values read through GetReader stay readable. If reloading fails the
+// database is closed.
+func (b *Bitcask) Reload() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if b.inMemory {
+		return ErrInMemory
+	}
+	if len(b.txns) > 0 {
+		return ErrTxnsOpen
+	}
+	err := b.closeDatafiles()
+	if err == nil {
+		err = b.openFiles()
+	}
+	if err != nil {
+		b.fail()
+	}
+	return err
+}
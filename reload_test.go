@@ -0,0 +1,67 @@
+package bitcask
+
+import (
+	"testing"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/data"
+)
+
+func TestReload(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	reader, err := Open(path, WithReadonly(true))
+	if err != nil {
+		t.Fatalf("open read-only error: %v", err)
+	}
+	defer reader.Close()
+
+	// written by another process
+	df, err := data.NewDatafile(path, 5, false, db.cfg)
+	if err != nil {
+		t.Fatalf("open datafile error: %v", err)
+	}
+	if _, _, err := df.Write(internal.NewEntry([]byte("b"), []byte("2"))); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if err := df.Close(); err != nil {
+		t.Fatalf("close datafile error: %v", err)
+	}
+	if err := db.Put([]byte("c"), []byte("3")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+
+	for name, db := range map[string]*Bitcask{"writer": db, "reader": reader} {
+		if err := db.Reload(); err != nil {
+			t.Fatalf("%s reload error: %v", name, err)
+		}
+		for key, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+			if got, err := db.Get([]byte(key)); err != nil || string(got) != want {
+				t.Errorf("%s get %s after reload, want: %s, got: %s (%v)", name, key, want, got, err)
+			}
+		}
+	}
+	// writes go after the datafile written by the other process
+	if err := db.Put([]byte("d"), []byte("4")); err != nil {
+		t.Fatalf("put after reload error: %v", err)
+	}
+	if id := db.curr.FileID(); id != 6 {
+		t.Errorf("current datafile after reload, want: 6, got: %d", id)
+	}
+
+	txn := db.Begin()
+	if err := db.Reload(); err != ErrTxnsOpen {
+		t.Errorf("reload with an open transaction, want: %v, got: %v", ErrTxnsOpen, err)
+	}
+	txn.Rollback()
+}
@@ -0,0 +1,97 @@
+package bitcask
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"jay.com/bitcask/internal"
+)
+
+// Relocate moves the files of the database to newPath and carries on from
+// there, renaming them on the same filesystem and copying them otherwise.
+// newPath is created if needed and must not hold another database. Writes are
+// blocked while relocating. If relocating fails the database is closed, with
+// its files left in either directory.
+func (b *Bitcask) Relocate(newPath string) error {
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if b.inMemory {
+		return ErrInMemory
+	}
+	if err := os.MkdirAll(newPath, b.cfg.DirModeOr(0755)); err != nil {
+		return err
+	}
+	// lock the new path first so no one opens it half moved
+	flock, err := internal.Flock(filepath.Join(newPath, "lock"))
+	if err != nil {
+		if err == internal.ErrLocked {
+			return ErrDatabaseLocked
+		}
+		return err
+	}
+	if err := b.moveFiles(newPath); err != nil {
+		flock.Close()
+		b.fail()
+		return err
+	}
+	os.Remove(b.flock.Name())
+	b.flock.Close()
+	b.flock = flock
+	// the old directory is only removed if nothing else is in it
+	os.Remove(b.path)
+	b.path = newPath
+	if err := b.openFiles(); err != nil {
+		b.fail()
+		return err
+	}
+	return nil
+}
+
+// fail closes a database left without open datafiles, it must be called with
+// b.mu held for writing
+func (b *Bitcask) fail() {
+	b.closed = true
+	b.closeSubscriptions()
+	close(b.done)
+	os.Remove(b.flock.Name())
+	b.flock.Close()
+}
+
+// moveFiles closes the datafiles and saves the index, then moves every file
+// but the lock to newPath
+func (b *Bitcask) moveFiles(newPath string) error {
+	if err := b.closeDatafiles(); err != nil {
+		return err
+	}
+	if err := b.saveIndex(filepath.Join(b.path, "index")); err != nil {
+		return err
+	}
+	fis, err := ioutil.ReadDir(b.path)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if fi.IsDir() || fi.Name() == "lock" {
+			continue
+		}
+		src, dst := filepath.Join(b.path, fi.Name()), filepath.Join(newPath, fi.Name())
+		if err := os.Rename(src, dst); err == nil {
+			continue
+		}
+		// e.g. across filesystems
+		if err := internal.CopyFile(src, dst); err != nil {
+			return err
+		}
+		if err := os.Remove(src); err != nil {
+			return err
+		}
+	}
+	return internal.FsyncDir(newPath)
+}
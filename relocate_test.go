@@ -0,0 +1,72 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelocate(t *testing.T) {
+	path := tempDir(t)
+	oldPath := filepath.Join(path, "old")
+	newPath := filepath.Join(path, "new")
+	db, err := Open(oldPath, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Relocate(newPath); err != nil {
+		t.Fatalf("relocate error: %v", err)
+	}
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("old path after relocate, want removed, got: %v", err)
+	}
+	check := func() {
+		t.Helper()
+		for i := 0; i < 11; i++ {
+			want := fmt.Sprintf("value-%05d", i)
+			if got, err := db.Get([]byte(fmt.Sprintf("key-%02d", i))); err != nil || string(got) != want {
+				t.Errorf("get, want: %s, got: %s (%v)", want, got, err)
+			}
+		}
+	}
+	if err := db.Put([]byte("key-10"), []byte("value-00010")); err != nil {
+		t.Fatalf("put after relocate error: %v", err)
+	}
+	check()
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	db, err = Open(newPath, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	check()
+}
+
+func TestRelocateLocked(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(filepath.Join(path, "a"))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	other, err := Open(filepath.Join(path, "b"))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer other.Close()
+	if err := db.Relocate(filepath.Join(path, "b")); err != ErrDatabaseLocked {
+		t.Errorf("relocate to an open database, want: %v, got: %v", ErrDatabaseLocked, err)
+	}
+	if err := db.Put([]byte("foo"), []byte("bar")); err != nil {
+		t.Errorf("put after failed relocate error: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package bitcask
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/config"
+	"jay.com/bitcask/internal/data"
+	"jay.com/bitcask/internal/data/codec"
+)
+
+// Repair truncates every datafile of the database at path at its first entry
+// that can't be decoded, such as one half written by a crash, and rebuilds
+// the index. The options are those the database is opened with.
+func Repair(path string, options ...Option) error {
+	db, err := Open(path, append(options, WithRepairOnOpen())...)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// repairDatafiles truncates the datafiles at their first entry that can't be
+// decoded, removing the index and the hint files made stale by it.
+func repairDatafiles(path string, cfg *config.Config) error {
+	ids, err := datafileIDs(path, cfg)
+	if err != nil {
+		return err
+	}
+	repaired := false
+	for _, id := range ids {
+		truncated, err := repairDatafile(path, id, cfg, false)
+		if err != nil {
+			return err
+		}
+		repaired = repaired || truncated
+	}
+	if !repaired {
+		return nil
+	}
+	return removeIndex(path)
+}
+
+// repairTail truncates the last datafile if it ends with a truncated entry,
+// which a crash while writing leaves behind. Once another datafile follows it
+// the truncated entry would no longer be at the end, so it must go before
+// writes start.
+func repairTail(path string, cfg *config.Config) error {
+	ids, err := datafileIDs(path, cfg)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	truncated, err := repairDatafile(path, ids[len(ids)-1], cfg, true)
+	if err != nil || !truncated {
+		return err
+	}
+	return removeIndex(path)
+}
+
+// repairDatafile truncates the datafile with the given id at its first entry
+// that can't be decoded, or with tailOnly that is truncated, and removes its
+// hint file. It reports whether the datafile was truncated.
+func repairDatafile(path string, id int, cfg *config.Config, tailOnly bool) (bool, error) {
+	df, err := data.NewDatafile(path, id, true, cfg)
+	if err != nil {
+		return false, err
+	}
+	offset, decodeErr := decodableSize(df)
+	if err := df.Close(); err != nil {
+		return false, err
+	}
+	if decodeErr == nil || tailOnly && !codec.IsTruncated(decodeErr) {
+		return false, nil
+	}
+	cfg.Logger.Printf("truncating datafile %d at offset %d: %v", id, offset, decodeErr)
+	if err := os.Truncate(df.Name(), offset); err != nil {
+		return false, err
+	}
+	return true, removeHint(df.Name())
+}
+
+func datafileIDs(path string, cfg *config.Config) ([]int, error) {
+	fns, err := internal.GetDatafiles(path, cfg.DatafileFormat)
+	if err != nil {
+		return nil, err
+	}
+	return internal.ParseIds(fns, cfg.DatafileFormat)
+}
+
+// removeIndex removes the index file, so that it's rebuilt on load
+func removeIndex(path string) error {
+	err := os.Remove(filepath.Join(path, "index"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// decodableSize returns the size of the leading entries of df that can be
+// decoded, and the error decoding the next one if it isn't the end of df.
+func decodableSize(df data.DataFile) (int64, error) {
+	var offset int64
+	for {
+		_, n, err := df.Read()
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		offset += n
+	}
+}
@@ -0,0 +1,168 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// a crash leaves a half written entry behind, and an index without it
+	fn := filepath.Join(path, "000000000.data")
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatalf("stat error: %v", err)
+	}
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open datafile error: %v", err)
+	}
+	f.Write([]byte{0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 5, 0, 'k', 'e'})
+	f.Close()
+	os.Remove(filepath.Join(path, "index"))
+	os.Remove(filepath.Join(path, "000000000.hint"))
+
+	if err := Repair(path); err != nil {
+		t.Fatalf("repair error: %v", err)
+	}
+	if got, err := os.Stat(fn); err != nil || got.Size() != fi.Size() {
+		t.Errorf("datafile size after repair, want: %d, got: %v (%v)", fi.Size(), got.Size(), err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		want := []byte(fmt.Sprintf("value-%d", i))
+		if got, err := db.Get(key); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("get %s, want: %s, got: %s (%v)", key, want, got, err)
+		}
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Errorf("put after repair error: %v", err)
+	}
+}
+
+// writeTestStore writes keys 0 to 9 to datafiles of two entries each and
+// removes the index and hint files, so that opening rebuilds from datafiles
+func writeTestStore(t *testing.T, path string) {
+	t.Helper()
+	db, err := Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i)))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	os.Remove(filepath.Join(path, "index"))
+	fns, _ := filepath.Glob(filepath.Join(path, "*.hint"))
+	for _, fn := range fns {
+		os.Remove(fn)
+	}
+}
+
+func TestTruncatedTail(t *testing.T) {
+	path := tempDir(t)
+	writeTestStore(t, path)
+	// cut the last entry of the last datafile short
+	last := filepath.Join(path, "000000004.data")
+	if err := os.Truncate(last, 80); err != nil {
+		t.Fatalf("truncate error: %v", err)
+	}
+
+	db, err := Open(path, WithReadonly(true))
+	if err != nil {
+		t.Fatalf("read-only open error: %v", err)
+	}
+	if db.Len() != 9 {
+		t.Errorf("read-only keys, want: 9, got: %d", db.Len())
+	}
+	db.Close()
+
+	db, err = Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if fi, err := os.Stat(last); err != nil || fi.Size() != 50 {
+		t.Errorf("last datafile size, want truncated to 50, got: %v (%v)", fi.Size(), err)
+	}
+	db.Put([]byte("key-09"), []byte("rewritten"))
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path, WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 9; i++ {
+		key := []byte(fmt.Sprintf("key-%02d", i))
+		want := []byte(fmt.Sprintf("value-%05d", i))
+		if got, err := db.Get(key); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("get %s, want: %s, got: %s (%v)", key, want, got, err)
+		}
+	}
+	if got, _ := db.Get([]byte("key-09")); !bytes.Equal(got, []byte("rewritten")) {
+		t.Errorf("get key-09, want: rewritten, got: %s", got)
+	}
+}
+
+func TestCorruptDatafile(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(path string) error
+	}{
+		{
+			name: "invalid key size",
+			corrupt: func(path string) error {
+				f, err := os.OpenFile(filepath.Join(path, "000000004.data"), os.O_RDWR, 0)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				_, err = f.WriteAt([]byte{0xff, 0xff}, 50)
+				return err
+			},
+		},
+		{
+			name: "truncated entry before the last datafile",
+			corrupt: func(path string) error {
+				return os.Truncate(filepath.Join(path, "000000003.data"), 80)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := tempDir(t)
+			writeTestStore(t, path)
+			if err := test.corrupt(path); err != nil {
+				t.Fatalf("corrupt error: %v", err)
+			}
+			if db, err := Open(path); err == nil {
+				db.Close()
+				t.Errorf("open corrupt datafile, want error, got: nil")
+			}
+		})
+	}
+}
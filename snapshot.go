@@ -0,0 +1,73 @@
+package bitcask
+
+import (
+	"bytes"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/index"
+)
+
+// Snapshot is a read-only view of the database as it was when Snapshot was
+// called. Writes after that aren't visible to it, as their entries are
+// appended rather than overwriting the ones it refers to.
+//
+// The entries stay readable until Merge removes their datafiles, after which
+// Get of a key whose entry was merged away fails with ErrDatafileMissing. A
+// Snapshot is safe for concurrent use.
+type Snapshot struct {
+	db *Bitcask
+	t  index.Tree
+}
+
+// Snapshot takes a point-in-time view of the database, copying its index
+func (b *Bitcask) Snapshot() (*Snapshot, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	// the keys are already transformed, they're inserted as they are
+	t := index.NewTree(b.cfg.Index)
+	b.t.ForEach(func(key []byte, item internal.Item) bool {
+		t.Insert(key, item)
+		return true
+	})
+	if b.cfg.KeyTransform != nil {
+		t = index.NewTransformTree(t, b.cfg.KeyTransform)
+	}
+	return &Snapshot{db: b, t: t}, nil
+}
+
+// Get retrieves the value key had when the snapshot was taken
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	item, found := s.t.Search(key)
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	e, err := s.db.read(item)
+	if err != nil {
+		return nil, err
+	}
+	if s.db.expired(e) {
+		return nil, ErrKeyNotFound
+	}
+	// the entry may have been written after the snapshot in place of the
+	// one it referred to
+	if !bytes.Equal(s.db.indexKey(e.Key), s.db.indexKey(key)) {
+		return nil, ErrDatafileMissing
+	}
+	return e.Value, nil
+}
+
+// Has returns true if key existed when the snapshot was taken
+func (s *Snapshot) Has(key []byte) bool {
+	_, found := s.t.Search(key)
+	return found
+}
+
+// Len returns the number of keys when the snapshot was taken
+func (s *Snapshot) Len() int {
+	return s.t.Size()
+}
@@ -0,0 +1,78 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Put([]byte(key), []byte("value-"+key)); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	if err := db.Put([]byte("a"), []byte("overwritten")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if err := db.Delete([]byte("b")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.Put([]byte("d"), []byte("value-d")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		got, err := snap.Get([]byte(key))
+		if err != nil || string(got) != "value-"+key {
+			t.Errorf("snapshot get %s, want: %s, got: %s (%v)", key, "value-"+key, got, err)
+		}
+	}
+	if _, err := snap.Get([]byte("d")); err != ErrKeyNotFound {
+		t.Errorf("snapshot get of a later key, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	if snap.Has([]byte("d")) || snap.Len() != 3 {
+		t.Errorf("snapshot keys, want: 3 without d, got: %d, has d: %v", snap.Len(), snap.Has([]byte("d")))
+	}
+	if got, _ := db.Get([]byte("a")); string(got) != "overwritten" {
+		t.Errorf("get, want: %s, got: %s", "overwritten", got)
+	}
+
+	// merging removes the datafiles the snapshot refers to
+	if err := db.Merge(); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	if _, err := snap.Get([]byte("c")); !errors.Is(err, ErrDatafileMissing) {
+		t.Errorf("snapshot get after merge, want: %v, got: %v", ErrDatafileMissing, err)
+	}
+}
+
+func TestSnapshotKeyTransform(t *testing.T) {
+	db, err := OpenInMemory(WithKeyTransform(bytes.ToLower))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	for _, key := range []string{"key", "KEY"} {
+		if got, err := snap.Get([]byte(key)); err != nil || string(got) != "value" {
+			t.Errorf("snapshot get %s, want: %s, got: %s (%v)", key, "value", got, err)
+		}
+	}
+}
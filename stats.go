@@ -0,0 +1,180 @@
+package bitcask
+
+import (
+	"math"
+	"sort"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/data/codec"
+)
+
+// Stats is a summary of the database's size on disk
+type Stats struct {
+	// Datafiles is the number of datafiles, including the current one
+	Datafiles int
+	// Keys is the number of keys
+	Keys int
+	// TotalSize is the sum of all datafile sizes in bytes
+	TotalSize int64
+	// ReclaimableSize is the number of bytes taken by overwritten entries
+	// and tombstones, which Merge would reclaim
+	ReclaimableSize int64
+	// KeySizes and ValueSizes are the distributions of the sizes in bytes of
+	// the keys and their values, only computed by a detailed Stats. Values
+	// are counted as stored, compressed if they were.
+	KeySizes   SizeHistogram
+	ValueSizes SizeHistogram
+}
+
+// SizeHistogram summarizes a distribution of sizes in bytes, percentiles are
+// nearest-rank
+type SizeHistogram struct {
+	Count int
+	Min   int64
+	Max   int64
+	Mean  float64
+	P50   int64
+	P90   int64
+	P99   int64
+}
+
+// newSizeHistogram returns the histogram of sizes, which it sorts
+func newSizeHistogram(sizes []int64) SizeHistogram {
+	if len(sizes) == 0 {
+		return SizeHistogram{}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	var sum int64
+	for _, size := range sizes {
+		sum += size
+	}
+	percentile := func(p float64) int64 {
+		return sizes[int(math.Ceil(p/100*float64(len(sizes))))-1]
+	}
+	return SizeHistogram{
+		Count: len(sizes),
+		Min:   sizes[0],
+		Max:   sizes[len(sizes)-1],
+		Mean:  float64(sum) / float64(len(sizes)),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+	}
+}
+
+// FileUsage is the space taken by a datafile
+type FileUsage struct {
+	FileID int
+	// TotalBytes is the size of the datafile
+	TotalBytes int64
+	// LiveBytes is the size of the entries of current keys
+	LiveBytes int64
+	// DeadBytes is the size of overwritten entries and tombstones, which
+	// merging the datafile would reclaim
+	DeadBytes int64
+}
+
+// Stats returns statistics of the database, which can be used to decide when
+// to call Merge. If detailed the sizes of all keys and values are summed up
+// too, which takes memory in proportion to the number of keys.
+func (b *Bitcask) Stats(detailed bool) (Stats, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return Stats{}, ErrClosed
+	}
+	stats := b.stats()
+	if detailed {
+		stats.KeySizes, stats.ValueSizes = b.sizeHistograms()
+	}
+	return stats, nil
+}
+
+// KeyCount returns the number of keys, maintained as keys are written rather
+// than counted. It returns zero once the database is closed.
+func (b *Bitcask) KeyCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return 0
+	}
+	return b.keys
+}
+
+// SizeOnDisk returns the sum of all datafile sizes in bytes, maintained as
+// entries are written rather than summed up. It returns zero once the database
+// is closed.
+func (b *Bitcask) SizeOnDisk() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return 0
+	}
+	return b.size
+}
+
+// DiskUsage returns the space taken by each datafile, including the current
+// one, ordered by FileID. The datafiles with the most DeadBytes gain the most
+// from being merged.
+func (b *Bitcask) DiskUsage() ([]FileUsage, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	live := make(map[int]int64)
+	b.t.ForEach(func(_ []byte, item internal.Item) bool {
+		live[item.FileID] += item.Size
+		return true
+	})
+	usage := []FileUsage{{FileID: b.curr.FileID(), TotalBytes: b.curr.Size()}}
+	for id, df := range b.datafiles {
+		if id != b.curr.FileID() {
+			usage = append(usage, FileUsage{FileID: id, TotalBytes: df.Size()})
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].FileID < usage[j].FileID
+	})
+	for i := range usage {
+		usage[i].LiveBytes = live[usage[i].FileID]
+		usage[i].DeadBytes = usage[i].TotalBytes - usage[i].LiveBytes
+	}
+	return usage, nil
+}
+
+func (b *Bitcask) stats() Stats {
+	stats := Stats{
+		Datafiles: 1,
+		Keys:      b.t.Size(),
+		TotalSize: b.curr.Size(),
+	}
+	for id, df := range b.datafiles {
+		if id == b.curr.FileID() {
+			continue
+		}
+		stats.Datafiles++
+		stats.TotalSize += df.Size()
+	}
+	var live int64
+	b.t.ForEach(func(_ []byte, item internal.Item) bool {
+		live += item.Size
+		return true
+	})
+	stats.ReclaimableSize = stats.TotalSize - live
+	return stats
+}
+
+// sizeHistograms returns the histograms of the sizes of the keys and of their
+// values, the latter worked out from the sizes of their entries
+func (b *Bitcask) sizeHistograms() (SizeHistogram, SizeHistogram) {
+	keySizes := make([]int64, 0, b.t.Size())
+	valueSizes := make([]int64, 0, b.t.Size())
+	encrypted := b.cfg.EncryptionKey != nil
+	b.t.ForEach(func(key []byte, item internal.Item) bool {
+		keySizes = append(keySizes, int64(len(key)))
+		valueSizes = append(valueSizes, codec.ValueSize(len(key), item.Size, encrypted, b.cfg.ChecksumSize()))
+		return true
+	})
+	return newSizeHistogram(keySizes), newSizeHistogram(valueSizes)
+}
@@ -0,0 +1,155 @@
+package bitcask
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"jay.com/bitcask/internal"
+	"jay.com/bitcask/internal/data/codec"
+)
+
+// GetReader returns a reader of the value of the given key, streamed from its
+// datafile rather than read into memory at once. The checksum is verified as
+// the value is read, the last Read returns ErrChecksumFailed if it doesn't
+// match. Compressed and encrypted values are read whole. The reader must be
+// closed.
+func (b *Bitcask) GetReader(key []byte) (io.ReadCloser, error) {
+	if b.inMemory {
+		return b.getWhole(key)
+	}
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	item, found := b.t.Search(key)
+	if !found {
+		b.mu.RUnlock()
+		return nil, ErrKeyNotFound
+	}
+	df, err := b.datafile(item.FileID)
+	if err == nil && df == b.curr && b.cfg.WriteBuffer > 0 && !b.cfg.Readonly {
+		// the entry may still be buffered
+		err = df.Flush()
+	}
+	var f *os.File
+	if err == nil {
+		// the opened file stays readable even if a merge removes it
+		f, err = os.Open(df.Name())
+	}
+	b.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	e, value, err := codec.ValueSection(f, item.Offset, b.cfg.MaxKeySize, b.cfg.MaxValueSize, b.cfg.ChecksumSize())
+	if err == codec.ErrNotPlain {
+		f.Close()
+		return b.getWhole(key)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if b.expired(e) {
+		f.Close()
+		return nil, ErrKeyNotFound
+	}
+	return &valueReader{
+		f:        f,
+		r:        value,
+		checksum: e.Checksum,
+		h:        internal.NewChecksum(b.cfg.ChecksumMode, b.cfg.Checksum, e.Key),
+	}, nil
+}
+
+// getWhole returns a reader of the value of key read whole with Get
+func (b *Bitcask) getWhole(key []byte) (io.ReadCloser, error) {
+	v, err := b.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(v)), nil
+}
+
+// valueReader reads a value from its datafile, computing its checksum on the
+// way
+type valueReader struct {
+	f        *os.File
+	r        io.Reader
+	checksum uint64
+	h        hash.Hash
+}
+
+func (r *valueReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF && internal.SumChecksum(r.h) != r.checksum {
+		err = ErrChecksumFailed
+	}
+	return n, err
+}
+
+func (r *valueReader) Close() error {
+	return r.f.Close()
+}
+
+// PutReader stores key with a value of the given size read from r, streamed
+// to the current datafile rather than read into memory at once. r must yield
+// at least size bytes, nothing is stored if it doesn't. Values are stored
+// uncompressed, and read whole when encrypted.
+func (b *Bitcask) PutReader(key []byte, r io.Reader, size int64) (err error) {
+	if b.cfg.Metrics != internal.DiscardMetrics {
+		defer func(start time.Time) {
+			if err == nil {
+				b.cfg.Metrics.RecordPut(time.Since(start))
+			}
+		}(time.Now())
+	}
+	if b.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	if err := b.checkKey(key); err != nil {
+		return err
+	}
+	if size < 0 || uint64(size) > b.cfg.MaxValueSize {
+		return ErrValueTooLarge
+	}
+	// empty values and encrypted ones go through Put
+	if size == 0 || b.cfg.EncryptionKey != nil {
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	e := internal.Entry{Key: key, Timestamp: b.clock().UnixNano()}
+	if err := b.rotateFor(codec.EncodedSize(e, false, b.cfg.ChecksumSize()) + size); err != nil {
+		return err
+	}
+	offset, n, err := b.curr.WriteFrom(e, r, size, internal.NewChecksum(b.cfg.ChecksumMode, b.cfg.Checksum, key))
+	if err != nil {
+		return err
+	}
+	b.size += n
+	if err := b.maybeSync(); err != nil {
+		return err
+	}
+	b.insert(key, internal.Item{
+		FileID:    b.curr.FileID(),
+		Offset:    offset,
+		Size:      n,
+		Timestamp: e.Timestamp,
+	})
+	return nil
+}
@@ -0,0 +1,152 @@
+package bitcask
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetReader(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxValueSize(1<<21))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	want := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	if err := db.Put([]byte("large"), want); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+
+	r, err := db.GetReader([]byte("large"))
+	if err != nil {
+		t.Fatalf("get reader error: %v", err)
+	}
+	var got []byte
+	buf := make([]byte, 1000)
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read error: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("close error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("value, want: %d bytes, got: %d bytes", len(want), len(got))
+	}
+
+	if _, err := db.GetReader([]byte("missing")); err != ErrKeyNotFound {
+		t.Errorf("get reader of missing key, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestGetReaderChecksum(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	key := []byte("large")
+	if err := db.Put(key, bytes.Repeat([]byte("x"), 10000)); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	// flip a byte in the middle of the value
+	item, _ := db.t.Search(key)
+	f, err := os.OpenFile(db.curr.Name(), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open datafile error: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("y"), item.Offset+13+int64(len(key))+5000); err != nil {
+		t.Fatalf("corrupt error: %v", err)
+	}
+	f.Close()
+
+	r, err := db.GetReader(key)
+	if err != nil {
+		t.Fatalf("get reader error: %v", err)
+	}
+	defer r.Close()
+	buf := make([]byte, 1000)
+	for err == nil {
+		_, err = r.Read(buf)
+	}
+	if err != ErrChecksumFailed {
+		t.Errorf("last read, want: %v, got: %v", ErrChecksumFailed, err)
+	}
+}
+
+func TestGetReaderCompressed(t *testing.T) {
+	db, err := Open(tempDir(t), WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	want := bytes.Repeat([]byte("compressible"), 1000)
+	if err := db.Put([]byte("key"), want); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	r, err := db.GetReader([]byte("key"))
+	if err != nil {
+		t.Fatalf("get reader error: %v", err)
+	}
+	defer r.Close()
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, r); err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("value, want: %d bytes, got: %d bytes", len(want), got.Len())
+	}
+}
+
+func TestPutReader(t *testing.T) {
+	path := tempDir(t)
+	db, err := Open(path, WithMaxValueSize(1<<21))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	want := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	if err := db.PutReader([]byte("large"), bytes.NewReader(want), int64(len(want))); err != nil {
+		t.Fatalf("put reader error: %v", err)
+	}
+	if got, err := db.Get([]byte("large")); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("get, want: %d bytes, got: %d bytes (%v)", len(want), len(got), err)
+	}
+
+	// a short reader stores nothing
+	if err := db.PutReader([]byte("short"), bytes.NewReader(want[:10]), 20); err == nil {
+		t.Errorf("put short reader, want error, got: nil")
+	}
+	if _, err := db.Get([]byte("short")); err != ErrKeyNotFound {
+		t.Errorf("get short, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	if err := db.PutReader([]byte("small"), bytes.NewReader([]byte("value")), 5); err != nil {
+		t.Fatalf("put reader error: %v", err)
+	}
+	if err := db.PutReader([]byte("small"), bytes.NewReader(want), 1<<22); err != ErrValueTooLarge {
+		t.Errorf("put too large, want: %v, got: %v", ErrValueTooLarge, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	os.Remove(filepath.Join(path, "index"))
+	db, err = Open(path, WithMaxValueSize(1<<21))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	if got, err := db.Get([]byte("small")); err != nil || string(got) != "value" {
+		t.Errorf("get after reopen, want: value, got: %s (%v)", got, err)
+	}
+	if got, err := db.Get([]byte("large")); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("get after reopen, want: %d bytes, got: %d bytes (%v)", len(want), len(got), err)
+	}
+}
@@ -0,0 +1,115 @@
+package bitcask
+
+import (
+	"bytes"
+
+	"jay.com/bitcask/internal"
+)
+
+// subscribeBuffer is the number of events a subscriber may fall behind by
+// before further events are dropped
+const subscribeBuffer = 256
+
+// Op is the kind of change of a key
+type Op int
+
+const (
+	// OpPut is a key written
+	OpPut Op = iota + 1
+	// OpDelete is a key deleted or expired
+	OpDelete
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change of Key, sent to the subscribers of a prefix of Key
+type Event struct {
+	Key []byte
+	Op  Op
+}
+
+type subscription struct {
+	prefix []byte
+	events chan Event
+}
+
+// Subscribe returns a channel receiving an Event for every key with the given
+// prefix written or deleted, by any write including transactions, batches and
+// DeleteAll, and a function ending the subscription. Like Scan an empty
+// prefix matches all keys. Writes never wait on subscribers: once a subscriber
+// falls 256 events behind, further events are dropped until it catches up.
+// The channel is closed when the subscription ends or the database is closed.
+func (b *Bitcask) Subscribe(prefix []byte) (<-chan Event, func()) {
+	sub := &subscription{
+		prefix: append([]byte(nil), prefix...),
+		events: make(chan Event, subscribeBuffer),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.events)
+		return sub.events, func() {}
+	}
+	if b.subs == nil {
+		b.subs = make(map[*subscription]struct{})
+	}
+	b.subs[sub] = struct{}{}
+	return sub.events, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[sub]; ok {
+			delete(b.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// publish sends the change of key to the subscribers of its prefixes without
+// blocking, it must be called with b.mu held for writing.
+func (b *Bitcask) publish(key []byte, op Op) {
+	var e *Event
+	for sub := range b.subs {
+		if !bytes.HasPrefix(key, sub.prefix) {
+			continue
+		}
+		// key may be reused by the caller
+		if e == nil {
+			e = &Event{Key: append([]byte(nil), key...), Op: op}
+		}
+		select {
+		case sub.events <- *e:
+		default:
+		}
+	}
+}
+
+// publishDeleteAll sends the deletion of every key in the index to the
+// subscribers, before the index is dropped. It must be called with b.mu held
+// for writing.
+func (b *Bitcask) publishDeleteAll() {
+	if len(b.subs) == 0 {
+		return
+	}
+	b.t.ForEach(func(key []byte, _ internal.Item) bool {
+		b.publish(key, OpDelete)
+		return true
+	})
+}
+
+// closeSubscriptions ends all subscriptions, it must be called with b.mu held
+// for writing.
+func (b *Bitcask) closeSubscriptions() {
+	for sub := range b.subs {
+		close(sub.events)
+	}
+	b.subs = nil
+}
@@ -0,0 +1,150 @@
+package bitcask
+
+import (
+	"fmt"
+	"testing"
+)
+
+// drain returns the events received so far, writes publish before returning
+func drain(events <-chan Event) []string {
+	var got []string
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return append(got, "closed")
+			}
+			got = append(got, fmt.Sprintf("%s %s", e.Op, e.Key))
+		default:
+			return got
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	users, unsubscribe := db.Subscribe([]byte("user/"))
+	defer unsubscribe()
+	all, unsubscribeAll := db.Subscribe(nil)
+	defer unsubscribeAll()
+
+	for _, key := range []string{"user/1", "user/2", "other/1"} {
+		if err := db.Put([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if err := db.Delete([]byte("user/1")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := db.Delete([]byte("other/1")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	// deleting a missing key changes nothing
+	if err := db.Delete([]byte("user/3")); err != nil {
+		t.Fatalf("delete missing key error: %v", err)
+	}
+	if err := db.Rename([]byte("user/2"), []byte("other/2")); err != nil {
+		t.Fatalf("rename error: %v", err)
+	}
+	if err := db.DeleteAll(); err != nil {
+		t.Fatalf("delete all error: %v", err)
+	}
+
+	want := []string{"put user/1", "put user/2", "delete user/1", "delete user/2"}
+	if got := drain(users); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("prefix events, want: %v, got: %v", want, got)
+	}
+	want = []string{"put user/1", "put user/2", "put other/1", "delete user/1", "delete other/1", "put other/2", "delete user/2", "delete other/2"}
+	if got := drain(all); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("all events, want: %v, got: %v", want, got)
+	}
+}
+
+func TestSubscribeTxn(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	events, unsubscribe := db.Subscribe([]byte("a"))
+	defer unsubscribe()
+
+	txn := db.Begin()
+	if err := txn.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("txn put error: %v", err)
+	}
+	if err := txn.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("txn put error: %v", err)
+	}
+	if got := drain(events); len(got) != 0 {
+		t.Errorf("events before commit, want: none, got: %v", got)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("commit error: %v", err)
+	}
+	if got := drain(events); fmt.Sprint(got) != "[put a]" {
+		t.Errorf("events after commit, want: [put a], got: %v", got)
+	}
+	if err := db.DropAll(); err != nil {
+		t.Fatalf("drop all error: %v", err)
+	}
+	if got := drain(events); fmt.Sprint(got) != "[delete a]" {
+		t.Errorf("events after drop all, want: [delete a], got: %v", got)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	events, unsubscribe := db.Subscribe(nil)
+	kept, _ := db.Subscribe(nil)
+
+	unsubscribe()
+	unsubscribe()
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("put error: %v", err)
+	}
+	if got := drain(events); fmt.Sprint(got) != "[closed]" {
+		t.Errorf("events after unsubscribe, want: [closed], got: %v", got)
+	}
+	if len(db.subs) != 1 {
+		t.Errorf("subscriptions, want: 1, got: %d", len(db.subs))
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	if got := drain(kept); fmt.Sprint(got) != "[put key closed]" {
+		t.Errorf("events after close, want: [put key closed], got: %v", got)
+	}
+	closed, _ := db.Subscribe(nil)
+	if _, ok := <-closed; ok {
+		t.Errorf("subscribe after close, want: closed channel, got: open")
+	}
+}
+
+func TestSubscribeSlow(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	events, unsubscribe := db.Subscribe(nil)
+	defer unsubscribe()
+
+	// writes don't wait on a subscriber not reading
+	for i := 0; i < subscribeBuffer+10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("value")); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	if n := len(drain(events)); n != subscribeBuffer {
+		t.Errorf("events, want: the first %d, got: %d", subscribeBuffer, n)
+	}
+}
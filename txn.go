@@ -0,0 +1,200 @@
+package bitcask
+
+import "jay.com/bitcask/internal"
+
+// Txn is a transaction reading a snapshot of the database taken by Begin.
+// Its puts and deletes are buffered, visible to its own reads, and applied
+// atomically by Commit. Commits by others after Begin are never visible, and
+// Commit fails with ErrTxnConflict if one of them wrote a key the transaction
+// writes too. A Txn isn't safe for concurrent use.
+//
+// Open transactions keep Merge from running, so every transaction must end
+// with Commit or Rollback.
+type Txn struct {
+	db *Bitcask
+	// ops holds the writes in the order they were first made, writes the
+	// position in ops of the write of each key as the index holds it
+	ops    []batchOp
+	writes map[string]int
+	// snapshot holds the item of every key committed by others since Begin
+	// as it was at Begin, nil if the key didn't exist. Guarded by db.mu.
+	snapshot map[string]*internal.Item
+	closed   bool
+}
+
+// Begin starts a transaction
+func (b *Bitcask) Begin() *Txn {
+	txn := &Txn{
+		db:       b,
+		writes:   make(map[string]int),
+		snapshot: make(map[string]*internal.Item),
+	}
+	b.mu.Lock()
+	// the transaction of a closed database fails to commit
+	if !b.closed {
+		b.txns[txn] = struct{}{}
+	}
+	b.mu.Unlock()
+	return txn
+}
+
+// Get retrieves the value of key as of Begin, or as written by the
+// transaction itself.
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if txn.closed {
+		return nil, ErrTxnClosed
+	}
+	if i, ok := txn.writes[string(txn.db.indexKey(key))]; ok {
+		if op := txn.ops[i]; op.delete {
+			return nil, ErrKeyNotFound
+		}
+		return txn.ops[i].entry.Value, nil
+	}
+
+	db := txn.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	var item internal.Item
+	if prev, ok := txn.snapshot[string(db.indexKey(key))]; ok {
+		if prev == nil {
+			return nil, ErrKeyNotFound
+		}
+		item = *prev
+	} else {
+		var found bool
+		if item, found = db.t.Search(key); !found {
+			return nil, ErrKeyNotFound
+		}
+	}
+	e, err := db.read(item)
+	if err != nil {
+		return nil, err
+	}
+	if db.expired(e) {
+		return nil, ErrKeyNotFound
+	}
+	return e.Value, nil
+}
+
+// Put stores key and value when the transaction commits
+func (txn *Txn) Put(key, value []byte) error {
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	if err := txn.db.checkKey(key); err != nil {
+		return err
+	}
+	if uint64(len(value)) > txn.db.cfg.MaxValueSize {
+		return ErrValueTooLarge
+	}
+	txn.write(key, batchOp{entry: txn.db.newEntry(key, value)})
+	return nil
+}
+
+// Delete deletes key when the transaction commits
+func (txn *Txn) Delete(key []byte) error {
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	if err := txn.db.checkKey(key); err != nil {
+		return err
+	}
+	txn.write(key, batchOp{entry: txn.db.newTombstone(key), delete: true})
+	return nil
+}
+
+// write buffers op writing key, replacing an earlier write of key in place
+func (txn *Txn) write(key []byte, op batchOp) {
+	k := string(txn.db.indexKey(key))
+	if i, ok := txn.writes[k]; ok {
+		txn.ops[i] = op
+		return
+	}
+	txn.writes[k] = len(txn.ops)
+	txn.ops = append(txn.ops, op)
+}
+
+// Commit writes the transaction, in the order its keys were first written,
+// and applies it to the index under a single lock, like Batch.Commit. The
+// transaction ends whether or not it succeeds.
+func (txn *Txn) Commit() error {
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	db := txn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	txn.end()
+	if db.closed {
+		return ErrClosed
+	}
+	if len(txn.ops) == 0 {
+		return nil
+	}
+	if db.cfg.Readonly {
+		return ErrReadOnlyDatabase
+	}
+	for key := range txn.writes {
+		if _, ok := txn.snapshot[key]; ok {
+			return ErrTxnConflict
+		}
+	}
+
+	entries := make([]internal.Entry, len(txn.ops))
+	for i, op := range txn.ops {
+		entries[i] = op.entry
+	}
+	items, err := db.writeMany(entries)
+	if err != nil {
+		return err
+	}
+	if err := db.maybeSync(); err != nil {
+		return err
+	}
+	for i, op := range txn.ops {
+		if op.delete {
+			db.remove(op.entry.Key)
+			continue
+		}
+		db.insert(op.entry.Key, items[i])
+	}
+	return nil
+}
+
+// Rollback ends the transaction discarding its writes
+func (txn *Txn) Rollback() {
+	if txn.closed {
+		return
+	}
+	txn.db.mu.Lock()
+	txn.end()
+	txn.db.mu.Unlock()
+}
+
+// end closes the transaction, must be called with db.mu held for writing.
+func (txn *Txn) end() {
+	txn.closed = true
+	delete(txn.db.txns, txn)
+}
+
+// record saves the item of key in every open transaction that hasn't seen it
+// change since Begin. It must be called with b.mu held for writing before key
+// is changed in the index.
+func (b *Bitcask) record(key []byte) {
+	if len(b.txns) == 0 {
+		return
+	}
+	var prev *internal.Item
+	if item, found := b.t.Search(key); found {
+		prev = &item
+	}
+	k := string(b.indexKey(key))
+	for txn := range b.txns {
+		if _, ok := txn.snapshot[k]; !ok {
+			txn.snapshot[k] = prev
+		}
+	}
+}
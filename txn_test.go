@@ -0,0 +1,162 @@
+package bitcask
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTxnReadYourWrites(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("2"))
+
+	txn := db.Begin()
+	txn.Put([]byte("a"), []byte("3"))
+	txn.Delete([]byte("b"))
+	txn.Put([]byte("c"), []byte("4"))
+	if got, err := txn.Get([]byte("a")); err != nil || !bytes.Equal(got, []byte("3")) {
+		t.Errorf("txn get a, want: 3, got: %s (%v)", got, err)
+	}
+	if _, err := txn.Get([]byte("b")); err != ErrKeyNotFound {
+		t.Errorf("txn get deleted b, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	if got, err := db.Get([]byte("a")); err != nil || !bytes.Equal(got, []byte("1")) {
+		t.Errorf("get a before commit, want: 1, got: %s (%v)", got, err)
+	}
+	if db.Has([]byte("c")) {
+		t.Errorf("has c before commit, want: false, got: true")
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("commit error: %v", err)
+	}
+	if got, err := db.Get([]byte("a")); err != nil || !bytes.Equal(got, []byte("3")) {
+		t.Errorf("get a after commit, want: 3, got: %s (%v)", got, err)
+	}
+	if db.Has([]byte("b")) || !db.Has([]byte("c")) {
+		t.Errorf("has b/c after commit, want: false/true, got: %v/%v", db.Has([]byte("b")), db.Has([]byte("c")))
+	}
+	if err := txn.Put([]byte("d"), []byte("5")); err != ErrTxnClosed {
+		t.Errorf("put after commit, want: %v, got: %v", ErrTxnClosed, err)
+	}
+}
+
+func TestTxnRollback(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("1"))
+
+	txn := db.Begin()
+	txn.Put([]byte("a"), []byte("2"))
+	txn.Put([]byte("b"), []byte("3"))
+	txn.Rollback()
+	if got, err := db.Get([]byte("a")); err != nil || !bytes.Equal(got, []byte("1")) {
+		t.Errorf("get a after rollback, want: 1, got: %s (%v)", got, err)
+	}
+	if db.Has([]byte("b")) {
+		t.Errorf("has b after rollback, want: false, got: true")
+	}
+	if err := txn.Commit(); err != ErrTxnClosed {
+		t.Errorf("commit after rollback, want: %v, got: %v", ErrTxnClosed, err)
+	}
+	if err := db.Merge(); err != nil {
+		t.Errorf("merge after rollback error: %v", err)
+	}
+}
+
+func TestTxnIsolation(t *testing.T) {
+	db, err := Open(tempDir(t))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("2"))
+
+	txn := db.Begin()
+	db.Put([]byte("a"), []byte("changed"))
+	db.Delete([]byte("b"))
+	db.Put([]byte("c"), []byte("new"))
+	if got, err := txn.Get([]byte("a")); err != nil || !bytes.Equal(got, []byte("1")) {
+		t.Errorf("txn get a, want: 1, got: %s (%v)", got, err)
+	}
+	if got, err := txn.Get([]byte("b")); err != nil || !bytes.Equal(got, []byte("2")) {
+		t.Errorf("txn get b, want: 2, got: %s (%v)", got, err)
+	}
+	if _, err := txn.Get([]byte("c")); err != ErrKeyNotFound {
+		t.Errorf("txn get c, want: %v, got: %v", ErrKeyNotFound, err)
+	}
+	if err := db.Merge(); err != ErrTxnsOpen {
+		t.Errorf("merge with open txn, want: %v, got: %v", ErrTxnsOpen, err)
+	}
+
+	// writing a key committed by others since Begin conflicts
+	txn.Put([]byte("a"), []byte("2"))
+	if err := txn.Commit(); err != ErrTxnConflict {
+		t.Errorf("commit conflicting txn, want: %v, got: %v", ErrTxnConflict, err)
+	}
+	if got, _ := db.Get([]byte("a")); !bytes.Equal(got, []byte("changed")) {
+		t.Errorf("get a after conflict, want: changed, got: %s", got)
+	}
+
+	// writing other keys doesn't
+	txn = db.Begin()
+	db.Put([]byte("a"), []byte("again"))
+	txn.Put([]byte("d"), []byte("4"))
+	if err := txn.Commit(); err != nil {
+		t.Errorf("commit error: %v", err)
+	}
+}
+
+func TestTxnKeyTransform(t *testing.T) {
+	db, err := Open(tempDir(t), WithKeyTransform(bytes.ToLower))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("1"))
+
+	// keys the transform maps to the same index key are the same key
+	txn := db.Begin()
+	txn.Put([]byte("B"), []byte("2"))
+	if got, err := txn.Get([]byte("b")); err != nil || !bytes.Equal(got, []byte("2")) {
+		t.Errorf("txn get b, want: 2, got: %s (%v)", got, err)
+	}
+	txn.Put([]byte("b"), []byte("3"))
+	db.Put([]byte("A"), []byte("changed"))
+	if got, err := txn.Get([]byte("a")); err != nil || !bytes.Equal(got, []byte("1")) {
+		t.Errorf("txn get a, want: 1, got: %s (%v)", got, err)
+	}
+	txn.Put([]byte("a"), []byte("4"))
+	if err := txn.Commit(); err != ErrTxnConflict {
+		t.Errorf("commit conflicting txn, want: %v, got: %v", ErrTxnConflict, err)
+	}
+
+	txn = db.Begin()
+	txn.Put([]byte("B"), []byte("2"))
+	txn.Put([]byte("c"), []byte("5"))
+	txn.Put([]byte("b"), []byte("3"))
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("commit error: %v", err)
+	}
+	if n := db.Len(); n != 3 {
+		t.Errorf("len after commit, want: 3, got: %d", n)
+	}
+	if got, err := db.Get([]byte("B")); err != nil || !bytes.Equal(got, []byte("3")) {
+		t.Errorf("get b, want: 3, got: %s (%v)", got, err)
+	}
+
+	// the writes are stored in the order they were first made
+	b, _ := db.t.Search([]byte("b"))
+	c, _ := db.t.Search([]byte("c"))
+	if b.Offset > c.Offset {
+		t.Errorf("offset of b after c, want: %d < %d", b.Offset, c.Offset)
+	}
+}
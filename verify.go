@@ -0,0 +1,87 @@
+package bitcask
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	"jay.com/bitcask/internal"
+)
+
+// errKeyMismatch is reported by Verify for an index entry pointing at the
+// entry of another key
+var errKeyMismatch = errors.New("error: entry of another key")
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	// Checked is the number of entries read
+	Checked int
+	// Corrupt lists the keys whose entry is corrupt, in key order
+	Corrupt []CorruptEntry
+}
+
+// CorruptEntry is the entry of a key that failed verification
+type CorruptEntry struct {
+	Key    []byte
+	FileID int
+	// Err is why the entry failed, ErrChecksumFailed for a value not matching
+	// its checksum
+	Err error
+}
+
+// Verify reads the entry of every key like Fold does, checking it decodes,
+// belongs to its key and matches its checksum. Unlike Fold it doesn't stop at
+// the first corrupt entry but reports them all, unless the WithOnCorruption
+// handler aborts on an entry failing its checksum. The keys the handler
+// deletes are deleted once all are verified. The database is locked for
+// reading while verifying.
+func (b *Bitcask) Verify() (VerifyReport, error) {
+	var (
+		report  VerifyReport
+		aborted bool
+		deletes [][]byte
+		items   []internal.Item
+	)
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return report, ErrClosed
+	}
+	b.t.ForEach(func(key []byte, item internal.Item) bool {
+		report.Checked++
+		e, err := b.read(item)
+		if err == nil && !bytes.Equal(b.indexKey(e.Key), key) {
+			err = errKeyMismatch
+		}
+		if err == nil {
+			return true
+		}
+		report.Corrupt = append(report.Corrupt, CorruptEntry{
+			Key:    key,
+			FileID: item.FileID,
+			Err:    err,
+		})
+		if err != ErrChecksumFailed {
+			return true
+		}
+		switch onCorruption(b.cfg, key, item.FileID, err, ActionSkip) {
+		case ActionAbort:
+			aborted = true
+			return false
+		case ActionDelete:
+			deletes = append(deletes, key)
+			items = append(items, item)
+		}
+		return true
+	})
+	b.mu.RUnlock()
+
+	if aborted {
+		return report, ErrChecksumFailed
+	}
+	for i, key := range deletes {
+		if err := b.deleteCorrupt(key, items[i]); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,153 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// corruptValue flips the last byte of the value of key on disk
+func corruptValue(t *testing.T, db *Bitcask, key []byte) {
+	t.Helper()
+	item, _ := db.t.Search(key)
+	df, err := db.datafile(item.FileID)
+	if err != nil {
+		t.Fatalf("datafile error: %v", err)
+	}
+	f, err := os.OpenFile(df.Name(), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open datafile error: %v", err)
+	}
+	defer f.Close()
+	// the value ends before the 20 byte trailer
+	if _, err := f.WriteAt([]byte("x"), item.Offset+item.Size-21); err != nil {
+		t.Fatalf("corrupt error: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	db, err := Open(tempDir(t), WithMaxDatafileSize(100))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%05d", i))); err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+	report, err := db.Verify()
+	if err != nil || report.Checked != 10 || len(report.Corrupt) != 0 {
+		t.Fatalf("verify intact database, want: 10 checked, none corrupt, got: %+v (%v)", report, err)
+	}
+
+	corruptValue(t, db, []byte("key-03"))
+	corruptValue(t, db, []byte("key-07"))
+
+	report, err = db.Verify()
+	if err != nil {
+		t.Fatalf("verify error: %v", err)
+	}
+	if report.Checked != 10 || len(report.Corrupt) != 2 {
+		t.Fatalf("verify, want: 10 checked, 2 corrupt, got: %+v", report)
+	}
+	for i, want := range []int{3, 7} {
+		c := report.Corrupt[i]
+		if string(c.Key) != fmt.Sprintf("key-%02d", want) || c.FileID != want/2 || c.Err != ErrChecksumFailed {
+			t.Errorf("corrupt entry, want: key-%02d in datafile %d (%v), got: %s in datafile %d (%v)", want, want/2, ErrChecksumFailed, c.Key, c.FileID, c.Err)
+		}
+	}
+}
+
+func TestOnCorruptionReplay(t *testing.T) {
+	tests := []struct {
+		action  Action
+		openErr error
+		want    string
+		getErr  error
+	}{
+		{action: ActionAbort, openErr: ErrChecksumFailed},
+		{action: ActionSkip, want: "old"},
+		{action: ActionDelete, getErr: ErrKeyNotFound},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.action), func(t *testing.T) {
+			path := tempDir(t)
+			db, err := Open(path)
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			db.Put([]byte("foo"), []byte("old"))
+			db.Put([]byte("foo"), []byte("new"))
+			db.Put([]byte("bar"), []byte("bar"))
+			corruptValue(t, db, []byte("foo"))
+			if err := db.Close(); err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+			// rebuild the index from the datafiles
+			os.Remove(filepath.Join(path, "index"))
+			hints, _ := filepath.Glob(filepath.Join(path, "*.hint"))
+			for _, hint := range hints {
+				os.Remove(hint)
+			}
+
+			var calls []string
+			db, err = Open(path, WithOnCorruption(func(key []byte, fileID int, err error) Action {
+				calls = append(calls, fmt.Sprintf("%s %d %v", key, fileID, err))
+				return test.action
+			}))
+			if want := []string{fmt.Sprintf("foo 0 %v", ErrChecksumFailed)}; fmt.Sprint(calls) != fmt.Sprint(want) {
+				t.Errorf("handler calls, want: %q, got: %q", want, calls)
+			}
+			if errors.Cause(err) != test.openErr {
+				t.Fatalf("open, want: %v, got: %v", test.openErr, err)
+			}
+			if err != nil {
+				return
+			}
+			defer db.Close()
+			got, err := db.Get([]byte("foo"))
+			if err != test.getErr || string(got) != test.want {
+				t.Errorf("get, want: %q (%v), got: %q (%v)", test.want, test.getErr, got, err)
+			}
+			if got, err := db.Get([]byte("bar")); err != nil || string(got) != "bar" {
+				t.Errorf("get intact key, want: bar, got: %q (%v)", got, err)
+			}
+		})
+	}
+}
+
+func TestOnCorruptionGet(t *testing.T) {
+	action := ActionAbort
+	db, err := Open(tempDir(t), WithOnCorruption(func([]byte, int, error) Action {
+		return action
+	}))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer db.Close()
+	db.Put([]byte("foo"), []byte("value"))
+	corruptValue(t, db, []byte("foo"))
+
+	if _, err := db.Get([]byte("foo")); err != ErrChecksumFailed {
+		t.Errorf("get aborting, want: %v, got: %v", ErrChecksumFailed, err)
+	}
+	action = ActionSkip
+	if _, err := db.Get([]byte("foo")); err != ErrKeyNotFound || !db.Has([]byte("foo")) {
+		t.Errorf("get skipping, want: %v and the key kept, got: %v", ErrKeyNotFound, err)
+	}
+	action = ActionAbort
+	if report, err := db.Verify(); err != ErrChecksumFailed || len(report.Corrupt) != 1 {
+		t.Errorf("verify aborting, want: 1 corrupt (%v), got: %+v (%v)", ErrChecksumFailed, report, err)
+	}
+	action = ActionDelete
+	if _, err := db.Get([]byte("foo")); err != ErrKeyNotFound || db.Has([]byte("foo")) {
+		t.Errorf("get deleting, want: %v and the key deleted, got: %v", ErrKeyNotFound, err)
+	}
+	if report, err := db.Verify(); err != nil || len(report.Corrupt) != 0 {
+		t.Errorf("verify after delete, want: none corrupt, got: %+v (%v)", report, err)
+	}
+}